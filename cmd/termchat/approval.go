@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sam/termchat/internal/network"
+)
+
+// approvals holds join requests waiting for the host to /accept or /reject
+// them.
+type approvals struct {
+	mu      sync.Mutex
+	waiting map[string]chan bool
+	allow   map[string]bool
+	output  func(string)
+	backlog []string // notices from before the UI was ready
+}
+
+// newApprovals lets the SSH keys with the given fingerprints in without
+// asking.
+func newApprovals(allow []string) *approvals {
+	a := &approvals{
+		waiting: make(map[string]chan bool),
+		allow:   make(map[string]bool),
+	}
+	for _, fingerprint := range allow {
+		a.allow[fingerprint] = true
+	}
+	return a
+}
+
+// autoApproveWarnings explains which auto_approve entries can never match.
+// Only guests of the embedded SSH server have a key the host checked, so the
+// list does nothing for joiners through a tunnel, a socket or a relay.
+func autoApproveWarnings(allow []string, sshServer bool) []string {
+	var warnings []string
+	for _, entry := range allow {
+		if !strings.HasPrefix(entry, "SHA256:") {
+			warnings = append(warnings, fmt.Sprintf("auto_approve entry %q is not an SSH key fingerprint (SHA256:...) and is ignored", entry))
+		}
+	}
+	if len(allow) > 0 && !sshServer {
+		warnings = append(warnings, "auto_approve only applies to guests of the embedded SSH server (--ssh-server); joiners through a tunnel, a socket or a relay are always asked")
+	}
+	return warnings
+}
+
+// attach starts showing notices through output, including any held back
+// until now.
+func (a *approvals) attach(output func(string)) {
+	a.mu.Lock()
+	backlog := a.backlog
+	a.backlog = nil
+	a.output = output
+	a.mu.Unlock()
+	
+	for _, notice := range backlog {
+		output(notice)
+	}
+}
+
+func (a *approvals) notify(notice string) {
+	a.mu.Lock()
+	output := a.output
+	if output == nil {
+		a.backlog = append(a.backlog, notice)
+	}
+	a.mu.Unlock()
+	
+	if output != nil {
+		output(notice)
+	}
+}
+
+// approve is the server's approver: it lets allowlisted joiners straight in
+// and asks the host about everyone else.
+func (a *approvals) approve(ctx context.Context, req network.JoinRequest) bool {
+	who := req.Name
+	if req.SSHUser != "" {
+		who += " (SSH user " + req.SSHUser + ")"
+	}
+	if req.Key != "" {
+		who += " with key " + req.Key
+	}
+	
+	// The name and SSH user are the joiner's own claims; only a key the
+	// host checked says who they are
+	if req.Key != "" && a.allow[req.Key] {
+		a.notify(fmt.Sprintf("[%s approved automatically]", who))
+		return true
+	}
+	
+	decision := make(chan bool, 1)
+	a.mu.Lock()
+	a.waiting[req.Name] = decision
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.waiting, req.Name)
+		a.mu.Unlock()
+	}()
+	
+	a.notify(fmt.Sprintf("[%s wants to join from %s - /accept %s or /reject %s]", who, req.Addr, req.Name, req.Name))
+	
+	select {
+	case ok := <-decision:
+		return ok
+	case <-ctx.Done():
+		a.notify(fmt.Sprintf("[Join request from %s timed out]", req.Name))
+		return false
+	}
+}
+
+// decide answers a waiting request and returns whose it was. The name may be
+// left out when only one person is waiting.
+func (a *approvals) decide(name string, ok bool) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	
+	if name == "" {
+		switch len(a.waiting) {
+		case 0:
+			return "", fmt.Errorf("nobody is waiting to join")
+		case 1:
+			for n := range a.waiting {
+				name = n
+			}
+		default:
+			names := make([]string, 0, len(a.waiting))
+			for n := range a.waiting {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return "", fmt.Errorf("several people are waiting, say which: %v", names)
+		}
+	}
+	
+	decision, found := a.waiting[name]
+	if !found {
+		return "", fmt.Errorf("%s is not waiting to join", name)
+	}
+	delete(a.waiting, name)
+	decision <- ok
+	return name, nil
+}
\ No newline at end of file
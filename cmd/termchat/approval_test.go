@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sam/termchat/internal/network"
+)
+
+func TestApproveSpoofedNameIsPrompted(t *testing.T) {
+	const alice = "SHA256:2bQ3ZfSl6ObqSjs0lEDcK5rYCL0hHrJBlYtYsRo8vF4"
+	a := newApprovals([]string{alice, "alice"})
+	notices := make(chan string, 8)
+	a.attach(func(notice string) { notices <- notice })
+	
+	// Her key gets her in
+	if !a.approve(context.Background(), network.JoinRequest{Name: "alice", Key: alice}) {
+		t.Fatal("An allowlisted key should be approved without asking")
+	}
+	<-notices
+	
+	// Claiming her name, SSH user or fingerprint does not
+	spoofed := network.JoinRequest{Name: "alice", SSHUser: alice}
+	decided := make(chan bool, 1)
+	go func() { decided <- a.approve(context.Background(), spoofed) }()
+	
+	select {
+	case notice := <-notices:
+		t.Logf("Prompted: %s", notice)
+	case ok := <-decided:
+		t.Fatalf("A spoofed name should be asked about, got %v without asking", ok)
+	case <-time.After(2 * time.Second):
+		t.Fatal("The host was not asked")
+	}
+	if name, err := a.decide("", false); err != nil || name != "alice" {
+		t.Fatalf("Expected alice to be waiting, got %q (%v)", name, err)
+	}
+	if <-decided {
+		t.Error("A rejected joiner should not get in")
+	}
+}
+func TestAutoApproveWarnings(t *testing.T) {
+	const alice = "SHA256:2bQ3ZfSl6ObqSjs0lEDcK5rYCL0hHrJBlYtYsRo8vF4"
+	
+	if warnings := autoApproveWarnings([]string{alice}, true); len(warnings) != 0 {
+		t.Errorf("Expected no warnings with the SSH server on, got %q", warnings)
+	}
+	if warnings := autoApproveWarnings(nil, false); len(warnings) != 0 {
+		t.Errorf("Expected no warnings without auto_approve, got %q", warnings)
+	}
+	
+	// Without the embedded SSH server no joiner has a key to match
+	warnings := autoApproveWarnings([]string{alice}, false)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "--ssh-server") {
+		t.Errorf("Expected a warning about --ssh-server, got %q", warnings)
+	}
+	
+	warnings = autoApproveWarnings([]string{"alice"}, true)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `"alice"`) {
+		t.Errorf("Expected a warning about the name entry, got %q", warnings)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/sam/termchat/internal/network"
+)
+
+// Exit codes, so scripts can tell why termchat gave up. Once released they
+// keep their meaning.
+const (
+	exitError              = 1 // anything not listed below
+	exitUsage              = 2 // bad flags or arguments
+	exitSessionMismatch    = 3 // wrong session ID or secret
+	exitVersionUnsupported = 4 // the other side speaks another major version
+	exitRateLimited        = 5 // locked out after too many wrong guesses
+	exitSessionFull        = 6
+	exitRejected           = 7 // the host said no, or did not answer in time
+	exitTooLarge           = 8 // a message was over the host's limit
+	exitHostKey            = 9 // the host key was unknown and refused, or changed
+)
+
+// exitCode picks the exit code for a failure to join.
+func exitCode(err error) int {
+	var mismatch *network.HostKeyMismatchError
+	var unknown *network.HostKeyUnknownError
+	switch {
+	case errors.Is(err, network.ErrSessionMismatch):
+		return exitSessionMismatch
+	case errors.Is(err, network.ErrVersionUnsupported):
+		return exitVersionUnsupported
+	case errors.Is(err, network.ErrRateLimited):
+		return exitRateLimited
+	case errors.Is(err, network.ErrSessionFull):
+		return exitSessionFull
+	case errors.Is(err, network.ErrRejected):
+		return exitRejected
+	case errors.Is(err, network.ErrTooLarge):
+		return exitTooLarge
+	case errors.As(err, &mismatch), errors.As(err, &unknown):
+		return exitHostKey
+	}
+	return exitError
+}
\ No newline at end of file
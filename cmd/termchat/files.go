@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sam/termchat/internal/config"
+	"github.com/sam/termchat/internal/transfer"
+	"github.com/sam/termchat/internal/ui"
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+// newFileTransfers runs file transfers over send and shows them in chat.
+func newFileTransfers(send func(*protocol.Message) error, chat *ui.SimpleUI, cfg *config.Config) *transfer.Manager {
+	files := transfer.NewManager(send, cfg.Downloads())
+	files.SetCallbacks(
+		func(o transfer.Offer) {
+			chat.AddMessage(fmt.Sprintf("[%s offers %s (%s) - /get %d to save it in %s, /deny %d to refuse]",
+				o.From, o.Name, ui.FormatSize(o.Size), o.Number, files.Dir(), o.Number))
+		},
+		func(t transfer.Transfer) {
+			label := fmt.Sprintf("[Receiving %s from %s]", t.Name, t.Peer)
+			if t.Outgoing {
+				label = fmt.Sprintf("[Sending %s to %s]", t.Name, t.Peer)
+			}
+			chat.SetTransfer(t.Key, label, t.Done, t.Size)
+		},
+		func(t transfer.Transfer, err error) {
+			switch {
+			case err != nil && t.Outgoing:
+				chat.FinishTransfer(t.Key, fmt.Sprintf("[Could not send %s to %s: %v]", t.Name, t.Peer, err), true)
+			case err != nil:
+				chat.FinishTransfer(t.Key, fmt.Sprintf("[Could not receive %s from %s: %v]", t.Name, t.Peer, err), true)
+			case t.Outgoing:
+				chat.FinishTransfer(t.Key, fmt.Sprintf("[Sent %s to %s]", t.Name, t.Peer), false)
+			default:
+				chat.FinishTransfer(t.Key, fmt.Sprintf("[Saved %s from %s as %s]", t.Name, t.Peer, t.Path), false)
+			}
+		},
+	)
+	return files
+}
+
+// fileCommand handles /send, /get and /deny and reports whether cmd was one
+// of them. files is nil where transfers are not allowed.
+func fileCommand(files *transfer.Manager, chat *ui.SimpleUI, cmd, arg string) bool {
+	switch cmd {
+	case "send", "get", "deny":
+	default:
+		return false
+	}
+	if files == nil {
+		chat.AddMessage("[File transfer is not available here]")
+		return true
+	}
+	
+	switch cmd {
+	case "send":
+		if arg == "" {
+			chat.AddMessage("[Usage: /send <path>]")
+			return true
+		}
+		if rest, ok := strings.CutPrefix(arg, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				arg = filepath.Join(home, rest)
+			}
+		}
+		info, err := files.Send(arg)
+		if err != nil {
+			chat.AddMessage(fmt.Sprintf("[Cannot send %s: %v]", arg, err))
+			return true
+		}
+		chat.AddMessage(fmt.Sprintf("[Offered %s (%s) to everyone]", info.Name, ui.FormatSize(info.Size)))
+	case "get":
+		if _, err := files.Accept(arg); err != nil {
+			chat.AddMessage(fmt.Sprintf("[Cannot get the file: %v]", err))
+		}
+	case "deny":
+		o, err := files.Decline(arg)
+		if err != nil {
+			chat.AddMessage(fmt.Sprintf("[Cannot refuse the file: %v]", err))
+			return true
+		}
+		chat.AddMessage(fmt.Sprintf("[Refused %s from %s]", o.Name, o.From))
+	}
+	return true
+}
\ No newline at end of file
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sam/termchat/internal/config"
+	"github.com/sam/termchat/internal/network"
+	"github.com/sam/termchat/internal/session"
+	"github.com/sam/termchat/internal/sshserver"
+	"github.com/sam/termchat/internal/ui"
+	"github.com/sam/termchat/pkg/protocol"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSSHServer lets guests join with a plain ssh client. Each one gets
+// their own client attached to server and a chat drawn on their terminal.
+func startSSHServer(addr string, server *network.Server, cfg *config.Config) (*sshserver.Server, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return nil, err
+	}
+	
+	hostKey, err := sshserver.LoadHostKey(filepath.Join(dir, "ssh_host_ed25519_key"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the SSH host key: %w", err)
+	}
+	keys, err := authorizedKeys(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+	
+	guests := sshserver.New(hostKey, keys, func(term *sshserver.Terminal) {
+		serveGuest(term, server, cfg)
+	})
+	if err := guests.Listen(addr); err != nil {
+		return nil, err
+	}
+	
+	port := guests.Addr().(*net.TCPAddr).Port
+	fmt.Printf("Guests with one of the %d authorized keys can join with:\n", len(keys))
+	fmt.Printf("  ssh -t -p %d host\n", port)
+	fmt.Printf("The SSH host key is %s\n", ssh.FingerprintSHA256(hostKey.PublicKey()))
+	return guests, nil
+}
+
+// authorizedKeys collects who may join over ssh from --authorized-keys, or
+// the default file if it exists, and from ssh_keys in the config.
+func authorizedKeys(dir string, cfg *config.Config) ([]sshserver.AuthorizedKey, error) {
+	paths := sshKeys
+	if len(paths) == 0 {
+		path := filepath.Join(dir, "authorized_keys")
+		if _, err := os.Stat(path); err == nil {
+			paths = []string{path}
+		}
+	}
+	
+	keys, err := sshserver.LoadAuthorizedKeys(paths...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys: %w", err)
+	}
+	if len(cfg.SSHKeys) > 0 {
+		found, err := sshserver.ParseAuthorizedKeys([]byte(strings.Join(cfg.SSHKeys, "\n")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssh_keys in config: %w", err)
+		}
+		keys = append(keys, found...)
+	}
+	
+	if len(keys) == 0 {
+		return nil, errors.New("nobody could join over ssh: pass --authorized-keys or add ssh_keys to the config")
+	}
+	return keys, nil
+}
+
+// guestName picks a display name from the authorized_keys comment of the
+// key the guest logged in with, such as alice for "alice@laptop". The SSH
+// user name is whatever the guest typed, so it is not used.
+func guestName(term *sshserver.Terminal) string {
+	name, _, _ := strings.Cut(term.Comment, "@")
+	if session.ValidateName(name) == nil {
+		return name
+	}
+	return "guest"
+}
+
+func serveGuest(term *sshserver.Terminal, server *network.Server, cfg *config.Config) {
+	sess := session.New()
+	sess.SetName(guestName(term))
+	
+	// A guest's files would be the host's files, so they get no transfers
+	var caps []string
+	for _, c := range capabilities(cfg) {
+		if c != protocol.CapFileTransfer {
+			caps = append(caps, c)
+		}
+	}
+	client := network.NewClient(sess)
+	client.SetCapabilities(caps)
+	client.SetMaxMessageSize(cfg.MaxMessageSize)
+	
+	fmt.Fprint(term, "Joining the session...\r\n")
+	if err := client.ConnectAttached(server, term.Comment, term.Fingerprint); err != nil {
+		fmt.Fprintf(term, "Could not join: %v\r\n", err)
+		return
+	}
+	defer client.Stop()
+	
+	chat, err := ui.NewRemote(term, term.Term, sess.ID)
+	if err != nil {
+		fmt.Fprintf(term, "Cannot draw on this terminal: %v\r\n", err)
+		return
+	}
+	defer chat.Close()
+	chat.SetShowControls(cfg.ShowControlChars)
+	chat.SetName(sess.GetName())
+	
+	done := chatWithClient(client, chat, cfg, false)
+	go chat.Run()
+	<-done
+}
\ No newline at end of file
@@ -1,41 +1,89 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/sam/termchat/internal/config"
 	"github.com/sam/termchat/internal/network"
+	"github.com/sam/termchat/internal/relay"
 	"github.com/sam/termchat/internal/session"
+	"github.com/sam/termchat/internal/sshserver"
+	"github.com/sam/termchat/internal/transfer"
 	"github.com/sam/termchat/internal/ui"
 	"github.com/sam/termchat/pkg/protocol"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 )
 
 var (
-	version = "dev"
-	port    int
+	version   = "dev"
+	port      int
+	maxPeers  int
+	jump      string
+	name      string
+	direct    bool
+	approval  bool
+	customID  string
+	idWords   int
+	secret    bool
+	binds     []string
+	lan       bool
+	socket    bool
+	sshAddr   string
+	sshKeys   []string
+	relayAddr string
+	relayBind string
+	via       string
+	noPunch   bool
 	
 	rootCmd = &cobra.Command{
 		Use:   "termchat",
 		Short: "Serverless P2P terminal chat over SSH",
 		Long: `termchat is a serverless, peer-to-peer terminal chat application that works over SSH.
-It enables secure, ephemeral one-on-one conversations between two developers without any infrastructure requirements.`,
+It enables secure, ephemeral conversations between developers without any infrastructure requirements.`,
 	}
 	
 	startCmd = &cobra.Command{
 		Use:   "start",
-		Short: "Start a new P2P session and wait for connection",
+		Short: "Start a new session and wait for people to join",
 		Run:   startSession,
 	}
 	
 	joinCmd = &cobra.Command{
-		Use:   "join user@host:session-id",
+		Use:   "join [user@]host:session-id | --relay host session-id",
 		Short: "Join a session via SSH tunnel",
-		Args:  cobra.ExactArgs(1),
-		Run:   joinSession,
+		Long: `Join a session via SSH tunnel.
+
+The host may be an alias from ~/.ssh/config; HostName, User, Port,
+IdentityFile, IdentitiesOnly and ProxyJump are honored as they are by ssh.
+Use --jump to reach hosts behind one or more bastions; it overrides any
+ProxyJump from the config ("--jump none" disables it).`,
+		Args: cobra.ExactArgs(1),
+		Run:  joinSession,
+	}
+	
+	relayCmd = &cobra.Command{
+		Use:   "relay",
+		Short: "Run a relay for peers that cannot reach each other",
+		Long: `Run a relay for peers that cannot reach each other.
+
+Hosts and joiners both dial out to the relay with --relay and are paired by
+session. The relay then helps them punch through their NATs over UDP and
+carries the session itself only when that fails. It only ever sees encrypted
+traffic; it never learns the session ID and cannot read or join a chat.`,
+		Args: cobra.NoArgs,
+		Run:  runRelay,
 	}
 	
 	versionCmd = &cobra.Command{
@@ -49,82 +97,314 @@ It enables secure, ephemeral one-on-one conversations between two developers wit
 
 func init() {
 	startCmd.Flags().IntVar(&port, "port", 9999, "Port to listen on")
+	startCmd.Flags().StringSliceVar(&binds, "bind", []string{"127.0.0.1"}, "Addresses to listen on, comma-separated or repeated: IPs, [IPv6] or unix:/path/to/socket")
+	startCmd.Flags().BoolVar(&socket, "socket", false, "Listen on a private Unix socket instead of a TCP port; joiners must SSH in as you")
+	startCmd.Flags().BoolVar(&lan, "lan", false, "Listen on every interface so people on your network can join with --direct")
+	startCmd.Flags().IntVar(&maxPeers, "max-peers", network.DefaultMaxPeers, "Maximum number of people who can join")
+	startCmd.Flags().StringVar(&name, "name", "", "Display name (default $USER)")
+	startCmd.Flags().StringVar(&customID, "session-id", "", "Use your own session ID instead of a generated one (must be hard to guess)")
+	startCmd.Flags().IntVar(&idWords, "id-words", session.DefaultIDWords, "Number of words in a generated session ID (7 bits each)")
+	startCmd.Flags().BoolVar(&secret, "secret", false, "Add a random 128-bit secret to the join string")
+	startCmd.Flags().BoolVar(&approval, "require-approval", false, "Ask before letting each person join (see auto_approve in the config)")
+	startCmd.Flags().StringVar(&sshAddr, "ssh-server", "", "Also let guests join with plain ssh on this address, e.g. :2222")
+	startCmd.Flags().StringSliceVar(&sshKeys, "authorized-keys", nil, "authorized_keys files listing who may join with ssh (default ~/.config/termchat/authorized_keys)")
+	startCmd.Flags().StringVar(&relayAddr, "relay", "", "Also host the session at this relay (host[:port]) for people who cannot reach you")
+	startCmd.Flags().StringVar(&via, "via", "", "Host through a shared SSH server you and your joiners can both reach: user@shared-host[:port]")
+	joinCmd.Flags().StringVar(&relayAddr, "relay", "", "Join through this relay (host[:port]); the session ID alone is enough")
+	relayCmd.Flags().StringVar(&relayBind, "listen", fmt.Sprintf(":%d", relay.DefaultPort), "Address to listen on")
+	relayCmd.Flags().BoolVar(&noPunch, "no-punch", false, "Carry every session instead of helping peers connect directly, so they never learn each other's address")
+	joinCmd.Flags().StringVar(&name, "name", "", "Display name (default your SSH username)")
+	joinCmd.Flags().StringVarP(&jump, "jump", "J", "", "Connect through jump hosts: user@bastion[:port][,user@bastion2] (like ssh -J)")
+	joinCmd.Flags().BoolVar(&direct, "direct", false, "Connect straight to the host's termchat port instead of tunnelling through SSH")
 	
 	rootCmd.AddCommand(startCmd)
 	rootCmd.AddCommand(joinCmd)
+	rootCmd.AddCommand(relayCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
 func main() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 }
 
 func startSession(cmd *cobra.Command, args []string) {
+	if maxPeers < 1 {
+		fmt.Fprintln(os.Stderr, "Error: --max-peers must be at least 1")
+		os.Exit(exitUsage)
+	}
+	
+	if idWords < 1 || idWords > session.MaxIDWords {
+		fmt.Fprintf(os.Stderr, "Error: --id-words must be between 1 and %d\n", session.MaxIDWords)
+		os.Exit(exitUsage)
+	}
+	
 	sess := session.New()
+	if name != "" {
+		if err := session.ValidateName(name); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --name: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		sess.SetName(name)
+	}
 	
-	fmt.Printf("Session started: %s\n", sess.ID)
-	fmt.Printf("Listening on port %d\n", port)
-	fmt.Println()
-	fmt.Println("Share this with your chat partner:")
-	fmt.Printf("  termchat join user@host:%s", sess.ID)
-	if port != 9999 {
-		fmt.Printf(":%d", port)
+	if secret {
+		sess.Secret = session.GenerateSecret()
+	}
+	switch {
+	case customID != "":
+		// A secret carries the entropy, so any valid ID will do
+		check := session.CheckIDStrength
+		if secret {
+			check = session.ValidateSessionID
+		}
+		if err := check(customID); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --session-id: %v\n", err)
+			if !secret {
+				fmt.Fprintln(os.Stderr, "Or keep it short and add --secret.")
+			}
+			os.Exit(exitUsage)
+		}
+		sess.ID = customID
+	case idWords != session.DefaultIDWords:
+		sess.ID = session.GenerateSessionIDWords(idWords)
+	}
+	
+	if !secret && session.IDStrength(sess.ID) < session.MinIDBits {
+		fmt.Fprintf(os.Stderr, "Warning: this session ID is easy to guess (about %.0f bits); consider --secret\n", session.IDStrength(sess.ID))
 	}
-	fmt.Println()
-	fmt.Println()
-	fmt.Println("Waiting for connection...")
+	
+	if lan {
+		if cmd.Flags().Changed("bind") {
+			fmt.Fprintln(os.Stderr, "Error: use either --lan or --bind")
+			os.Exit(exitUsage)
+		}
+		binds = []string{""}
+	}
+	var viaInfo *network.ConnectionInfo
+	if via != "" {
+		if socket {
+			fmt.Fprintln(os.Stderr, "Error: use either --via or --socket")
+			os.Exit(exitUsage)
+		}
+		var err error
+		if viaInfo, err = network.ParseSSHHost(via); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --via: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		
+		// The forwarded port replaces the default loopback port
+		if !lan && !cmd.Flags().Changed("bind") {
+			binds = nil
+		}
+	}
+	var addrs []string
+	if socket {
+		dir, err := network.SocketDir()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot create the session socket: %v\n", err)
+			os.Exit(exitError)
+		}
+		addrs = append(addrs, "unix:"+network.SocketPath(dir, sess.ID))
+		
+		// The socket replaces the default loopback port
+		if !lan && !cmd.Flags().Changed("bind") {
+			binds = nil
+		}
+	}
+	exposed := false
+	for _, bind := range binds {
+		addr := network.BindAddress(bind, port)
+		addrs = append(addrs, addr)
+		if !network.IsLoopback(addr) {
+			exposed = true
+		}
+	}
+	if relayAddr != "" {
+		relayAddr = relayAddress(relayAddr)
+		addrs = append(addrs, "relay:"+relayAddr)
+	}
+	
+	cfg := loadConfig()
 	
 	server := network.NewServer(sess)
+	server.SetMaxPeers(maxPeers)
+	server.SetCapabilities(capabilities(cfg))
+	server.SetMaxMessageSize(cfg.MaxMessageSize)
 	
-	if err := server.Start(port); err != nil {
+	// Set before Start so nobody slips in ahead of the UI
+	var pending *approvals
+	if approval {
+		for _, warning := range autoApproveWarnings(cfg.AutoApprove, sshAddr != "") {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+		pending = newApprovals(cfg.AutoApprove)
+		server.SetApprover(pending.approve)
+	}
+	
+	if err := server.Listen(addrs...); err != nil {
 		if strings.Contains(err.Error(), "address already in use") {
 			fmt.Fprintf(os.Stderr, "Error: Port %d is already in use.\n", port)
 			fmt.Fprintf(os.Stderr, "Try: lsof -ti:%d | xargs kill -9\n", port)
 			fmt.Fprintf(os.Stderr, "Or use a different port: termchat start --port 9998\n")
+			fmt.Fprintf(os.Stderr, "Or avoid ports altogether: termchat start --socket\n")
 		} else {
 			fmt.Fprintf(os.Stderr, "Failed to start server: %v\n", err)
 		}
-		os.Exit(1)
+		os.Exit(exitError)
+	}
+	
+	var viaPort int
+	if viaInfo != nil {
+		remotePort := 0
+		if cmd.Flags().Changed("port") {
+			remotePort = port
+		}
+		fmt.Printf("Connecting to %s...\n", viaInfo.Host)
+		var err error
+		if viaPort, err = server.ListenVia(viaInfo, remotePort, confirmHostKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to host via %s: %v\n", viaInfo.Host, err)
+			server.Stop()
+			os.Exit(exitError)
+		}
+	}
+	
+	fmt.Printf("Session started: %s\n", sess.ID)
+	if viaInfo != nil {
+		addrs = append(addrs, fmt.Sprintf("port %d on %s", viaPort, viaInfo.Host))
+	}
+	fmt.Printf("Listening on %s\n", strings.Join(addrs, ", "))
+	if exposed {
+		fmt.Println("This session is reachable from the network; anyone who has the join string can connect directly.")
+	}
+	fmt.Println()
+	fmt.Println("Share this with the people you want to chat with:")
+	switch {
+	case viaInfo != nil:
+		fmt.Printf("  termchat join user@%s:%s:%d\n", viaJoinHost(via), sess.Credential(), viaPort)
+	case socket:
+		fmt.Printf("  termchat join user@host:%s:socket\n", sess.Credential())
+	case port != 9999:
+		fmt.Printf("  termchat join user@host:%s:%d\n", sess.Credential(), port)
+	default:
+		fmt.Printf("  termchat join user@host:%s\n", sess.Credential())
+	}
+	if relayAddr != "" {
+		fmt.Println("or, from anywhere:")
+		fmt.Printf("  termchat join --relay %s %s\n", relayAddr, sess.Credential())
+	}
+	fmt.Println()
+	fmt.Printf("Waiting for up to %d people to join...\n", maxPeers)
+	if approval {
+		fmt.Println("You will be asked to /accept or /reject each of them.")
+	}
+	
+	var guests *sshserver.Server
+	if sshAddr != "" {
+		var err error
+		if guests, err = startSSHServer(sshAddr, server, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			server.Stop()
+			os.Exit(exitError)
+		}
 	}
 	
 	ui, err := ui.NewSimple(sess.ID)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize UI: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitError)
 	}
 	defer ui.Close()
+	ui.SetShowControls(cfg.ShowControlChars)
 	
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	
 	stopChan := make(chan struct{})
 	
+	ui.SetName(sess.GetName())
+	ui.SetRoster(server.Roster())
+	files := newFileTransfers(server.SendMessage, ui, cfg)
+	
 	server.SetCallbacks(
 		func(msg protocol.Message) {
+			if files.Handle(msg) {
+				return
+			}
 			ui.DisplayMessage(msg)
 		},
-		func() {
-			ui.AddMessage("[Connected]")
+		func(name string) {
+			ui.AddMessage(fmt.Sprintf("[%s joined]", name))
+			ui.SetRoster(server.Roster())
+			ui.SetVerificationCode(name, server.SAS(name))
 		},
-		func() {
-			ui.AddMessage("[Disconnected]")
-			close(stopChan)
+		func(name string) {
+			ui.AddMessage(fmt.Sprintf("[%s left]", name))
+			ui.SetRoster(server.Roster())
+			ui.ClearVerification(name)
+			files.PeerLeft(name)
 		},
 	)
 	
+	server.SetRejectCallback(func(addr net.Addr, reason string) {
+		ui.AddMessage(fmt.Sprintf("[Turned away connection from %s: %s]", addr, reason))
+	})
+	if guests != nil {
+		guests.SetRejectCallback(func(addr net.Addr, reason string) {
+			ui.AddMessage(fmt.Sprintf("[Turned away SSH guest from %s: %s]", addr, reason))
+		})
+	}
+	
 	ui.SetCallbacks(
-		func(text string) {
-			msg := protocol.NewMessage(protocol.MessageTypeText, text)
-			server.SendMessage(msg)
+		func(msg *protocol.Message) error {
+			return server.SendMessage(msg)
 		},
 		func() {
 			close(stopChan)
 		},
 	)
 	
+	server.SetStatusCallback(ui.SetStatus)
+	ui.SetReadCallback(func(id string) {
+		server.SendMessage(protocol.NewReceipt(protocol.MessageTypeRead, id))
+	})
+	
+	if cfg.TypingIndicators {
+		ui.SetTypingCallback(func(active bool) {
+			server.SendMessage(protocol.NewMessage(protocol.MessageTypeTyping, strconv.FormatBool(active)))
+		})
+	}
+	
+	if pending != nil {
+		pending.attach(ui.AddMessage)
+	}
+	
+	ui.SetCommandHandler(func(cmd, arg string) {
+		switch cmd {
+		case "nick":
+			if err := server.SetName(arg); err != nil {
+				ui.AddMessage(fmt.Sprintf("[Cannot change name: %v]", err))
+			}
+		case "accept", "reject":
+			if pending == nil {
+				ui.AddMessage("[Join approval is off; start with --require-approval]")
+				return
+			}
+			who, err := pending.decide(arg, cmd == "accept")
+			if err != nil {
+				ui.AddMessage(fmt.Sprintf("[Cannot %s: %v]", cmd, err))
+			} else if cmd == "reject" {
+				ui.AddMessage(fmt.Sprintf("[Rejected %s]", who))
+			}
+		default:
+			if !fileCommand(files, ui, cmd, arg) {
+				ui.AddMessage(fmt.Sprintf("[Unknown command: /%s]", cmd))
+			}
+		}
+	})
+	
 	go ui.Run()
 	
 	select {
@@ -133,81 +413,308 @@ func startSession(cmd *cobra.Command, args []string) {
 	case <-stopChan:
 	}
 	
+	if guests != nil {
+		guests.Stop()
+	}
+	files.Stop()
 	server.Stop()
 }
 
 func joinSession(cmd *cobra.Command, args []string) {
-	connInfo, err := network.ParseConnectionString(args[0])
-	if err != nil {
+	var connInfo *network.ConnectionInfo
+	var err error
+	if relayAddr != "" && !strings.Contains(args[0], ":") {
+		// Through a relay the session ID is all we need
+		id, secret, _ := strings.Cut(args[0], "+")
+		connInfo = &network.ConnectionInfo{SessionID: id, Secret: secret}
+	} else if connInfo, err = network.ParseConnectionString(args[0]); err != nil {
 		fmt.Fprintf(os.Stderr, "Invalid connection string: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+	
+	if jump != "" {
+		connInfo.ProxyJump = jump
+	}
+	
+	if relayAddr == "" {
+		if err := connInfo.ApplySSHConfig(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read SSH config: %v\n", err)
+			os.Exit(exitError)
+		}
 	}
 	
 	sess := session.New()
 	sess.ID = connInfo.SessionID
+	sess.Secret = connInfo.Secret
+	switch {
+	case name != "":
+		if err := session.ValidateName(name); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid --name: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		sess.SetName(name)
+	case session.ValidateName(connInfo.User) == nil:
+		sess.SetName(connInfo.User)
+	}
 	
-	fmt.Printf("Connecting via SSH to %s@%s...\n", connInfo.User, connInfo.Host)
+	isLocal := connInfo.Host == "localhost" || connInfo.Host == "127.0.0.1"
+	if relayAddr != "" {
+		relayAddr = relayAddress(relayAddr)
+		fmt.Printf("Connecting through the relay at %s...\n", relayAddr)
+	} else if direct || isLocal {
+		fmt.Printf("Connecting directly to %s:%d...\n", connInfo.Host, connInfo.Port)
+	} else {
+		fmt.Printf("Connecting via SSH to %s@%s...\n", connInfo.User, connInfo.Host)
+	}
 	
-	client := network.NewClient(sess)
+	cfg := loadConfig()
 	
-	isLocal := connInfo.Host == "localhost" || connInfo.Host == "127.0.0.1"
+	client := network.NewClient(sess)
+	client.SetHostKeyPrompt(confirmHostKey)
+	client.SetCapabilities(capabilities(cfg))
+	client.SetMaxMessageSize(cfg.MaxMessageSize)
 	
-	if isLocal {
-		err = client.ConnectLocal(fmt.Sprintf("localhost:%d", connInfo.Port), connInfo.SessionID)
-	} else {
+	switch {
+	case relayAddr != "":
+		err = client.ConnectRelay(relayAddr, connInfo.SessionID)
+	case connInfo.Socket && direct:
+		fmt.Fprintln(os.Stderr, "A session on a Unix socket can only be joined through SSH, not --direct")
+		os.Exit(exitUsage)
+	case connInfo.Socket && isLocal:
+		dir, dirErr := network.SocketDir()
+		if dirErr != nil {
+			fmt.Fprintf(os.Stderr, "Cannot find the session socket: %v\n", dirErr)
+			os.Exit(exitError)
+		}
+		err = client.ConnectLocal("unix:"+network.SocketPath(dir, connInfo.SessionID), connInfo.SessionID)
+	case direct || isLocal:
+		err = client.ConnectLocal(net.JoinHostPort(connInfo.Host, strconv.Itoa(connInfo.Port)), connInfo.SessionID)
+	default:
 		err = client.ConnectViaSSH(connInfo)
 	}
 	
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to connect: %v\n", err)
-		os.Exit(1)
+		var mismatch *network.HostKeyMismatchError
+		var jumpErr *network.JumpError
+		if errors.As(err, &mismatch) {
+			fmt.Fprintln(os.Stderr, mismatch.Error())
+			if errors.As(err, &jumpErr) && jumpErr.Total > 1 && jumpErr.Hop < jumpErr.Total {
+				fmt.Fprintf(os.Stderr, "(at jump host %d/%d %s)\n", jumpErr.Hop, jumpErr.Total-1, jumpErr.Addr)
+			}
+		} else {
+			// May quote the host
+			fmt.Fprintf(os.Stderr, "Failed to connect: %s\n", ui.Sanitize(err.Error(), false))
+		}
+		
+		var versionErr *protocol.VersionError
+		if errors.As(err, &versionErr) {
+			fmt.Fprintln(os.Stderr, "Both sides need a termchat release with the same major protocol version.")
+		}
+		os.Exit(exitCode(err))
 	}
 	
+	if addr := client.RemoteAddr(); addr != nil && relayAddr != "" {
+		if addr.Network() == "udp" {
+			fmt.Println("Punched through to the host; the relay is out of the loop.")
+		} else {
+			fmt.Println("Could not reach the host directly; the relay is carrying the session.")
+		}
+	}
 	fmt.Println("Connected! Type your messages below.")
 	fmt.Println()
 	
 	ui, err := ui.NewSimple(sess.ID)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize UI: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitError)
 	}
 	defer ui.Close()
+	ui.SetShowControls(cfg.ShowControlChars)
+	ui.SetName(sess.GetName())
+	ui.SetVerificationCode(client.HostName(), client.SAS())
 	
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	
-	stopChan := make(chan struct{})
+	done := chatWithClient(client, ui, cfg, true)
+	go ui.Run()
+	
+	select {
+	case <-sigChan:
+		fmt.Println("\nShutting down...")
+	case <-done:
+	}
+	
+	client.Stop()
+}
+
+// chatWithClient connects a chat UI to a client. The returned channel closes
+// when the user quits or the session ends. Files can be sent and received
+// only if allowFiles is set.
+func chatWithClient(client *network.Client, chat *ui.SimpleUI, cfg *config.Config, allowFiles bool) <-chan struct{} {
+	done := make(chan struct{})
+	var once sync.Once
+	var files *transfer.Manager
+	stop := func() {
+		once.Do(func() {
+			if files != nil {
+				files.Stop()
+			}
+			close(done)
+		})
+	}
+	if allowFiles {
+		files = newFileTransfers(client.SendMessage, chat, cfg)
+	}
 	
 	client.SetCallbacks(
 		func(msg protocol.Message) {
-			ui.DisplayMessage(msg)
+			if files != nil && files.Handle(msg) {
+				return
+			}
+			chat.DisplayMessage(msg)
 		},
 		func() {
-			ui.AddMessage("[Connected to session]")
+			chat.AddMessage("[Connected to session]")
+			chat.SetVerificationCode(client.HostName(), client.SAS())
+			// Ask again for whatever was lost while we were away
+			if files != nil {
+				files.Resume("")
+			}
 		},
 		func() {
-			ui.AddMessage("[Disconnected]")
-			close(stopChan)
+			chat.AddMessage("[Disconnected]")
+			stop()
 		},
 	)
+	client.SetReconnectingCallback(func() {
+		chat.AddMessage("[Connection lost - reconnecting...]")
+	})
 	
-	ui.SetCallbacks(
-		func(text string) {
-			msg := protocol.NewMessage(protocol.MessageTypeText, text)
-			client.SendMessage(msg)
-		},
-		func() {
-			close(stopChan)
+	chat.SetCallbacks(
+		func(msg *protocol.Message) error {
+			return client.SendMessage(msg)
 		},
+		stop,
 	)
 	
-	go ui.Run()
+	client.SetStatusCallback(chat.SetStatus)
+	chat.SetReadCallback(func(id string) {
+		client.SendMessage(protocol.NewReceipt(protocol.MessageTypeRead, id))
+	})
 	
-	select {
-	case <-sigChan:
-		fmt.Println("\nShutting down...")
-	case <-stopChan:
+	if cfg.TypingIndicators {
+		chat.SetTypingCallback(func(active bool) {
+			client.SendMessage(protocol.NewMessage(protocol.MessageTypeTyping, strconv.FormatBool(active)))
+		})
 	}
 	
-	client.Stop()
+	chat.SetCommandHandler(func(cmd, arg string) {
+		switch cmd {
+		case "nick":
+			if err := client.SetName(arg); err != nil {
+				chat.AddMessage(fmt.Sprintf("[Cannot change name: %v]", err))
+			}
+		default:
+			if !fileCommand(files, chat, cmd, arg) {
+				chat.AddMessage(fmt.Sprintf("[Unknown command: /%s]", cmd))
+			}
+		}
+	})
+	return done
+}
+
+func runRelay(cmd *cobra.Command, args []string) {
+	server := relay.NewServer()
+	if noPunch {
+		server.DisablePunching()
+	}
+	server.SetEventCallback(func(event string) {
+		fmt.Printf("%s %s\n", time.Now().Format(time.TimeOnly), event)
+	})
+	if err := server.Listen(relayBind); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitError)
+	}
+	fmt.Printf("Relay listening on %s\n", server.Addr())
+	
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	<-sigChan
+	server.Stop()
+}
+
+// viaJoinHost is the shared server as joiners should name it: the --via
+// value without our user name or SSH port, since they log in as themselves.
+func viaJoinHost(spec string) string {
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		spec = spec[at+1:]
+	}
+	if host, _, err := net.SplitHostPort(spec); err == nil {
+		return host
+	}
+	return spec
+}
+
+// relayAddress adds the default port to a --relay value that has none.
+func relayAddress(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(relay.DefaultPort))
+}
+
+func loadConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v (using defaults)\n", err)
+		return config.Default()
+	}
+	return cfg
+}
+
+// capabilities lists the optional protocol features the user has not
+// switched off.
+func capabilities(cfg *config.Config) []string {
+	var caps []string
+	for _, c := range protocol.SupportedCapabilities() {
+		if c == protocol.CapTyping && !cfg.TypingIndicators {
+			continue
+		}
+		caps = append(caps, c)
+	}
+	return caps
+}
+
+func confirmHostKey(host string, remote net.Addr, key ssh.PublicKey) bool {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+	
+	if h, port, err := net.SplitHostPort(host); err == nil && port == "22" {
+		host = h
+	}
+	
+	fmt.Printf("The authenticity of host '%s (%s)' can't be established.\n", host, remote)
+	fmt.Printf("%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
+	fmt.Print("Are you sure you want to continue connecting (yes/no)? ")
+	
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			return false
+		}
+		
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "yes":
+			fmt.Printf("Warning: Permanently added '%s' to the list of known hosts.\n", host)
+			return true
+		case "no":
+			return false
+		default:
+			fmt.Print("Please type 'yes' or 'no': ")
+		}
+	}
 }
\ No newline at end of file
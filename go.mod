@@ -2,17 +2,21 @@ module github.com/sam/termchat
 
 go 1.24.5
 
+require (
+	github.com/flynn/noise v1.1.0
+	github.com/gdamore/tcell/v2 v2.8.1
+	github.com/spf13/cobra v1.9.1
+	golang.org/x/crypto v0.40.0
+	golang.org/x/term v0.33.0
+)
+
 require (
 	github.com/gdamore/encoding v1.0.1 // indirect
-	github.com/gdamore/tcell/v2 v2.8.1 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-runewidth v0.0.16 // indirect
 	github.com/rivo/uniseg v0.4.3 // indirect
-	github.com/spf13/cobra v1.9.1 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
-	golang.org/x/crypto v0.40.0 // indirect
 	golang.org/x/sys v0.34.0 // indirect
-	golang.org/x/term v0.33.0 // indirect
 	golang.org/x/text v0.27.0 // indirect
 )
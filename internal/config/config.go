@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config holds user preferences read from
+// $XDG_CONFIG_HOME/termchat/config.json (~/.config/termchat/config.json).
+// Any setting missing from the file keeps its default.
+type Config struct {
+	// TypingIndicators sends and shows "is typing…" notices
+	TypingIndicators bool `json:"typing_indicators"`
+	
+	// AutoApprove lets guests of the embedded SSH server whose keys have
+	// these fingerprints (SHA256:...) join without asking when the host runs
+	// with --require-approval
+	AutoApprove []string `json:"auto_approve"`
+	
+	// SSHKeys are public keys, in authorized_keys format, that may join
+	// through the embedded SSH server (start --ssh-server)
+	SSHKeys []string `json:"ssh_keys"`
+	
+	// DownloadDir is where files others send are saved; see Downloads
+	DownloadDir string `json:"download_dir"`
+	
+	// MaxMessageSize is the largest message, in bytes as sent, accepted from
+	// others; 0 keeps the built-in limit of 1 MiB
+	MaxMessageSize int `json:"max_message_size"`
+	
+	// ShowControlChars shows control characters in what others send as ^[
+	// and the like, rather than removing them
+	ShowControlChars bool `json:"show_control_chars"`
+}
+
+func Default() *Config {
+	return &Config{
+		TypingIndicators: true,
+	}
+}
+
+// Dir is where termchat keeps its config and keys.
+func Dir() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "termchat"), nil
+}
+
+// Downloads is where received files go: download_dir, with a leading ~/
+// meaning the home directory, or else ~/Downloads if it exists, or else the
+// current directory.
+func (c *Config) Downloads() string {
+	home, _ := os.UserHomeDir()
+	if c.DownloadDir != "" {
+		if rest, ok := strings.CutPrefix(c.DownloadDir, "~/"); ok && home != "" {
+			return filepath.Join(home, rest)
+		}
+		return c.DownloadDir
+	}
+	
+	if home != "" {
+		dir := filepath.Join(home, "Downloads")
+		if stat, err := os.Stat(dir); err == nil && stat.IsDir() {
+			return dir
+		}
+	}
+	return "."
+}
+
+func Path() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+// Load reads the config file, returning the defaults if it does not exist.
+func Load() (*Config, error) {
+	cfg := Default()
+	
+	path, err := Path()
+	if err != nil {
+		return cfg, nil
+	}
+	
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
\ No newline at end of file
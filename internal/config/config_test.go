@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	
+	if !cfg.TypingIndicators {
+		t.Error("Typing indicators should be on by default")
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	
+	writeConfig(t, dir, `{"typing_indicators": false, "auto_approve": ["alice", "bob"], "ssh_keys": ["ssh-ed25519 AAAA carol"]}`)
+	
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	
+	if cfg.TypingIndicators {
+		t.Error("Expected typing indicators to be disabled")
+	}
+	if len(cfg.AutoApprove) != 2 || cfg.AutoApprove[1] != "bob" {
+		t.Errorf("Unexpected auto_approve list: %v", cfg.AutoApprove)
+	}
+	if len(cfg.SSHKeys) != 1 {
+		t.Errorf("Unexpected ssh_keys list: %v", cfg.SSHKeys)
+	}
+}
+
+func TestDownloads(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	
+	cfg := Default()
+	if got := cfg.Downloads(); got != "." {
+		t.Errorf("Without ~/Downloads files should go in the current directory, got %s", got)
+	}
+	
+	os.Mkdir(filepath.Join(home, "Downloads"), 0700)
+	if got := cfg.Downloads(); got != filepath.Join(home, "Downloads") {
+		t.Errorf("Expected ~/Downloads, got %s", got)
+	}
+	
+	cfg.DownloadDir = "~/inbox"
+	if got := cfg.Downloads(); got != filepath.Join(home, "inbox") {
+		t.Errorf("Expected ~/inbox, got %s", got)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	
+	writeConfig(t, dir, `{"typing_indicators": `)
+	
+	if _, err := Load(); err == nil {
+		t.Error("Expected error for malformed config")
+	}
+}
+
+func writeConfig(t *testing.T, dir, contents string) {
+	t.Helper()
+	path := filepath.Join(dir, "termchat", "config.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sam/termchat/internal/session"
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+func TestConnectAttached(t *testing.T) {
+	requests := make(chan JoinRequest, 1)
+	server := startTestServer(t, 5, func(s *Server) {
+		s.session.Secret = "abcdefgh"
+		s.SetApprover(func(ctx context.Context, req JoinRequest) bool {
+			requests <- req
+			return true
+		})
+	})
+	received := make(chan protocol.Message, 64)
+	sess := session.New()
+	sess.SetName("guest")
+	guest := NewClient(sess)
+	guest.SetCallbacks(func(msg protocol.Message) { received <- msg }, nil, nil)
+	if err := guest.ConnectAttached(server, "alice", "SHA256:alice"); err != nil {
+		t.Fatalf("ConnectAttached failed: %v", err)
+	}
+	defer guest.Stop()
+	
+	if req := <-requests; req.Name != "guest" || req.SSHUser != "alice" || req.Key != "SHA256:alice" {
+		t.Errorf("Unexpected join request %+v", req)
+	}
+	
+	waitFor(t, received, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 2
+	})
+	server.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "welcome"))
+	waitFor(t, received, isText("welcome"))
+	
+	// The host vouched for the guest, so there is nothing to compare
+	if code := guest.SAS(); code != "" {
+		t.Errorf("Attached client should have no verification code, got %q", code)
+	}
+	if code := server.SAS("guest"); code != "" {
+		t.Errorf("Attached peer should have no verification code, got %q", code)
+	}
+}
+
+func TestAttachedBothEndsSending(t *testing.T) {
+	// Like the UI, each side sends while holding the lock that its own
+	// message callback takes
+	var hostMu, guestMu sync.Mutex
+	var hostGot, guestGot atomic.Int32
+	server := startTestServer(t, 5, func(s *Server) {
+		s.SetCallbacks(func(msg protocol.Message) {
+			if msg.Type == protocol.MessageTypeText {
+				hostMu.Lock()
+				hostGot.Add(1)
+				hostMu.Unlock()
+			}
+		}, nil, nil)
+	})
+	sess := session.New()
+	sess.SetName("guest")
+	guest := NewClient(sess)
+	guest.SetCallbacks(func(msg protocol.Message) {
+		if msg.Type == protocol.MessageTypeText {
+			guestMu.Lock()
+			guestGot.Add(1)
+			guestMu.Unlock()
+		}
+	}, nil, nil)
+	if err := guest.ConnectAttached(server, "alice", ""); err != nil {
+		t.Fatalf("ConnectAttached failed: %v", err)
+	}
+	defer guest.Stop()
+	for len(server.Roster()) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	
+	const n = 500
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			hostMu.Lock()
+			server.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "from the host"))
+			hostMu.Unlock()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			guestMu.Lock()
+			guest.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "from the guest"))
+			guestMu.Unlock()
+		}
+	}()
+	
+	sent := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Both ends sending at once locked up")
+	}
+	
+	deadline := time.Now().Add(5 * time.Second)
+	for hostGot.Load() < n || guestGot.Load() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Host got %d and guest got %d of %d messages", hostGot.Load(), guestGot.Load(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPipeBuffersUpToLimit(t *testing.T) {
+	a, b := newPipe()
+	defer a.Close()
+	defer b.Close()
+	
+	// A reader that has stopped cannot make the writer buffer without end
+	a.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	chunk := make([]byte, 64<<10)
+	written := 0
+	var err error
+	for written <= 2*pipeBufferSize && err == nil {
+		var n int
+		n, err = a.Write(chunk)
+		written += n
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) || written > pipeBufferSize {
+		t.Fatalf("Expected the write to time out after %d bytes, got %v after %d", pipeBufferSize, err, written)
+	}
+	
+	// Reading makes room again
+	if _, err := io.ReadFull(b, chunk); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	a.SetWriteDeadline(time.Time{})
+	if _, err := a.Write(chunk); err != nil {
+		t.Errorf("Expected room for another write, got %v", err)
+	}
+}
\ No newline at end of file
@@ -1,12 +1,14 @@
 package network
 
 import (
-	"encoding/json"
+	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/sam/termchat/internal/session"
 	"github.com/sam/termchat/pkg/protocol"
@@ -16,26 +18,67 @@ import (
 type Client struct {
 	session     *session.Session
 	conn        net.Conn
-	encoder     *json.Encoder
-	decoder     *json.Decoder
+	encoder     protocol.Encoder
+	decoder     protocol.Decoder
 	sshClient   *ssh.Client
+	jumpClients []*ssh.Client
+	offered     []string
+	caps        []string
+	codecs      []string // wire codecs we offer, preferred first
+	maxFrame    int      // largest message we accept from the host
+	receipts    *receiptTracker
+	host        string // the host's display name
+	sshUser     string // announced to the host when it asks for approval
+	sas         string // verification code from our first handshake
 	mu          sync.Mutex
 	
-	onMessage    func(protocol.Message)
-	onConnect    func()
-	onDisconnect func()
+	// Resuming after a dropped connection
+	transport Transport
+	psk       []byte
+	token     string
+	sent      outbox
+	recvSeq   uint64 // last sequence number received from the host
+	lastHeard time.Time
+	resuming  bool // messages are queued until we are back
+	stopped   bool
+	done      chan struct{}
+	
+	onMessage      func(protocol.Message)
+	onConnect      func()
+	onDisconnect   func()
+	onReconnecting func()
+	
+	hostKeyPrompt HostKeyPrompt
+	
+	heartbeatInterval time.Duration
+	resumeWindow      time.Duration
 }
 
 type ConnectionInfo struct {
 	User      string
 	Host      string
 	SessionID string
+	Secret    string // from "session-id+secret", if the host uses one
 	Port      int
+	Socket    bool // the host listens on its per-user Unix socket
+	
+	// Filled in from ~/.ssh/config by ApplySSHConfig
+	SSHPort        int
+	IdentityFiles  []string
+	IdentitiesOnly bool
+	ProxyJump      string
 }
 
 func NewClient(sess *session.Session) *Client {
 	return &Client{
-		session: sess,
+		session:  sess,
+		offered:  protocol.SupportedCapabilities(),
+		codecs:   protocol.SupportedCodecs(),
+		receipts: newReceiptTracker(),
+		done:     make(chan struct{}),
+		
+		heartbeatInterval: heartbeatInterval,
+		resumeWindow:      resumeWindow,
 	}
 }
 
@@ -45,18 +88,59 @@ func (c *Client) SetCallbacks(onMessage func(protocol.Message), onConnect, onDis
 	c.onDisconnect = onDisconnect
 }
 
+// SetReconnectingCallback is called when the connection drops and the client
+// starts trying to resume. onConnect follows if it succeeds, onDisconnect if
+// it gives up.
+func (c *Client) SetReconnectingCallback(onReconnecting func()) {
+	c.onReconnecting = onReconnecting
+}
+
+// SetCapabilities limits the optional features offered to the host.
+func (c *Client) SetCapabilities(caps []string) {
+	c.offered = protocol.NegotiateCapabilities(protocol.SupportedCapabilities(), caps)
+}
+
+// SetCodecs limits the wire codecs offered to the host, in order of
+// preference. JSON is always possible.
+func (c *Client) SetCodecs(codecs []string) {
+	c.codecs = protocol.NegotiateCapabilities(codecs, protocol.SupportedCodecs())
+}
+
+// SetMaxMessageSize limits how large a message from the host may be, in
+// bytes as encoded. Larger ones are skipped and the host is told why. 0
+// means protocol.DefaultMaxFrameSize.
+func (c *Client) SetMaxMessageSize(n int) {
+	c.maxFrame = n
+}
+
+// SetStatusCallback is told how each TEXT message we send progresses once
+// receipts have been negotiated.
+func (c *Client) SetStatusCallback(onStatus func(id string, status protocol.DeliveryStatus)) {
+	c.receipts.setCallback(onStatus)
+}
+
+func (c *Client) SetHostKeyPrompt(prompt HostKeyPrompt) {
+	c.hostKeyPrompt = prompt
+}
+
 func ParseConnectionString(connStr string) (*ConnectionInfo, error) {
 	parts := strings.Split(connStr, ":")
 	if len(parts) < 2 || len(parts) > 3 {
-		return nil, fmt.Errorf("invalid format, expected user@host:session-id or user@host:session-id:port")
+		return nil, fmt.Errorf("invalid format, expected [user@]host:session-id, [user@]host:session-id:port or [user@]host:session-id:socket")
 	}
 	
 	userHost := parts[0]
-	sessionID := parts[1]
+	sessionID, secret, _ := strings.Cut(parts[1], "+")
+	if sessionID == "" {
+		return nil, fmt.Errorf("session ID cannot be empty")
+	}
 	port := 9999 // default port
+	socket := false
 	
-	// Optional port
-	if len(parts) == 3 {
+	// Optional port, or "socket"
+	if len(parts) == 3 && parts[2] == "socket" {
+		socket = true
+	} else if len(parts) == 3 {
 		customPort, err := strconv.Atoi(parts[2])
 		if err != nil {
 			return nil, fmt.Errorf("invalid port number: %s", parts[2])
@@ -67,16 +151,21 @@ func ParseConnectionString(connStr string) (*ConnectionInfo, error) {
 		port = customPort
 	}
 	
-	uhParts := strings.Split(userHost, "@")
-	if len(uhParts) != 2 {
-		return nil, fmt.Errorf("invalid user@host format")
-	}
-	
-	user := uhParts[0]
-	host := uhParts[1]
-	
-	if user == "" {
-		return nil, fmt.Errorf("user cannot be empty")
+	// The user is optional; it can come from ~/.ssh/config instead
+	user := ""
+	host := userHost
+	if strings.Contains(userHost, "@") {
+		uhParts := strings.Split(userHost, "@")
+		if len(uhParts) != 2 {
+			return nil, fmt.Errorf("invalid user@host format")
+		}
+		
+		user = uhParts[0]
+		host = uhParts[1]
+		
+		if user == "" {
+			return nil, fmt.Errorf("user cannot be empty")
+		}
 	}
 	
 	if host == "" {
@@ -87,23 +176,71 @@ func ParseConnectionString(connStr string) (*ConnectionInfo, error) {
 		User:      user,
 		Host:      host,
 		SessionID: sessionID,
+		Secret:    secret,
 		Port:      port,
+		Socket:    socket,
 	}, nil
 }
 
+// ConnectLocal joins a session on this machine or the local network. addr is
+// host:port, or unix:/path for a Unix socket.
 func (c *Client) ConnectLocal(addr string, sessionID string) error {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
-	}
-	
-	c.conn = conn
-	c.encoder = json.NewEncoder(conn)
-	c.decoder = json.NewDecoder(conn)
-	c.session.ID = sessionID
-	
-	if err := c.performHandshake(); err != nil {
-		c.conn.Close()
+	return c.Connect(sessionID, TransportFunc(func() (net.Conn, error) {
+		network := "tcp"
+		if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+			network, addr = "unix", path
+		}
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect: %w", err)
+		}
+		return conn, nil
+	}))
+}
+
+func (c *Client) ConnectViaSSH(connInfo *ConnectionInfo) error {
+	c.session.Secret = connInfo.Secret
+	c.sshUser = connInfo.User
+	socketPath := ""
+	return c.Connect(connInfo.SessionID, TransportFunc(func() (net.Conn, error) {
+		sshClient, err := c.dialSSH(connInfo)
+		if err != nil {
+			return nil, err
+		}
+		fail := func(err error) (net.Conn, error) {
+			c.mu.Lock()
+			c.closeSSH()
+			c.mu.Unlock()
+			return nil, err
+		}
+		
+		if !connInfo.Socket {
+			conn, err := sshClient.Dial("tcp", fmt.Sprintf("localhost:%d", connInfo.Port))
+			if err != nil {
+				return fail(fmt.Errorf("failed to connect through SSH tunnel: %w", err))
+			}
+			return conn, nil
+		}
+		
+		// The socket stays put, so only ask where it is once
+		if socketPath == "" {
+			if socketPath, err = remoteSocketPath(sshClient, connInfo.SessionID); err != nil {
+				return fail(err)
+			}
+		}
+		
+		// Uses a direct-streamlocal@openssh.com channel
+		conn, err := sshClient.Dial("unix", socketPath)
+		if err != nil {
+			return fail(fmt.Errorf("failed to reach the session socket through SSH (is AllowStreamLocalForwarding on?): %w", err))
+		}
+		return conn, nil
+	}))
+}
+
+func (c *Client) connect() error {
+	c.psk = sessionKey(c.session.Credential())
+	if err := c.establish(); err != nil {
 		return err
 	}
 	
@@ -116,76 +253,197 @@ func (c *Client) ConnectLocal(addr string, sessionID string) error {
 	return nil
 }
 
-func (c *Client) ConnectViaSSH(connInfo *ConnectionInfo) error {
-	sshConfig := &ssh.ClientConfig{
-		User: connInfo.User,
-		Auth: []ssh.AuthMethod{},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+// establish dials the host and completes the handshake, resuming our seat if
+// we have had one before.
+func (c *Client) establish() error {
+	raw, err := c.transport.Dial()
+	if err != nil {
+		return err
 	}
 	
-	if authMethod := getSSHAuthMethod(); authMethod != nil {
-		sshConfig.Auth = append(sshConfig.Auth, authMethod)
+	fail := func(err error) error {
+		raw.Close()
+		c.mu.Lock()
+		c.closeSSH()
+		c.mu.Unlock()
+		return err
 	}
 	
-	sshAddr := fmt.Sprintf("%s:22", connInfo.Host)
-	sshClient, err := ssh.Dial("tcp", sshAddr, sshConfig)
+	conn, err := noiseClient(raw, c.psk)
 	if err != nil {
-		return fmt.Errorf("SSH connection failed: %w", err)
+		var hostErr *HostError
+		if !errors.As(err, &hostErr) {
+			err = fmt.Errorf("encrypted handshake failed (is the host running termchat %s or later?): %w", protocol.ProtocolVersion, err)
+		}
+		return fail(err)
 	}
 	
-	c.sshClient = sshClient
-	
-	conn, err := sshClient.Dial("tcp", fmt.Sprintf("localhost:%d", connInfo.Port))
+	reader := bufio.NewReader(conn)
+	welcome, codec, err := c.performHandshake(conn, reader)
 	if err != nil {
-		sshClient.Close()
-		return fmt.Errorf("failed to connect through SSH tunnel: %w", err)
+		return fail(err)
 	}
+	encoder := codec.NewEncoder(stallGuard{conn, 3 * c.heartbeatInterval})
+	decoder := codec.NewDecoder(reader, c.maxFrame)
 	
-	c.conn = conn
-	c.encoder = json.NewEncoder(conn)
-	c.decoder = json.NewDecoder(conn)
-	c.session.ID = connInfo.SessionID
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	
-	if err := c.performHandshake(); err != nil {
-		c.conn.Close()
-		sshClient.Close()
-		return err
+	if c.stopped {
+		raw.Close()
+		c.closeSSH()
+		return fmt.Errorf("client stopped")
 	}
 	
-	c.session.SetState(session.StateActive)
-	if c.onConnect != nil {
-		c.onConnect()
+	// A resume is authenticated by the token, which travelled over the
+	// channel the user verified, so the code stays the same
+	if c.sas == "" && !isLocal(conn) {
+		c.sas = shortAuthString(conn.HandshakeHash())
 	}
 	
-	go c.handleConnection()
+	c.conn = conn
+	c.encoder = encoder
+	c.decoder = decoder
+	c.lastHeard = time.Now()
+	c.resuming = false
+	
+	// Replay whatever the host missed, including messages queued while we
+	// were away. A write error surfaces in the read loop.
+	for _, msg := range c.sent.since(welcome.Seq) {
+		if encoder.Encode(&msg) != nil {
+			break
+		}
+	}
+	
+	if protocol.HasCapability(c.caps, protocol.CapResume) {
+		go c.heartbeat(conn)
+	}
 	return nil
 }
 
+// dialSSH connects to the target, hopping through any ProxyJump hosts first.
+func (c *Client) dialSSH(connInfo *ConnectionInfo) (*ssh.Client, error) {
+	jumps, err := parseJumpHosts(connInfo.ProxyJump)
+	if err != nil {
+		return nil, err
+	}
+	
+	hostKeys := newHostKeyChecker(c.hostKeyPrompt)
+	c.mu.Lock()
+	if c.resuming {
+		hostKeys = hostKeys.unattended()
+	}
+	c.mu.Unlock()
+	
+	clients, err := dialSSHChain(append(jumps, connInfo), hostKeys)
+	if err != nil {
+		return nil, err
+	}
+	
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.jumpClients = clients[:len(clients)-1]
+	c.sshClient = clients[len(clients)-1]
+	return c.sshClient, nil
+}
+
+// closeSSH tears down the tunnel and any jump hosts. c.mu must be held.
+func (c *Client) closeSSH() {
+	if c.sshClient != nil {
+		c.sshClient.Close()
+		c.sshClient = nil
+	}
+	for i := len(c.jumpClients) - 1; i >= 0; i-- {
+		c.jumpClients[i].Close()
+	}
+	c.jumpClients = nil
+}
+
 func (c *Client) handleConnection() {
-	defer func() {
+	for c.serve() && c.reconnect() {
+	}
+	
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.encoder = nil
+	c.closeSSH()
+	c.mu.Unlock()
+	
+	if c.onDisconnect != nil {
+		c.onDisconnect()
+	}
+}
+
+// serve reads from the current connection until it ends. It reports whether
+// the connection dropped in a way we can resume from.
+func (c *Client) serve() bool {
+	c.mu.Lock()
+	decoder := c.decoder
+	c.mu.Unlock()
+	
+	for {
+		var msg protocol.Message
+		err := decoder.Decode(&msg)
+		var frameErr *protocol.FrameError
+		if err != nil && !errors.As(err, &frameErr) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return !c.stopped && c.token != ""
+		}
+		
 		c.mu.Lock()
-		if c.conn != nil {
-			c.conn.Close()
-			c.conn = nil
+		c.lastHeard = time.Now()
+		c.mu.Unlock()
+		
+		if frameErr != nil {
+			// The host hears why; after a skipped frame the connection
+			// carries on
+			c.write(protocol.NewError(frameErr.Code(), frameErr.Error()))
+			if frameErr.Fatal {
+				return false
+			}
+			continue
 		}
-		if c.sshClient != nil {
-			c.sshClient.Close()
-			c.sshClient = nil
+		
+		// Messages replayed after a resume that we already have
+		if msg.Seq != 0 {
+			if msg.Seq <= c.recvSeq {
+				continue
+			}
+			c.recvSeq = msg.Seq
 		}
-		c.mu.Unlock()
 		
-		if c.onDisconnect != nil {
-			c.onDisconnect()
+		switch msg.Type {
+		case protocol.MessageTypeAck, protocol.MessageTypeRead:
+			c.receipts.receipt(msg)
+			continue
+		case protocol.MessageTypeText:
+			// Delivered means it reached us; READ waits for the UI
+			if msg.ID != "" && c.HasCapability(protocol.CapReceipts) {
+				c.SendMessage(protocol.NewReceipt(protocol.MessageTypeAck, msg.ID))
+			}
+		case protocol.MessageTypePong:
+			continue
 		}
-	}()
-	
-	for {
-		var msg protocol.Message
-		if err := c.decoder.Decode(&msg); err != nil {
-			return
+		
+		if msg.Type != protocol.MessageTypeTyping && !protocol.IsFileTransfer(msg.Type) {
+			c.session.AddMessage(msg)
 		}
 		
-		c.session.AddMessage(msg)
+		// Track our own renames so later HELLOs and labels stay in sync
+		if msg.Type == protocol.MessageTypeNick && msg.From == c.session.GetName() {
+			c.session.SetName(msg.Content)
+		}
+		if msg.Type == protocol.MessageTypeNick {
+			c.mu.Lock()
+			if msg.From == c.host {
+				c.host = msg.Content
+			}
+			c.mu.Unlock()
+		}
 		
 		if c.onMessage != nil {
 			c.onMessage(msg)
@@ -193,54 +451,351 @@ func (c *Client) handleConnection() {
 		
 		switch msg.Type {
 		case protocol.MessageTypePing:
-			c.SendMessage(protocol.NewMessage(protocol.MessageTypePong, ""))
+			c.write(protocol.NewMessage(protocol.MessageTypePong, ""))
 		case protocol.MessageTypeLeave:
+			return false
+		}
+	}
+}
+
+// reconnect retries with exponential backoff until the host takes us back,
+// refuses us, or the resume window runs out.
+func (c *Client) reconnect() bool {
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.encoder = nil
+	c.closeSSH()
+	c.resuming = true
+	c.mu.Unlock()
+	
+	c.session.SetState(session.StateReconnecting)
+	if c.onReconnecting != nil {
+		c.onReconnecting()
+	}
+	
+	delay := reconnectMinDelay
+	deadline := time.Now().Add(c.resumeWindow)
+	for time.Now().Before(deadline) {
+		select {
+		case <-c.done:
+			return false
+		case <-time.After(delay):
+		}
+		
+		err := c.establish()
+		if err == nil {
+			c.session.SetState(session.StateActive)
+			if c.onConnect != nil {
+				c.onConnect()
+			}
+			return true
+		}
+		if permanentError(err) {
+			return false
+		}
+		
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+	return false
+}
+
+// heartbeat pings the host so it knows we are still here, and closes a
+// connection that has gone quiet so the read loop notices the drop. SSH
+// channels do not support read deadlines, hence the watchdog.
+func (c *Client) heartbeat(conn net.Conn) {
+	ticker := time.NewTicker(c.heartbeatInterval)
+	defer ticker.Stop()
+	
+	for range ticker.C {
+		c.mu.Lock()
+		if c.conn != conn {
+			c.mu.Unlock()
 			return
 		}
+		if time.Since(c.lastHeard) > 3*c.heartbeatInterval {
+			conn.Close()
+			c.mu.Unlock()
+			return
+		}
+		if err := c.encoder.Encode(protocol.NewMessage(protocol.MessageTypePing, "")); err != nil {
+			conn.Close()
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+	}
+}
+
+// stallGuard closes conn when a write to it takes longer than timeout, as
+// SSH channels do not support write deadlines either. Writes happen with
+// c.mu held, so a stalled link would otherwise block the whole client; once
+// closed, the read loop notices and reconnects.
+type stallGuard struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (g stallGuard) Write(b []byte) (int, error) {
+	stalled := time.AfterFunc(g.timeout, func() { g.conn.Close() })
+	defer stalled.Stop()
+	return g.conn.Write(b)
+}
+
+// permanentError reports whether retrying cannot help: the host turned us
+// away or the connection failed verification. A lockout ends by itself.
+func permanentError(err error) bool {
+	if errors.Is(err, ErrRateLimited) {
+		return false
 	}
+	var hostErr *HostError
+	var versionErr *protocol.VersionError
+	var mismatch *HostKeyMismatchError
+	var unknown *HostKeyUnknownError
+	return errors.As(err, &hostErr) || errors.As(err, &versionErr) ||
+		errors.As(err, &mismatch) || errors.As(err, &unknown)
+}
+
+var errNoFileTransfer = errors.New("the host cannot transfer files")
+
+// Reasons the host can turn us away. Errors from Connect match them with
+// errors.Is.
+var (
+	ErrSessionMismatch    = errors.New("session ID mismatch")
+	ErrVersionUnsupported = errors.New("unsupported protocol version")
+	ErrRateLimited        = errors.New("too many failed attempts")
+	ErrSessionFull        = errors.New("session is full")
+	ErrRejected           = errors.New("rejected by the host")
+	ErrTooLarge           = errors.New("message too large")
+	ErrSessionExpired     = errors.New("session expired")
+)
+
+var codeErrors = map[protocol.ErrorCode]error{
+	protocol.ErrorCodeSessionMismatch:    ErrSessionMismatch,
+	protocol.ErrorCodeVersionUnsupported: ErrVersionUnsupported,
+	protocol.ErrorCodeRateLimited:        ErrRateLimited,
+	protocol.ErrorCodeSessionFull:        ErrSessionFull,
+	protocol.ErrorCodeRejected:           ErrRejected,
+	protocol.ErrorCodeTooLarge:           ErrTooLarge,
+	protocol.ErrorCodeSessionExpired:     ErrSessionExpired,
+}
+
+// HostError is an ERROR reply from the host during the handshake. Hosts
+// from before error codes send none.
+type HostError struct {
+	Code    protocol.ErrorCode
+	Message string
+}
+
+func (e *HostError) Error() string {
+	if e.Message == "" {
+		return "server error: " + string(e.Code)
+	}
+	return "server error: " + e.Message
+}
+
+// Unwrap returns the sentinel for e's code, or nil for none.
+func (e *HostError) Unwrap() error {
+	return codeErrors[e.Code]
+}
+
+// versionError wraps a *protocol.VersionError, found by either side, so that
+// it also matches ErrVersionUnsupported.
+type versionError struct {
+	error
+}
+
+func (e versionError) Unwrap() []error {
+	return []error{ErrVersionUnsupported, e.error}
 }
 
-func (c *Client) performHandshake() error {
+// performHandshake introduces us to the host. A HELLO carrying our resume
+// token and the last sequence number we saw asks for our old seat back. It
+// returns the WELCOME and the codec the host picked for the rest of the
+// connection.
+func (c *Client) performHandshake(conn net.Conn, reader *bufio.Reader) (*protocol.Message, protocol.Codec, error) {
 	hello := protocol.NewHandshakeMessage(protocol.MessageTypeHello, c.session.ID)
-	if err := c.encoder.Encode(hello); err != nil {
-		return err
+	hello.Name = c.session.GetName()
+	hello.User = c.sshUser
+	hello.Capabilities = c.offered
+	hello.Codecs = c.codecs
+	c.mu.Lock()
+	hello.Token = c.token
+	c.mu.Unlock()
+	hello.Seq = c.recvSeq
+	if err := protocol.JSON.NewEncoder(conn).Encode(hello); err != nil {
+		return nil, nil, err
 	}
 	
 	var welcome protocol.Message
-	if err := c.decoder.Decode(&welcome); err != nil {
-		return err
+	if err := protocol.JSON.NewDecoder(reader, c.maxFrame).Decode(&welcome); err != nil {
+		return nil, nil, err
 	}
 	
 	if welcome.Type == protocol.MessageTypeError {
-		return fmt.Errorf("server error: %s", welcome.Content)
+		if welcome.Version != "" {
+			return nil, nil, versionError{&protocol.VersionError{Local: protocol.ProtocolVersion, Remote: welcome.Version}}
+		}
+		return nil, nil, &HostError{Code: welcome.Code, Message: welcome.Content}
 	}
 	
 	if welcome.Type != protocol.MessageTypeWelcome {
-		return fmt.Errorf("invalid handshake: expected WELCOME, got %s", welcome.Type)
+		return nil, nil, fmt.Errorf("invalid handshake: expected WELCOME, got %s", welcome.Type)
+	}
+	
+	if err := protocol.CheckVersion(welcome.Version); err != nil {
+		return nil, nil, versionError{err}
+	}
+	
+	// A host that predates codecs names none and stays in JSON
+	codec := protocol.JSON
+	if len(welcome.Codecs) > 0 {
+		picked, ok := protocol.CodecByName(welcome.Codecs[0])
+		if !ok || !protocol.HasCapability(c.codecs, welcome.Codecs[0]) {
+			return nil, nil, fmt.Errorf("invalid handshake: host picked codec %q, which we did not offer", welcome.Codecs[0])
+		}
+		codec = picked
+	}
+	
+	// Only trust the host to narrow what we offered, never to widen it
+	c.mu.Lock()
+	c.caps = protocol.NegotiateCapabilities(c.offered, welcome.Capabilities)
+	if welcome.Token != "" {
+		c.token = welcome.Token
+	}
+	c.host = welcome.From
+	c.mu.Unlock()
+	
+	// The host may have adjusted our name to keep it unique
+	if welcome.Name != "" {
+		c.session.SetName(welcome.Name)
 	}
 	
 	ready := protocol.NewMessage(protocol.MessageTypeReady, "")
-	if err := c.encoder.Encode(ready); err != nil {
-		return err
+	if err := codec.NewEncoder(conn).Encode(ready); err != nil {
+		return nil, nil, err
 	}
 	
-	return nil
+	return &welcome, codec, nil
 }
 
 func (c *Client) SendMessage(msg *protocol.Message) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	
-	if c.encoder == nil {
+	if c.encoder == nil && !c.resuming {
 		return fmt.Errorf("not connected")
 	}
 	
+	if protocol.IsFileTransfer(msg.Type) && !protocol.HasCapability(c.caps, protocol.CapFileTransfer) {
+		return errNoFileTransfer
+	}
+	
+	// Features the host did not agree to are dropped, not sent
+	switch msg.Type {
+	case protocol.MessageTypeTyping:
+		if !protocol.HasCapability(c.caps, protocol.CapTyping) {
+			return nil
+		}
+	case protocol.MessageTypeAck, protocol.MessageTypeRead:
+		if !protocol.HasCapability(c.caps, protocol.CapReceipts) {
+			return nil
+		}
+	case protocol.MessageTypeFileChunk:
+		// Not kept for replay; after a drop the receiver asks again from
+		// where it got to
+		if c.encoder == nil {
+			return nil
+		}
+		return c.encoder.Encode(msg)
+	}
+	
+	tracked := msg.Type == protocol.MessageTypeText && protocol.HasCapability(c.caps, protocol.CapReceipts)
+	if tracked {
+		c.receipts.track(msg.ID)
+	}
+	
+	out := c.sent.add(msg)
+	msg.Seq = out.Seq
+	if c.encoder == nil {
+		// Sent once we are back
+		return nil
+	}
+	
+	if err := c.encoder.Encode(&out); err != nil {
+		if c.token != "" {
+			// Still in the outbox; the read loop will notice and resume
+			return nil
+		}
+		if tracked {
+			c.receipts.fail(msg.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// write sends a control message that is not worth replaying.
+func (c *Client) write(msg *protocol.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	if c.encoder == nil {
+		return fmt.Errorf("not connected")
+	}
 	return c.encoder.Encode(msg)
 }
 
+// SAS returns the verification code for our connection to the host, to be
+// compared with the code the host sees.
+func (c *Client) SAS() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sas
+}
+
+// HostName returns the host's current display name.
+func (c *Client) HostName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.host
+}
+
+// Capabilities returns the optional features negotiated with the host.
+func (c *Client) Capabilities() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.caps...)
+}
+
+func (c *Client) HasCapability(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return protocol.HasCapability(c.caps, name)
+}
+
+// SetName asks the host to rename us; the change takes effect once the host
+// broadcasts it back.
+func (c *Client) SetName(name string) error {
+	if err := session.ValidateName(name); err != nil {
+		return err
+	}
+	return c.SendMessage(protocol.NewMessage(protocol.MessageTypeNick, name))
+}
+
 func (c *Client) Stop() {
 	c.mu.Lock()
 	
+	if !c.stopped {
+		c.stopped = true
+		close(c.done)
+	}
+	
 	if c.conn != nil {
 		// Send leave message before closing
 		if c.encoder != nil {
@@ -251,10 +806,7 @@ func (c *Client) Stop() {
 		c.conn = nil
 	}
 	
-	if c.sshClient != nil {
-		c.sshClient.Close()
-		c.sshClient = nil
-	}
+	c.closeSSH()
 	
 	c.mu.Unlock()
 	
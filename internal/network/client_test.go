@@ -1,7 +1,11 @@
 package network
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+
+	"github.com/sam/termchat/pkg/protocol"
 )
 
 func TestParseConnectionString(t *testing.T) {
@@ -10,7 +14,9 @@ func TestParseConnectionString(t *testing.T) {
 		wantUser string
 		wantHost string
 		wantSess string
+		wantSec  string
 		wantPort int
+		wantSock bool
 		wantErr  bool
 	}{
 		{
@@ -46,7 +52,15 @@ func TestParseConnectionString(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			input:   "missing:session",
+			input:    "devbox:cosmic-turtle-7823",
+			wantUser: "",
+			wantHost: "devbox",
+			wantSess: "cosmic-turtle-7823",
+			wantPort: 9999,
+			wantErr:  false,
+		},
+		{
+			input:   "a@b@host:session",
 			wantErr: true,
 		},
 		{
@@ -57,6 +71,26 @@ func TestParseConnectionString(t *testing.T) {
 			input:   "user@:session",
 			wantErr: true,
 		},
+		{
+			input:    "alice@devbox:cosmic-turtle-7823+mfrggzdfmztwq2lk:8080",
+			wantUser: "alice",
+			wantHost: "devbox",
+			wantSess: "cosmic-turtle-7823",
+			wantSec:  "mfrggzdfmztwq2lk",
+			wantPort: 8080,
+		},
+		{
+			input:   "alice@devbox:+secret",
+			wantErr: true,
+		},
+		{
+			input:    "alice@devbox:cosmic-turtle-7823:socket",
+			wantUser: "alice",
+			wantHost: "devbox",
+			wantSess: "cosmic-turtle-7823",
+			wantPort: 9999,
+			wantSock: true,
+		},
 	}
 	
 	for _, tt := range tests {
@@ -87,6 +121,14 @@ func TestParseConnectionString(t *testing.T) {
 				t.Errorf("SessionID = %q, want %q", info.SessionID, tt.wantSess)
 			}
 			
+			if info.Secret != tt.wantSec {
+				t.Errorf("Secret = %q, want %q", info.Secret, tt.wantSec)
+			}
+			
+			if info.Socket != tt.wantSock {
+				t.Errorf("Socket = %v, want %v", info.Socket, tt.wantSock)
+			}
+			
 			if info.Port != tt.wantPort {
 				t.Errorf("Port = %d, want %d", info.Port, tt.wantPort)
 			}
@@ -116,4 +158,33 @@ func TestConnectionInfoValidation(t *testing.T) {
 	if info == nil {
 		t.Error("Expected non-nil ConnectionInfo")
 	}
+}
+
+func TestHostErrorCodes(t *testing.T) {
+	err := fmt.Errorf("connect: %w", &HostError{Code: protocol.ErrorCodeSessionFull, Message: "Session is full"})
+	if !errors.Is(err, ErrSessionFull) || errors.Is(err, ErrRejected) {
+		t.Errorf("Expected %v to match only ErrSessionFull", err)
+	}
+	if !permanentError(err) {
+		t.Error("A full session should not be retried")
+	}
+	
+	// A lockout ends, so it is worth trying again
+	limited := &HostError{Code: protocol.ErrorCodeRateLimited}
+	if !errors.Is(limited, ErrRateLimited) || permanentError(limited) {
+		t.Errorf("Expected %v to be rate limited and retried", limited)
+	}
+	
+	// A code from a newer host is still a HostError
+	var hostErr *HostError
+	newer := error(&HostError{Code: "FROM_THE_FUTURE", Message: "Try later"})
+	if !errors.As(newer, &hostErr) || errors.Unwrap(newer) != nil {
+		t.Errorf("Expected an unknown code to match no sentinel, got %v", errors.Unwrap(newer))
+	}
+	
+	version := versionError{&protocol.VersionError{Local: "2.0", Remote: "3.0"}}
+	var versionErr *protocol.VersionError
+	if !errors.Is(version, ErrVersionUnsupported) || !errors.As(version, &versionErr) || versionErr.Remote != "3.0" {
+		t.Errorf("Expected %v to match ErrVersionUnsupported and *protocol.VersionError", version)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,301 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyPrompt asks the user whether an unknown host key should be trusted.
+// It is only consulted when the host has no entry in any known_hosts file.
+type HostKeyPrompt func(host string, remote net.Addr, key ssh.PublicKey) bool
+
+// HostKeyMismatchError is returned when a host presents a key that differs
+// from the one recorded in known_hosts.
+type HostKeyMismatchError struct {
+	Host  string
+	Key   ssh.PublicKey
+	Known []knownhosts.KnownKey
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	var b strings.Builder
+	b.WriteString("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n")
+	b.WriteString("@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\n")
+	b.WriteString("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n")
+	b.WriteString("IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!\n")
+	b.WriteString("Someone could be eavesdropping on you right now (man-in-the-middle attack)!\n")
+	b.WriteString("It is also possible that a host key has just been changed.\n")
+	fmt.Fprintf(&b, "The fingerprint for the %s key sent by the remote host %s is\n", keyTypeName(e.Key), e.Host)
+	fmt.Fprintf(&b, "%s.\n", ssh.FingerprintSHA256(e.Key))
+	for _, k := range e.Known {
+		fmt.Fprintf(&b, "Offending %s key in %s:%d\n", keyTypeName(k.Key), k.Filename, k.Line)
+	}
+	b.WriteString("Host key verification failed.")
+	return b.String()
+}
+
+// HostKeyUnknownError is returned when a host is not in known_hosts and the
+// user declined (or could not be asked) to trust it.
+type HostKeyUnknownError struct {
+	Host string
+	Key  ssh.PublicKey
+}
+
+func (e *HostKeyUnknownError) Error() string {
+	return fmt.Sprintf("host key verification failed: no known_hosts entry for %s (%s key %s)",
+		e.Host, keyTypeName(e.Key), ssh.FingerprintSHA256(e.Key))
+}
+
+type hostKeyChecker struct {
+	userFile string
+	files    []string
+	prompt   HostKeyPrompt
+	mu       sync.Mutex
+}
+
+// newHostKeyChecker reads ~/.ssh/known_hosts and the system-wide files, and
+// appends newly trusted keys to the user's file.
+func newHostKeyChecker(prompt HostKeyPrompt) *hostKeyChecker {
+	var userFile string
+	var files []string
+	if home, err := os.UserHomeDir(); err == nil {
+		userFile = filepath.Join(home, ".ssh", "known_hosts")
+		files = append(files, userFile, filepath.Join(home, ".ssh", "known_hosts2"))
+	}
+	files = append(files, "/etc/ssh/ssh_known_hosts", "/etc/ssh/ssh_known_hosts2")
+	
+	return &hostKeyChecker{
+		userFile: userFile,
+		files:    files,
+		prompt:   prompt,
+	}
+}
+
+// unattended returns a checker for the same files that refuses unknown keys
+// instead of prompting. Reconnects use it: they happen while the UI owns the
+// terminal, so nobody would see the prompt or be able to answer it.
+func (h *hostKeyChecker) unattended() *hostKeyChecker {
+	return &hostKeyChecker{userFile: h.userFile, files: h.files}
+}
+
+func (h *hostKeyChecker) load() (ssh.HostKeyCallback, error) {
+	var existing []string
+	for _, f := range h.files {
+		if _, err := os.Stat(f); err == nil {
+			existing = append(existing, f)
+		}
+	}
+	return knownhosts.New(existing...)
+}
+
+func (h *hostKeyChecker) Callback() ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		
+		check, err := h.load()
+		if err != nil {
+			return fmt.Errorf("failed to read known_hosts: %w", err)
+		}
+		
+		err = check(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+		
+		var revoked *knownhosts.RevokedError
+		if errors.As(err, &revoked) {
+			return fmt.Errorf("host key for %s is marked as revoked in %s:%d",
+				hostname, revoked.Revoked.Filename, revoked.Revoked.Line)
+		}
+		
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		
+		if len(keyErr.Want) > 0 {
+			return &HostKeyMismatchError{Host: hostname, Key: key, Known: keyErr.Want}
+		}
+		
+		if h.prompt == nil || !h.prompt(hostname, remote, key) {
+			return &HostKeyUnknownError{Host: hostname, Key: key}
+		}
+		
+		return h.remember(hostname, remote, key)
+	}
+}
+
+// KeyAlgorithms lists the algorithms recorded for addr so the server offers
+// a key we can verify instead of one we have never seen. It returns nil, for
+// the library's defaults, when a certificate authority is trusted for addr:
+// the host may then present a certificate of any type.
+func (h *hostKeyChecker) KeyAlgorithms(addr string) []string {
+	check, err := h.load()
+	if err != nil {
+		return nil
+	}
+	if h.hasAuthority(addr) {
+		return nil
+	}
+	
+	// A throwaway key never matches, so the error lists every known key.
+	placeholder, err := ssh.NewPublicKey(make(ed25519.PublicKey, ed25519.PublicKeySize))
+	if err != nil {
+		return nil
+	}
+	
+	var keyErr *knownhosts.KeyError
+	if !errors.As(check(addr, &net.TCPAddr{IP: net.IPv4zero}, placeholder), &keyErr) {
+		return nil
+	}
+	
+	var algos []string
+	seen := make(map[string]bool)
+	for _, k := range keyErr.Want {
+		for _, algo := range keyAlgorithms(k.Key.Type()) {
+			if !seen[algo] {
+				seen[algo] = true
+				algos = append(algos, algo)
+			}
+		}
+	}
+	return algos
+}
+
+// hasAuthority reports whether a @cert-authority line in known_hosts applies
+// to addr.
+func (h *hostKeyChecker) hasAuthority(addr string) bool {
+	host := knownhosts.Normalize(addr)
+	for _, file := range h.files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "@cert-authority") {
+				continue
+			}
+			marker, patterns, _, _, _, err := ssh.ParseKnownHosts([]byte(line))
+			if err == nil && marker == "cert-authority" && hostMatches(patterns, host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostMatches applies known_hosts patterns to a normalized host: one of them
+// must match and none of the negated ones.
+func hostMatches(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		
+		var ok bool
+		if strings.HasPrefix(pattern, "|1|") {
+			ok = hashedHostMatches(pattern, host)
+		} else {
+			// Brackets are literal in known_hosts, as in [host]:port
+			escaped := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(pattern)
+			ok, _ = path.Match(escaped, host)
+		}
+		
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// hashedHostMatches checks host against a |1|salt|hash pattern, as written
+// by ssh-keygen -H.
+func hashedHostMatches(pattern, host string) bool {
+	fields := strings.Split(pattern, "|")
+	if len(fields) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(fields[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+func (h *hostKeyChecker) remember(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if h.userFile == "" {
+		return fmt.Errorf("cannot record host key: home directory not found")
+	}
+	
+	if err := os.MkdirAll(filepath.Dir(h.userFile), 0700); err != nil {
+		return fmt.Errorf("cannot record host key: %w", err)
+	}
+	
+	f, err := os.OpenFile(h.userFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot record host key: %w", err)
+	}
+	defer f.Close()
+	
+	addresses := []string{hostname}
+	if host, port, err := net.SplitHostPort(hostname); err == nil {
+		if ip := remoteIP(remote); ip != "" && ip != host {
+			addresses = append(addresses, net.JoinHostPort(ip, port))
+		}
+	}
+	
+	if _, err := fmt.Fprintln(f, knownhosts.Line(addresses, key)); err != nil {
+		return fmt.Errorf("cannot record host key: %w", err)
+	}
+	return nil
+}
+
+func remoteIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok && tcp.IP != nil && !tcp.IP.IsUnspecified() {
+		return tcp.IP.String()
+	}
+	return ""
+}
+
+func keyAlgorithms(keyType string) []string {
+	switch keyType {
+	case ssh.KeyAlgoRSA:
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	default:
+		return []string{keyType}
+	}
+}
+
+func keyTypeName(key ssh.PublicKey) string {
+	switch key.Type() {
+	case ssh.KeyAlgoED25519:
+		return "ED25519"
+	case ssh.KeyAlgoRSA:
+		return "RSA"
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return "ECDSA"
+	default:
+		return strings.ToUpper(key.Type())
+	}
+}
\ No newline at end of file
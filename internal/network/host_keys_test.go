@@ -0,0 +1,260 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to convert key: %v", err)
+	}
+	return key
+}
+
+func newTestChecker(t *testing.T, contents string, prompt HostKeyPrompt) *hostKeyChecker {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if contents != "" {
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatalf("Failed to write known_hosts: %v", err)
+		}
+	}
+	return &hostKeyChecker{userFile: path, files: []string{path}, prompt: prompt}
+}
+
+var testRemote = &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 22}
+
+func TestHostKeyKnown(t *testing.T) {
+	key := newTestHostKey(t)
+	checker := newTestChecker(t, knownhosts.Line([]string{"devbox:22"}, key)+"\n", nil)
+	
+	if err := checker.Callback()("devbox:22", testRemote, key); err != nil {
+		t.Errorf("Expected known key to verify, got %v", err)
+	}
+}
+
+func TestHostKeyHashedEntry(t *testing.T) {
+	key := newTestHostKey(t)
+	line := knownhosts.HashHostname("devbox") + " " + string(ssh.MarshalAuthorizedKey(key))
+	checker := newTestChecker(t, line, nil)
+	
+	if err := checker.Callback()("devbox:22", testRemote, key); err != nil {
+		t.Errorf("Expected hashed entry to verify, got %v", err)
+	}
+}
+
+func TestHostKeyMismatch(t *testing.T) {
+	known := newTestHostKey(t)
+	presented := newTestHostKey(t)
+	checker := newTestChecker(t, knownhosts.Line([]string{"devbox:22"}, known)+"\n", func(string, net.Addr, ssh.PublicKey) bool {
+		t.Error("Prompt must not be shown for a changed key")
+		return true
+	})
+	
+	err := checker.Callback()("devbox:22", testRemote, presented)
+	var mismatch *HostKeyMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected HostKeyMismatchError, got %v", err)
+	}
+	
+	text := mismatch.Error()
+	if !strings.Contains(text, "REMOTE HOST IDENTIFICATION HAS CHANGED") {
+		t.Error("Mismatch error should carry the OpenSSH warning banner")
+	}
+	if !strings.Contains(text, ssh.FingerprintSHA256(presented)) {
+		t.Error("Mismatch error should include the presented fingerprint")
+	}
+	if !strings.Contains(text, checker.userFile+":1") {
+		t.Error("Mismatch error should point at the offending known_hosts line")
+	}
+}
+
+func TestHostKeyTrustOnFirstUse(t *testing.T) {
+	key := newTestHostKey(t)
+	prompted := 0
+	checker := newTestChecker(t, "", func(host string, remote net.Addr, k ssh.PublicKey) bool {
+		prompted++
+		return true
+	})
+	
+	if err := checker.Callback()("devbox:22", testRemote, key); err != nil {
+		t.Fatalf("Expected accepted key to verify, got %v", err)
+	}
+	
+	if err := checker.Callback()("devbox:22", testRemote, key); err != nil {
+		t.Fatalf("Expected recorded key to verify, got %v", err)
+	}
+	
+	if prompted != 1 {
+		t.Errorf("Expected a single prompt, got %d", prompted)
+	}
+	
+	data, err := os.ReadFile(checker.userFile)
+	if err != nil {
+		t.Fatalf("Failed to read known_hosts: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "devbox,192.0.2.10 ") {
+		t.Errorf("Unexpected known_hosts entry: %q", data)
+	}
+}
+
+func TestHostKeyDeclined(t *testing.T) {
+	key := newTestHostKey(t)
+	checker := newTestChecker(t, "", func(string, net.Addr, ssh.PublicKey) bool {
+		return false
+	})
+	
+	err := checker.Callback()("devbox:22", testRemote, key)
+	var unknown *HostKeyUnknownError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("Expected HostKeyUnknownError, got %v", err)
+	}
+	
+	if _, err := os.Stat(checker.userFile); !os.IsNotExist(err) {
+		t.Error("Declined key must not be written to known_hosts")
+	}
+}
+
+func TestHostKeyUnattended(t *testing.T) {
+	key := newTestHostKey(t)
+	checker := newTestChecker(t, "", func(string, net.Addr, ssh.PublicKey) bool {
+		t.Error("Prompt must not be shown on a reconnect")
+		return true
+	})
+	
+	err := checker.unattended().Callback()("devbox:22", testRemote, key)
+	var unknown *HostKeyUnknownError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("Expected HostKeyUnknownError, got %v", err)
+	}
+}
+
+func TestHostKeyCertAuthority(t *testing.T) {
+	caPub, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caPriv)
+	if err != nil {
+		t.Fatalf("Failed to create CA signer: %v", err)
+	}
+	caKey, _ := ssh.NewPublicKey(caPub)
+	
+	cert := &ssh.Certificate{
+		Key:             newTestHostKey(t),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"devbox.internal"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatalf("Failed to sign host cert: %v", err)
+	}
+	
+	line := "@cert-authority *.internal " + string(ssh.MarshalAuthorizedKey(caKey))
+	checker := newTestChecker(t, line, nil)
+	
+	if err := checker.Callback()("devbox.internal:22", testRemote, cert); err != nil {
+		t.Errorf("Expected CA-signed host key to verify, got %v", err)
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	key := newTestHostKey(t)
+	checker := newTestChecker(t, knownhosts.Line([]string{"devbox:22"}, key)+"\n", nil)
+	
+	algos := checker.KeyAlgorithms("devbox:22")
+	if len(algos) != 1 || algos[0] != ssh.KeyAlgoED25519 {
+		t.Errorf("Expected [%s], got %v", ssh.KeyAlgoED25519, algos)
+	}
+	
+	if algos := checker.KeyAlgorithms("elsewhere:22"); algos != nil {
+		t.Errorf("Expected no algorithms for unknown host, got %v", algos)
+	}
+}
+func TestHostKeyAlgorithmsWithCertAuthority(t *testing.T) {
+	_, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	caSigner, err := ssh.NewSignerFromKey(caPriv)
+	if err != nil {
+		t.Fatalf("Failed to create CA signer: %v", err)
+	}
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("Failed to create host signer: %v", err)
+	}
+	cert := &ssh.Certificate{
+		Key:             hostSigner.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"devbox.internal"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatalf("Failed to sign host cert: %v", err)
+	}
+	certSigner, err := ssh.NewCertSigner(cert, hostSigner)
+	if err != nil {
+		t.Fatalf("Failed to create cert signer: %v", err)
+	}
+	
+	// An old plain key for the host sits next to the authority
+	ca := string(ssh.MarshalAuthorizedKey(caSigner.PublicKey()))
+	checker := newTestChecker(t,
+		knownhosts.Line([]string{"devbox.internal:22", "other.example:22"}, newTestHostKey(t))+"\n"+
+			"@cert-authority *.internal,!build.internal "+ca, nil)
+			
+	if algos := checker.KeyAlgorithms("devbox.internal:22"); algos != nil {
+		t.Errorf("Expected the defaults for a host under an authority, got %v", algos)
+	}
+	if algos := checker.KeyAlgorithms("other.example:22"); len(algos) != 1 {
+		t.Errorf("Expected only the recorded key type elsewhere, got %v", algos)
+	}
+	if !checker.hasAuthority("devbox.internal:22") || checker.hasAuthority("build.internal:22") ||
+		checker.hasAuthority("devbox.internal:2222") {
+		t.Error("Authority patterns matched the wrong hosts")
+	}
+	
+	hashed := newTestChecker(t, "@cert-authority "+knownhosts.HashHostname("devbox.internal")+" "+ca, nil)
+	if !hashed.hasAuthority("devbox.internal:22") || hashed.hasAuthority("other.internal:22") {
+		t.Error("A hashed authority pattern matched the wrong hosts")
+	}
+	
+	// The host presents only its certificate, which must be negotiable
+	server := &ssh.ServerConfig{NoClientAuth: true}
+	server.AddHostKey(certSigner)
+	serverConn, clientConn := newPipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	go ssh.NewServerConn(serverConn, server)
+	
+	conn, _, _, err := ssh.NewClientConn(clientConn, "devbox.internal:22", &ssh.ClientConfig{
+		User:              "sam",
+		HostKeyCallback:   checker.Callback(),
+		HostKeyAlgorithms: checker.KeyAlgorithms("devbox.internal:22"),
+	})
+	if err != nil {
+		t.Fatalf("Expected the certificate to be accepted, got %v", err)
+	}
+	conn.Close()
+}
\ No newline at end of file
@@ -0,0 +1,191 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// handshakeTimeout bounds each step of the handshake, so a silent
+	// connection cannot hold a seat
+	handshakeTimeout = 10 * time.Second
+	
+	// New connections are accepted at acceptRate per second on average, in
+	// bursts of up to acceptBurst
+	acceptRate  = 10
+	acceptBurst = 20
+	
+	// After lockoutAfter wrong session IDs from one address, it is locked
+	// out for lockoutBase, doubling with each further failure up to
+	// lockoutMax. An address that stays quiet for forgetAfter starts afresh.
+	lockoutAfter = 3
+	lockoutBase  = time.Second
+	lockoutMax   = 5 * time.Minute
+	forgetAfter  = 10 * time.Minute
+)
+
+// rateLimiter is a token bucket.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (l *rateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// attempts tracks wrong session IDs per source address. Joiners through an
+// SSH tunnel all arrive from loopback, and those on a Unix socket or attached
+// in-process share one address too, so locking one out would lock out all of
+// them. Those share one count instead, and once it is over the limit their
+// handshakes are spaced out rather than refused.
+type attempts struct {
+	mu         sync.Mutex
+	sources    map[string]*source
+	local      source // shared by every source that cannot be told apart
+	base       time.Duration
+	countLocal bool // treat local sources like remote ones, for tests
+}
+
+type source struct {
+	failures int
+	until    time.Time // locked out, or for a local source the next turn, until then
+	last     time.Time
+}
+
+func newAttempts() *attempts {
+	return &attempts{sources: make(map[string]*source), base: lockoutBase}
+}
+
+// lockedOut returns how long addr must still wait before trying again. Local
+// sources are never locked out; see backoff.
+func (a *attempts) lockedOut(addr net.Addr) time.Duration {
+	if a.shared(addr) {
+		return 0
+	}
+	
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	
+	if src, ok := a.sources[sourceOf(addr)]; ok {
+		return max(0, time.Until(src.until))
+	}
+	return 0
+}
+
+// backoff returns how long a connection from a local source must wait before
+// its handshake, and books its turn, so that past the limit local attempts
+// come one per lockout period however many arrive at once.
+func (a *attempts) backoff(addr net.Addr) time.Duration {
+	if !a.shared(addr) {
+		return 0
+	}
+	
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	
+	if a.local.failures < lockoutAfter {
+		return 0
+	}
+	now := time.Now()
+	turn := now
+	if a.local.until.After(now) {
+		turn = a.local.until
+	}
+	a.local.until = turn.Add(a.lockout(a.local.failures))
+	return turn.Sub(now)
+}
+
+// fail records a wrong guess from addr and returns the lockout it earned, if
+// any. For a local source it is how long the next attempt is held back.
+func (a *attempts) fail(addr net.Addr) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	
+	now := time.Now()
+	for key, src := range a.sources {
+		if now.Sub(src.last) > forgetAfter && now.After(src.until) {
+			delete(a.sources, key)
+		}
+	}
+	
+	src := &a.local
+	if !a.shared(addr) {
+		key := sourceOf(addr)
+		if src = a.sources[key]; src == nil {
+			src = &source{}
+			a.sources[key] = src
+		}
+	} else if now.Sub(src.last) > forgetAfter && now.After(src.until) {
+		*src = source{}
+	}
+	src.failures++
+	src.last = now
+	
+	if src.failures < lockoutAfter {
+		return 0
+	}
+	lockout := a.lockout(src.failures)
+	if until := now.Add(lockout); until.After(src.until) {
+		src.until = until
+	}
+	return lockout
+}
+
+// lockout is what the given number of failures earns.
+func (a *attempts) lockout(failures int) time.Duration {
+	return min(a.base<<min(failures-lockoutAfter, 20), lockoutMax)
+}
+
+// succeed forgets earlier failures from addr once it gets in.
+func (a *attempts) succeed(addr net.Addr) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	
+	if a.shared(addr) {
+		a.local = source{}
+		return
+	}
+	delete(a.sources, sourceOf(addr))
+}
+
+// shared reports whether addr stands for every joiner arriving the same way,
+// rather than for one remote machine.
+func (a *attempts) shared(addr net.Addr) bool {
+	if a.countLocal {
+		return false
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	return !ok || tcp.IP.IsLoopback()
+}
+
+// sourceOf strips the port, so reconnecting from a new port does not reset
+// the count.
+func sourceOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
\ No newline at end of file
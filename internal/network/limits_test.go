@@ -0,0 +1,206 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		if !limiter.allow() {
+			t.Fatalf("Burst should allow 3, refused #%d", i+1)
+		}
+	}
+	if limiter.allow() {
+		t.Error("Fourth immediate attempt should be refused")
+	}
+	
+	limiter.last = limiter.last.Add(-time.Second)
+	if !limiter.allow() {
+		t.Error("A token should have come back after a second")
+	}
+}
+
+func TestAttemptsLockout(t *testing.T) {
+	a := newAttempts()
+	guesser := &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 40000}
+	other := &net.TCPAddr{IP: net.ParseIP("192.0.2.8"), Port: 40000}
+	
+	for i := 1; i < lockoutAfter; i++ {
+		if lockout := a.fail(guesser); lockout != 0 {
+			t.Fatalf("Failure %d should not lock out yet", i)
+		}
+	}
+	if lockout := a.fail(guesser); lockout != lockoutBase {
+		t.Errorf("Expected a %s lockout, got %s", lockoutBase, lockout)
+	}
+	if lockout := a.fail(guesser); lockout != 2*lockoutBase {
+		t.Errorf("Lockout should double, got %s", lockout)
+	}
+	
+	// A new source port is still the same source
+	guesser.Port++
+	if a.lockedOut(guesser) == 0 {
+		t.Error("Source should be locked out")
+	}
+	if a.lockedOut(other) != 0 {
+		t.Error("Other sources should not be affected")
+	}
+	
+	a.succeed(guesser)
+	if a.lockedOut(guesser) != 0 {
+		t.Error("Getting in should clear the record")
+	}
+	
+	for i := 0; i < 40; i++ {
+		a.fail(other)
+	}
+	if lockout := a.lockedOut(other); lockout > lockoutMax {
+		t.Errorf("Lockout should be capped at %s, got %s", lockoutMax, lockout)
+	}
+}
+
+func TestAttemptsBackOffLocalSources(t *testing.T) {
+	a := newAttempts()
+	local := []net.Addr{
+		&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40000},
+		&net.TCPAddr{IP: net.IPv6loopback, Port: 40000},
+		&net.UnixAddr{Name: "/tmp/termchat/s.sock", Net: "unix"},
+		pipeAddr{},
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 40000}
+	
+	// They cannot be told apart, so they share one count
+	for i := 0; i < lockoutAfter-1; i++ {
+		a.fail(local[i%len(local)])
+	}
+	if wait := a.backoff(pipeAddr{}); wait != 0 {
+		t.Fatalf("Should not back off before %d failures, got %s", lockoutAfter, wait)
+	}
+	if lockout := a.fail(local[0]); lockout != lockoutBase {
+		t.Errorf("Expected a %s backoff, got %s", lockoutBase, lockout)
+	}
+	
+	// Held back, not refused, one turn after another
+	for _, addr := range local {
+		if a.lockedOut(addr) != 0 {
+			t.Errorf("%s should not be locked out", addr)
+		}
+	}
+	first := a.backoff(local[2])
+	second := a.backoff(local[3])
+	if first <= 0 || first > lockoutBase || second <= first {
+		t.Errorf("Expected turns spaced by %s, got %s then %s", lockoutBase, first, second)
+	}
+	if a.backoff(remote) != 0 || a.lockedOut(remote) != 0 {
+		t.Error("Remote sources should not be affected")
+	}
+	
+	a.succeed(local[1])
+	if wait := a.backoff(local[0]); wait != 0 {
+		t.Errorf("Getting in should clear the count, still waiting %s", wait)
+	}
+}
+
+func TestServerLocksOutGuesser(t *testing.T) {
+	rejects := make(chan string, 16)
+	server := startTestServer(t, 5, func(s *Server) {
+		s.attempts.base = time.Minute
+		s.attempts.countLocal = true
+		s.SetRejectCallback(func(addr net.Addr, reason string) { rejects <- reason })
+	})
+	
+	// The same loopback address joinTestServer uses
+	addr := fmt.Sprintf("127.0.0.1:%d", server.Addr().(*net.TCPAddr).Port)
+	for i := 0; i < lockoutAfter; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		if _, err := noiseClient(conn, sessionKey("wrong-guess")); err == nil {
+			t.Fatal("Wrong session ID should fail")
+		}
+		conn.Close()
+		
+		select {
+		case reason := <-rejects:
+			if i == lockoutAfter-1 && reason != "wrong session ID, locked out for 1m0s" {
+				t.Errorf("Expected the lockout to be reported, got %q", reason)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Rejection was not reported")
+		}
+	}
+	
+	// Locked out, so even the right ID is refused before the handshake
+	if _, _, err := joinTestServer(t, server, "alice"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Locked out source should be told it is rate limited, got %v", err)
+	}
+}
+
+func TestServerHandshakeTimeout(t *testing.T) {
+	rejects := make(chan string, 1)
+	server := startTestServer(t, 5, func(s *Server) {
+		s.handshakeTimeout = 50 * time.Millisecond
+		s.SetRejectCallback(func(addr net.Addr, reason string) { rejects <- reason })
+	})
+	
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	
+	// Say nothing; the host should hang up on us
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the host to close a silent connection")
+	}
+	if reason := <-rejects; reason != "handshake timed out" {
+		t.Errorf("Unexpected reason %q", reason)
+	}
+}
+
+func TestServerTunnelledJoinersBackedOff(t *testing.T) {
+	const base = 300 * time.Millisecond
+	rejects := make(chan string, 16)
+	server := startTestServer(t, 5, func(s *Server) {
+		s.attempts.base = base
+		s.SetRejectCallback(func(addr net.Addr, reason string) { rejects <- reason })
+	})
+	
+	// Everyone through an SSH tunnel arrives from loopback, like this guesser
+	addr := fmt.Sprintf("127.0.0.1:%d", server.Addr().(*net.TCPAddr).Port)
+	for i := 0; i < lockoutAfter; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		if _, err := noiseClient(conn, sessionKey("wrong-guess")); err == nil {
+			t.Fatal("Wrong session ID should fail")
+		}
+		conn.Close()
+		
+		select {
+		case reason := <-rejects:
+			if i == lockoutAfter-1 && reason != "wrong session ID, holding back local joiners for 300ms each" {
+				t.Errorf("Expected the backoff to be reported, got %q", reason)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Rejection was not reported")
+		}
+	}
+	
+	// Another tunnelled joiner waits its turn but is not turned away
+	start := time.Now()
+	if _, _, err := joinTestServer(t, server, "alice"); err != nil {
+		t.Fatalf("Another tunnelled joiner should still get in: %v", err)
+	}
+	if waited := time.Since(start); waited < base/2 {
+		t.Errorf("Expected the joiner to be held back, got in after %s", waited)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,120 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// unixPrefix marks a listen address as a Unix socket path.
+const unixPrefix = "unix:"
+
+// BindAddress turns a --bind value into a listen address: an IP or host name
+// gets the port added, "" means every interface, and "unix:/path" is passed
+// through.
+func BindAddress(bind string, port int) string {
+	if strings.HasPrefix(bind, unixPrefix) {
+		return bind
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(bind, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// IsLoopback reports whether a listen address is only reachable from this
+// machine.
+func IsLoopback(addr string) bool {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		return listener, nil
+	}
+	
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	
+	// Bind in a directory only we can enter and move the socket into place
+	// once it is private, so nobody can connect in between
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".termchat-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	defer os.RemoveAll(dir)
+	
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "s"), Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	listener.SetUnlinkOnClose(false)
+	if err := os.Chmod(listener.Addr().String(), 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict %s: %w", path, err)
+	}
+	if err := os.Rename(listener.Addr().String(), path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	return &unixListener{listener, path}, nil
+}
+
+// unixListener is a socket that was moved to path after it was bound, so it
+// reports and removes path rather than where it was created.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// removeStaleSocket deletes a socket left behind by a session that crashed,
+// but refuses to touch one that is still in use or anything else.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s: %w", path, syscall.EADDRINUSE)
+	}
+	return os.Remove(path)
+}
\ No newline at end of file
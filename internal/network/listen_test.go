@@ -0,0 +1,137 @@
+package network
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sam/termchat/internal/session"
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+func TestBindAddress(t *testing.T) {
+	tests := []struct {
+		bind string
+		want string
+	}{
+		{"127.0.0.1", "127.0.0.1:9999"},
+		{"::1", "[::1]:9999"},
+		{"[::1]", "[::1]:9999"},
+		{"", ":9999"},
+		{"devbox.local", "devbox.local:9999"},
+		{"unix:/run/user/1000/termchat.sock", "unix:/run/user/1000/termchat.sock"},
+	}
+	
+	for _, tt := range tests {
+		if got := BindAddress(tt.bind, 9999); got != tt.want {
+			t.Errorf("BindAddress(%q) = %q, want %q", tt.bind, got, tt.want)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:9999", true},
+		{"[::1]:9999", true},
+		{"localhost:9999", true},
+		{"unix:/tmp/termchat.sock", true},
+		{":9999", false},
+		{"0.0.0.0:9999", false},
+		{"192.168.1.20:9999", false},
+	}
+	
+	for _, tt := range tests {
+		if got := IsLoopback(tt.addr); got != tt.want {
+			t.Errorf("IsLoopback(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestServerListensOnSeveralAddresses(t *testing.T) {
+	addrs := []string{"127.0.0.1:0", "unix:" + filepath.Join(t.TempDir(), "termchat.sock")}
+	if ln, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		ln.Close()
+		addrs = append(addrs, "[::1]:0")
+	}
+	
+	sess := session.New()
+	server := NewServer(sess)
+	if err := server.Listen(addrs...); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer server.Stop()
+	
+	listening := server.Addrs()
+	if len(listening) != len(addrs) {
+		t.Fatalf("Expected %d listeners, got %v", len(addrs), listening)
+	}
+	
+	socket := listening[1].String()
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatalf("Socket missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Socket should be private to its owner, got %v", perm)
+	}
+	
+	// Every listener leads to the same session
+	for _, addr := range listening {
+		raw, err := net.Dial(addr.Network(), addr.String())
+		if err != nil {
+			t.Fatalf("Dial %s failed: %v", addr, err)
+		}
+		conn, err := noiseClient(raw, sessionKey(sess.ID))
+		if err != nil {
+			t.Fatalf("Handshake over %s failed: %v", addr, err)
+		}
+		
+		hello := protocol.NewHandshakeMessage(protocol.MessageTypeHello, sess.ID)
+		json.NewEncoder(conn).Encode(hello)
+		var welcome protocol.Message
+		if err := json.NewDecoder(conn).Decode(&welcome); err != nil || welcome.Type != protocol.MessageTypeWelcome {
+			t.Errorf("Expected WELCOME over %s, got %+v (%v)", addr, welcome, err)
+		}
+		raw.Close()
+	}
+	
+	// Nothing is left behind where the socket was made private
+	server.Stop()
+	if entries, _ := os.ReadDir(filepath.Dir(socket)); len(entries) != 0 {
+		t.Errorf("Expected the socket directory to be empty, found %d entries", len(entries))
+	}
+}
+
+func TestListenRefusesLiveSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "termchat.sock")
+	
+	first := NewServer(session.New())
+	if err := first.Listen("unix:" + path); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer first.Stop()
+	
+	second := NewServer(session.New())
+	if err := second.Listen("unix:" + path); err == nil {
+		second.Stop()
+		t.Error("Expected a socket in use to be refused")
+	}
+	
+	// Never delete something that is not a socket
+	plain := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(plain, []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Listen("unix:" + plain); err == nil {
+		second.Stop()
+		t.Error("Expected a regular file to be refused")
+	}
+	if _, err := os.Stat(plain); err != nil {
+		t.Errorf("File was removed: %v", err)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,218 @@
+package network
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/flynn/noise"
+	"github.com/sam/termchat/pkg/protocol"
+	"golang.org/x/crypto/argon2"
+)
+
+// Every connection is wrapped in Noise_NNpsk0_25519_ChaChaPoly_BLAKE2s. Both
+// sides use fresh ephemeral keys and mix in a pre-shared key derived from the
+// session ID, so only someone who knows the ID can complete the handshake and
+// recorded traffic stays secret even if the ID leaks later.
+const noisePrologue = "termchat noise v1"
+
+// maxNoisePayload is the largest plaintext that fits in one Noise message.
+const maxNoisePayload = 65535 - 16
+
+// noiseReplySize is the length of the responder's handshake message: an
+// ephemeral key and the tag of an empty payload. Anything shorter is a
+// refusal.
+const noiseReplySize = 32 + 16
+
+// errWrongKey means the joiner does not know the session credential.
+var errWrongKey = errors.New("wrong session ID")
+
+var noiseSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+
+// sessionKey stretches a session credential (the ID plus any secret) into the
+// 32-byte Noise PSK. Argon2id makes guessing it from a captured handshake
+// expensive.
+func sessionKey(credential string) []byte {
+	return argon2.IDKey([]byte(credential), []byte(noisePrologue), 1, 64*1024, 4, 32)
+}
+
+// noiseConn encrypts everything written to the underlying connection. Each
+// Noise message travels as a 2-byte big-endian length followed by the
+// ciphertext.
+type noiseConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	send    *noise.CipherState
+	recv    *noise.CipherState
+	hash    []byte
+	pending []byte // decrypted but not yet read
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+}
+
+// noiseClient runs the initiator side of the handshake.
+func noiseClient(conn net.Conn, psk []byte) (*noiseConn, error) {
+	hs, err := newNoiseHandshake(psk, true)
+	if err != nil {
+		return nil, err
+	}
+	
+	reader := bufio.NewReader(conn)
+	msg, _, _, err := hs.WriteMessage(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, msg); err != nil {
+		return nil, err
+	}
+	
+	reply, err := readFrame(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) == 0 {
+		return nil, &HostError{Code: protocol.ErrorCodeSessionMismatch, Message: "Session ID mismatch"}
+	}
+	if len(reply) < noiseReplySize {
+		// Not a reply but the code of the reason we were turned away
+		code := protocol.ErrorCode(reply)
+		return nil, &HostError{Code: code, Message: code.Description()}
+	}
+	
+	_, send, recv, err := hs.ReadMessage(nil, reply)
+	if err != nil {
+		return nil, fmt.Errorf("invalid handshake reply: %w", err)
+	}
+	
+	return &noiseConn{Conn: conn, reader: reader, send: send, recv: recv, hash: hs.ChannelBinding()}, nil
+}
+
+// noiseServer runs the responder side. A joiner that does not know the
+// session ID fails to authenticate and gets an empty frame back.
+func noiseServer(conn net.Conn, reader *bufio.Reader, psk []byte) (*noiseConn, error) {
+	hs, err := newNoiseHandshake(psk, false)
+	if err != nil {
+		return nil, err
+	}
+	
+	msg, err := readFrame(reader)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, _, err := hs.ReadMessage(nil, msg); err != nil {
+		writeFrame(conn, nil)
+		return nil, fmt.Errorf("%w: %v", errWrongKey, err)
+	}
+	
+	reply, recv, send, err := hs.WriteMessage(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, reply); err != nil {
+		return nil, err
+	}
+	
+	return &noiseConn{Conn: conn, reader: reader, send: send, recv: recv, hash: hs.ChannelBinding()}, nil
+}
+
+// noiseRefuse turns a joiner away before the handshake, answering its first
+// message with code in place of a reply, and closes the connection.
+func noiseRefuse(conn net.Conn, code protocol.ErrorCode, timeout time.Duration) {
+	defer conn.Close()
+	
+	// Read what the joiner sent first, or closing may reset the connection
+	// before it has read the answer
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := readFrame(bufio.NewReader(conn)); err != nil {
+		return
+	}
+	writeFrame(conn, []byte(code))
+}
+
+func newNoiseHandshake(psk []byte, initiator bool) (*noise.HandshakeState, error) {
+	return noise.NewHandshakeState(noise.Config{
+		CipherSuite:           noiseSuite,
+		Random:                rand.Reader,
+		Pattern:               noise.HandshakeNN,
+		Initiator:             initiator,
+		Prologue:              []byte(noisePrologue),
+		PresharedKey:          psk,
+		PresharedKeyPlacement: 0,
+	})
+}
+
+func (c *noiseConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	
+	for len(c.pending) == 0 {
+		frame, err := readFrame(c.reader)
+		if err != nil {
+			return 0, err
+		}
+		c.pending, err = c.recv.Decrypt(nil, nil, frame)
+		if err != nil {
+			return 0, fmt.Errorf("decrypt: %w", err)
+		}
+	}
+	
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *noiseConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	
+	written := 0
+	for written < len(b) {
+		chunk := b[written:]
+		if len(chunk) > maxNoisePayload {
+			chunk = chunk[:maxNoisePayload]
+		}
+		
+		ciphertext, err := c.send.Encrypt(nil, nil, chunk)
+		if err != nil {
+			return written, err
+		}
+		if err := writeFrame(c.Conn, ciphertext); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// HandshakeHash identifies this connection's handshake. Both ends compute
+// the same value and nobody else can.
+func (c *noiseConn) HandshakeHash() []byte {
+	return c.hash
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	
+	frame := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func writeFrame(w io.Writer, frame []byte) error {
+	buf := make([]byte, 2+len(frame))
+	binary.BigEndian.PutUint16(buf, uint16(len(frame)))
+	copy(buf[2:], frame)
+	_, err := w.Write(buf)
+	return err
+}
\ No newline at end of file
@@ -0,0 +1,134 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+// noisePair runs both sides of the handshake over an in-memory pipe.
+func noisePair(t *testing.T, clientPSK, serverPSK []byte) (*noiseConn, *noiseConn, error, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	
+	type result struct {
+		conn *noiseConn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := noiseServer(b, bufio.NewReader(b), serverPSK)
+		if err != nil {
+			b.Close()
+		}
+		done <- result{conn, err}
+	}()
+	
+	client, clientErr := noiseClient(a, clientPSK)
+	server := <-done
+	return client, server.conn, clientErr, server.err
+}
+
+func TestNoiseRoundTrip(t *testing.T) {
+	psk := sessionKey("cosmic-turtle-7823")
+	client, server, clientErr, serverErr := noisePair(t, psk, psk)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("Handshake failed: client %v, server %v", clientErr, serverErr)
+	}
+	
+	if !bytes.Equal(client.HandshakeHash(), server.HandshakeHash()) {
+		t.Error("Both sides should agree on the handshake hash")
+	}
+	
+	// Larger than one Noise message, so it is split across frames
+	payload := bytes.Repeat([]byte("termchat "), 20000)
+	go client.Write(payload)
+	
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("Payload corrupted in transit")
+	}
+}
+
+func TestNoiseWrongSessionID(t *testing.T) {
+	_, _, clientErr, serverErr := noisePair(t, sessionKey("cosmic-turtle-7823"), sessionKey("cosmic-turtle-7824"))
+	if serverErr == nil {
+		t.Error("Server should reject a joiner with the wrong session ID")
+	}
+	
+	var hostErr *HostError
+	if !errors.As(clientErr, &hostErr) || !errors.Is(clientErr, ErrSessionMismatch) {
+		t.Errorf("Expected the client to be told it was rejected, got %v", clientErr)
+	}
+}
+
+func TestNoiseTraffic(t *testing.T) {
+	psk := sessionKey("cosmic-turtle-7823")
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	
+	go func() {
+		if conn, err := noiseServer(b, bufio.NewReader(b), psk); err == nil {
+			io.Copy(io.Discard, conn)
+		}
+	}()
+	
+	// Watch what actually crosses the wire
+	var wire bytes.Buffer
+	client, err := noiseClient(&tapConn{Conn: a, tap: &wire}, psk)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	json.NewEncoder(client).Encode(protocol.NewMessage(protocol.MessageTypeText, "attack at dawn"))
+	
+	if bytes.Contains(wire.Bytes(), []byte("attack at dawn")) {
+		t.Error("Message content visible on the wire")
+	}
+}
+
+func TestServerRefusesPlaintextJoiner(t *testing.T) {
+	server := startTestServer(t, 5)
+	
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	
+	// A joiner from before encryption sends its HELLO in the clear
+	hello := protocol.NewHandshakeMessage(protocol.MessageTypeHello, server.session.ID)
+	hello.Version = "1.0"
+	json.NewEncoder(conn).Encode(hello)
+	
+	var reply protocol.Message
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	if reply.Type != protocol.MessageTypeError || reply.Version != protocol.ProtocolVersion {
+		t.Errorf("Expected a version error, got %+v", reply)
+	}
+}
+
+type tapConn struct {
+	net.Conn
+	tap *bytes.Buffer
+}
+
+func (c *tapConn) Write(b []byte) (int, error) {
+	c.tap.Write(b)
+	return c.Conn.Write(b)
+}
\ No newline at end of file
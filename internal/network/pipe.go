@@ -0,0 +1,207 @@
+package network
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// pipeBufferSize bounds what one end of a pipe can write before the other
+// reads it, as a socket buffer would.
+const pipeBufferSize = 256 << 10
+
+// newPipe returns the two ends of an in-process connection. Unlike net.Pipe,
+// a write is buffered for the other end instead of waiting for it to read,
+// up to pipeBufferSize. Both sides of a session write while holding locks
+// that their own readers need, so two ends waiting on each other would
+// freeze both; the host's side never waits, as it writes from a queue.
+func newPipe() (net.Conn, net.Conn) {
+	a, b := newPipeQueue(), newPipeQueue()
+	return newPipeConn(a, b), newPipeConn(b, a)
+}
+
+// pipeQueue is what one end has written and the other has not read yet.
+type pipeQueue struct {
+	mu     sync.Mutex
+	data   []byte
+	closed bool
+	wake   chan struct{} // closed when data arrives, is read, or the queue closes
+}
+
+func newPipeQueue() *pipeQueue {
+	return &pipeQueue{wake: make(chan struct{})}
+}
+
+func (q *pipeQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	if !q.closed {
+		q.closed = true
+		q.signal()
+	}
+}
+
+// signal wakes everyone waiting on q. Call with q.mu held.
+func (q *pipeQueue) signal() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// pipeDeadline is a deadline that wakes whoever waits on it when it changes.
+type pipeDeadline struct {
+	mu      sync.Mutex
+	t       time.Time
+	changed chan struct{}
+}
+
+func (d *pipeDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	
+	d.t = t
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+type pipeConn struct {
+	in, out *pipeQueue
+	
+	readDeadline  pipeDeadline
+	writeDeadline pipeDeadline
+	
+	done chan struct{} // closed by Close
+	once sync.Once
+}
+
+func newPipeConn(in, out *pipeQueue) *pipeConn {
+	c := &pipeConn{in: in, out: out, done: make(chan struct{})}
+	c.readDeadline.changed = make(chan struct{})
+	c.writeDeadline.changed = make(chan struct{})
+	return c
+}
+
+// wait blocks until wake fires, d changes or passes, or c is closed.
+func (c *pipeConn) wait(d *pipeDeadline, wake chan struct{}) error {
+	d.mu.Lock()
+	deadline, changed := d.t, d.changed
+	d.mu.Unlock()
+	
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	
+	select {
+	case <-wake:
+	case <-changed:
+	case <-expired:
+	case <-c.done:
+	}
+	return nil
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	for {
+		select {
+		case <-c.done:
+			return 0, io.ErrClosedPipe
+		default:
+		}
+		
+		c.in.mu.Lock()
+		if len(c.in.data) > 0 {
+			n := copy(b, c.in.data)
+			c.in.data = c.in.data[n:]
+			if len(c.in.data) == 0 {
+				c.in.data = nil
+			}
+			// Room for a writer that was waiting
+			c.in.signal()
+			c.in.mu.Unlock()
+			return n, nil
+		}
+		closed, wake := c.in.closed, c.in.wake
+		c.in.mu.Unlock()
+		if closed {
+			return 0, io.EOF
+		}
+		
+		if err := c.wait(&c.readDeadline, wake); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write waits while the other end has pipeBufferSize bytes unread, until the
+// write deadline. A single write larger than that goes into an empty buffer.
+func (c *pipeConn) Write(b []byte) (int, error) {
+	for {
+		select {
+		case <-c.done:
+			return 0, io.ErrClosedPipe
+		default:
+		}
+		
+		c.out.mu.Lock()
+		if c.out.closed {
+			c.out.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if len(c.out.data) == 0 || len(c.out.data)+len(b) <= pipeBufferSize {
+			c.out.data = append(c.out.data, b...)
+			c.out.signal()
+			c.out.mu.Unlock()
+			return len(b), nil
+		}
+		wake := c.out.wake
+		c.out.mu.Unlock()
+		
+		if err := c.wait(&c.writeDeadline, wake); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Close ends both directions. The other end can still read what was written
+// before, then gets EOF.
+func (c *pipeConn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		c.out.close()
+		c.in.close()
+	})
+	return nil
+}
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr  { return pipeAddr{} }
+func (c *pipeConn) RemoteAddr() net.Addr { return pipeAddr{} }
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
\ No newline at end of file
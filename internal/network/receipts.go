@@ -0,0 +1,90 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+// ackTimeout is how long a sent message may go unacknowledged before it is
+// reported as failed.
+const ackTimeout = 10 * time.Second
+
+// receiptTracker follows the TEXT messages we send until an ACK or READ
+// comes back for them.
+type receiptTracker struct {
+	pending  map[string]*time.Timer
+	timeout  time.Duration
+	onStatus func(id string, status protocol.DeliveryStatus)
+	mu       sync.Mutex
+}
+
+func newReceiptTracker() *receiptTracker {
+	return &receiptTracker{
+		pending: make(map[string]*time.Timer),
+		timeout: ackTimeout,
+	}
+}
+
+func (r *receiptTracker) setCallback(onStatus func(id string, status protocol.DeliveryStatus)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onStatus = onStatus
+}
+
+// track starts the acknowledgement timer for a message about to be sent.
+// Sending the same message again restarts it.
+func (r *receiptTracker) track(id string) {
+	r.mu.Lock()
+	if timer := r.pending[id]; timer != nil {
+		timer.Stop()
+	}
+	r.pending[id] = time.AfterFunc(r.timeout, func() { r.fail(id) })
+	r.mu.Unlock()
+	
+	r.report(id, protocol.StatusSending)
+}
+
+// fail gives up on a message that timed out or could not be written.
+func (r *receiptTracker) fail(id string) {
+	r.mu.Lock()
+	timer := r.pending[id]
+	delete(r.pending, id)
+	r.mu.Unlock()
+	
+	if timer == nil {
+		return
+	}
+	timer.Stop()
+	r.report(id, protocol.StatusFailed)
+}
+
+// receipt records an ACK or READ for one of our messages. With several
+// peers the first of each kind decides the status.
+func (r *receiptTracker) receipt(msg protocol.Message) {
+	r.mu.Lock()
+	if timer := r.pending[msg.Ref]; timer != nil {
+		timer.Stop()
+		delete(r.pending, msg.Ref)
+	}
+	r.mu.Unlock()
+	
+	status := protocol.StatusDelivered
+	if msg.Type == protocol.MessageTypeRead {
+		status = protocol.StatusRead
+	}
+	r.report(msg.Ref, status)
+}
+
+// report runs the callback on its own goroutine: it is usually the UI, which
+// may be holding its lock while it sends.
+func (r *receiptTracker) report(id string, status protocol.DeliveryStatus) {
+	r.mu.Lock()
+	onStatus := r.onStatus
+	r.mu.Unlock()
+	
+	if onStatus != nil {
+		go onStatus(id, status)
+	}
+}
\ No newline at end of file
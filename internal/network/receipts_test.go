@@ -0,0 +1,69 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+type statusEvent struct {
+	id     string
+	status protocol.DeliveryStatus
+}
+
+func newStatusRecorder() (chan statusEvent, func(string, protocol.DeliveryStatus)) {
+	events := make(chan statusEvent, 64)
+	return events, func(id string, status protocol.DeliveryStatus) {
+		events <- statusEvent{id, status}
+	}
+}
+
+// waitForStatus waits until id reaches status, ignoring earlier reports.
+func waitForStatus(t *testing.T, events chan statusEvent, id string, status protocol.DeliveryStatus) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.id == id && ev.status == status {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %s to be %s", id, status)
+		}
+	}
+}
+
+func TestReceiptTrackerTimeout(t *testing.T) {
+	events, onStatus := newStatusRecorder()
+	tracker := newReceiptTracker()
+	tracker.timeout = 20 * time.Millisecond
+	tracker.setCallback(onStatus)
+	
+	tracker.track("m1")
+	waitForStatus(t, events, "m1", protocol.StatusFailed)
+	
+	// A receipt that arrives late still counts
+	tracker.receipt(*protocol.NewReceipt(protocol.MessageTypeAck, "m1"))
+	waitForStatus(t, events, "m1", protocol.StatusDelivered)
+}
+
+func TestReceiptTrackerAck(t *testing.T) {
+	events, onStatus := newStatusRecorder()
+	tracker := newReceiptTracker()
+	tracker.timeout = 50 * time.Millisecond
+	tracker.setCallback(onStatus)
+	
+	tracker.track("m1")
+	tracker.receipt(*protocol.NewReceipt(protocol.MessageTypeAck, "m1"))
+	tracker.receipt(*protocol.NewReceipt(protocol.MessageTypeRead, "m1"))
+	waitForStatus(t, events, "m1", protocol.StatusRead)
+	
+	time.Sleep(100 * time.Millisecond)
+	for len(events) > 0 {
+		if ev := <-events; ev.status == protocol.StatusFailed {
+			t.Error("Acknowledged message must not time out")
+		}
+	}
+}
\ No newline at end of file
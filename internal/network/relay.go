@@ -0,0 +1,37 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+
+	"github.com/sam/termchat/internal/relay"
+)
+
+// relayPrefix marks a listen address as a relay to host the session at.
+const relayPrefix = "relay:"
+
+// relayTag names a session at a relay. It comes from the session key, so the
+// relay can pair peers without learning anything that would let it join.
+func relayTag(psk []byte) string {
+	sum := sha256.Sum256(append([]byte("termchat relay v1"), psk...))
+	return hex.EncodeToString(sum[:])
+}
+
+// listenAt opens one of the addresses given to Listen.
+func (s *Server) listenAt(addr string) (net.Listener, error) {
+	if relayAddr, ok := strings.CutPrefix(addr, relayPrefix); ok {
+		return relay.Listen(relayAddr, relayTag(s.psk))
+	}
+	return listen(addr)
+}
+
+// ConnectRelay joins a session whose host registered at the relay at addr.
+// The relay helps both sides punch through to each other and carries the
+// session itself only if that fails.
+func (c *Client) ConnectRelay(addr string, sessionID string) error {
+	return c.Connect(sessionID, TransportFunc(func() (net.Conn, error) {
+		return relay.Dial(addr, relayTag(c.psk))
+	}))
+}
\ No newline at end of file
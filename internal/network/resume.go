@@ -0,0 +1,63 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+const (
+	// resumeWindow is how long a dropped peer may take to come back before
+	// the host gives up its seat and the client stops retrying.
+	resumeWindow = 2 * time.Minute
+	
+	// heartbeatInterval is how often a resumable client pings the host. A
+	// link silent for three intervals is treated as dead.
+	heartbeatInterval = 15 * time.Second
+	
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 15 * time.Second
+	
+	// maxOutbox bounds how many sent messages are kept for replay.
+	maxOutbox = 1000
+)
+
+// outbox numbers the messages sent on one link and keeps them so they can be
+// replayed to the other side after it reconnects. Callers provide locking.
+type outbox struct {
+	seq      uint64
+	messages []protocol.Message
+}
+
+// add assigns msg the next sequence number and returns the copy to send.
+func (o *outbox) add(msg *protocol.Message) protocol.Message {
+	o.seq++
+	out := *msg
+	out.Seq = o.seq
+	
+	o.messages = append(o.messages, out)
+	if len(o.messages) > maxOutbox {
+		o.messages = o.messages[len(o.messages)-maxOutbox:]
+	}
+	return out
+}
+
+// since returns the messages after seq, the last one the other side has
+// confirmed, and forgets everything up to it.
+func (o *outbox) since(seq uint64) []protocol.Message {
+	i := 0
+	for i < len(o.messages) && o.messages[i].Seq <= seq {
+		i++
+	}
+	o.messages = o.messages[i:]
+	return append([]protocol.Message(nil), o.messages...)
+}
+
+// newResumeToken returns the secret a peer presents to take back its seat.
+func newResumeToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
\ No newline at end of file
@@ -0,0 +1,150 @@
+package network
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+func TestOutbox(t *testing.T) {
+	var o outbox
+	for i := 0; i < 5; i++ {
+		if out := o.add(protocol.NewMessage(protocol.MessageTypeText, "hi")); out.Seq != uint64(i+1) {
+			t.Fatalf("Expected seq %d, got %d", i+1, out.Seq)
+		}
+	}
+	
+	missed := o.since(3)
+	if len(missed) != 2 || missed[0].Seq != 4 || missed[1].Seq != 5 {
+		t.Errorf("Expected messages 4 and 5, got %+v", missed)
+	}
+	if len(o.messages) != 2 {
+		t.Errorf("Confirmed messages should be dropped, %d left", len(o.messages))
+	}
+	
+	for i := 0; i < maxOutbox+10; i++ {
+		o.add(protocol.NewMessage(protocol.MessageTypeText, "hi"))
+	}
+	if len(o.messages) != maxOutbox {
+		t.Errorf("Outbox should hold at most %d messages, has %d", maxOutbox, len(o.messages))
+	}
+}
+
+func TestClientResumesAfterDrop(t *testing.T) {
+	server := startTestServer(t, 5)
+	
+	alice, aliceMessages, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	_, bobMessages, err := joinTestServer(t, server, "bob")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	
+	// Cut Alice's connection without a LEAVE, as a network drop would
+	alice.mu.Lock()
+	alice.conn.Close()
+	alice.mu.Unlock()
+	
+	waitFor(t, bobMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeAway && msg.Content == "alice"
+	})
+	
+	// Both sides keep talking while Alice is gone
+	server.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "missed you"))
+	if err := alice.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "queued")); err != nil {
+		t.Fatalf("Send while reconnecting should be queued, got %v", err)
+	}
+	
+	waitFor(t, bobMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeBack && msg.Content == "alice"
+	})
+	waitFor(t, aliceMessages, isText("missed you"))
+	waitFor(t, bobMessages, isText("queued"))
+	
+	server.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "after"))
+	waitFor(t, aliceMessages, isText("after"))
+	for len(aliceMessages) > 0 {
+		if msg := <-aliceMessages; msg.Type == protocol.MessageTypeText {
+			t.Errorf("Unexpected replayed message %q", msg.Content)
+		}
+	}
+	
+	if roster := server.Roster(); len(roster) != 3 {
+		t.Errorf("Alice should have kept her seat, roster is %v", roster)
+	}
+}
+
+func TestServerGivesUpOnAwayPeer(t *testing.T) {
+	server := startTestServer(t, 5, func(s *Server) {
+		s.resumeWindow = 50 * time.Millisecond
+	})
+	
+	_, bobMessages, err := joinTestServer(t, server, "bob")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	
+	conn := dialTestServer(t, server)
+	encoder := json.NewEncoder(conn)
+	decoder := json.NewDecoder(conn)
+	
+	hello := protocol.NewHandshakeMessage(protocol.MessageTypeHello, server.session.ID)
+	hello.Name = "carol"
+	hello.Capabilities = []string{protocol.CapResume}
+	encoder.Encode(hello)
+	
+	var welcome protocol.Message
+	if err := decoder.Decode(&welcome); err != nil || welcome.Token == "" {
+		t.Fatalf("Expected a resume token, got %+v (%v)", welcome, err)
+	}
+	encoder.Encode(protocol.NewMessage(protocol.MessageTypeReady, ""))
+	
+	waitFor(t, bobMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeJoined && msg.Content == "carol"
+	})
+	conn.Close()
+	
+	waitFor(t, bobMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeAway && msg.Content == "carol"
+	})
+	waitFor(t, bobMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeLeft && msg.Content == "carol"
+	})
+	
+	// The token is worthless once the seat is gone
+	conn = dialTestServer(t, server)
+	hello.Token = welcome.Token
+	json.NewEncoder(conn).Encode(hello)
+	var reply protocol.Message
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil || reply.Type != protocol.MessageTypeError {
+		t.Errorf("Expected resume to be refused, got %+v (%v)", reply, err)
+	}
+}
+
+func TestStallGuardClosesStalledLink(t *testing.T) {
+	// Nobody reads the other end, so a write never completes by itself
+	conn, other := net.Pipe()
+	defer other.Close()
+	
+	start := time.Now()
+	_, err := stallGuard{conn, 50 * time.Millisecond}.Write([]byte("ping"))
+	if err == nil {
+		t.Fatal("Expected the stalled write to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Gave up after %s", elapsed)
+	}
+	
+	// Closed, so the read loop notices too
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the connection to be closed")
+	}
+}
\ No newline at end of file
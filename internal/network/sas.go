@@ -0,0 +1,21 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// sasLabel keeps the verification code independent of other uses of the
+// handshake hash.
+const sasLabel = "termchat sas v1"
+
+// shortAuthString turns a Noise handshake hash into a six-digit code for two
+// people to read to each other. The hash covers both sides' ephemeral keys,
+// so anyone relaying the connection ends up with a different code on each
+// side.
+func shortAuthString(hash []byte) string {
+	sum := sha256.Sum256(append([]byte(sasLabel), hash...))
+	n := binary.BigEndian.Uint32(sum[:4]) % 1000000
+	return fmt.Sprintf("%03d %03d", n/1000, n%1000)
+}
\ No newline at end of file
@@ -0,0 +1,48 @@
+package network
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestSASAgreesBetweenHostAndJoiner(t *testing.T) {
+	server := startTestServer(t, 5)
+	
+	alice, _, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	bob, _, err := joinTestServer(t, server, "bob")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	
+	code := alice.SAS()
+	if !regexp.MustCompile(`^\d{3} \d{3}$`).MatchString(code) {
+		t.Fatalf("Unexpected code format %q", code)
+	}
+	if got := server.SAS("alice"); got != code {
+		t.Errorf("Host sees %q, alice sees %q", got, code)
+	}
+	
+	// Every connection has its own ephemeral keys and so its own code
+	if bob.SAS() == code {
+		t.Error("Different joiners should not share a code")
+	}
+	if alice.HostName() != "host" {
+		t.Errorf("Expected host name, got %q", alice.HostName())
+	}
+	if server.SAS("nobody") != "" {
+		t.Error("Unknown peers have no code")
+	}
+}
+
+func TestShortAuthString(t *testing.T) {
+	a := shortAuthString([]byte("handshake one"))
+	if a != shortAuthString([]byte("handshake one")) {
+		t.Error("Code should be deterministic")
+	}
+	if a == shortAuthString([]byte("handshake two")) {
+		t.Error("Different handshakes should give different codes")
+	}
+}
\ No newline at end of file
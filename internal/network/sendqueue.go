@@ -0,0 +1,122 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// sendQueueSize bounds what the host has queued for one joiner. A joiner
+	// that falls this far behind has stopped reading and is dropped.
+	sendQueueSize = 4 << 20
+	
+	// flushTimeout bounds how long a closed connection may take to send
+	// what is still queued, such as a LEAVE.
+	flushTimeout = 5 * time.Second
+)
+
+var errSendQueueFull = errors.New("peer is not reading, send queue full")
+
+// queuedConn hands writes to a goroutine of its own, so a joiner that stops
+// reading fills its queue instead of blocking whoever is writing to it, such
+// as a broadcast to everyone.
+type queuedConn struct {
+	net.Conn
+	limit int
+	
+	mu      sync.Mutex
+	data    []byte
+	pending int // bytes queued or being written
+	closed  bool
+	err     error
+	wake    chan struct{} // closed when data arrives or the conn closes
+}
+
+func newQueuedConn(conn net.Conn, limit int) *queuedConn {
+	q := &queuedConn{Conn: conn, limit: limit, wake: make(chan struct{})}
+	go q.flush()
+	return q
+}
+
+// Write queues b. It fails once the connection is closed or broken, and
+// closes the connection if b would take the queue past its limit. A single
+// write larger than the limit is accepted into an empty queue.
+func (q *queuedConn) Write(b []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	if q.err != nil {
+		return 0, q.err
+	}
+	if q.closed {
+		return 0, net.ErrClosed
+	}
+	if q.pending > 0 && q.pending+len(b) > q.limit {
+		q.err = errSendQueueFull
+		q.Conn.Close()
+		q.signal()
+		return 0, q.err
+	}
+	q.data = append(q.data, b...)
+	q.pending += len(b)
+	q.signal()
+	return len(b), nil
+}
+
+// Close sends what is queued and then closes the connection, giving up after
+// flushTimeout. It does not wait for either.
+func (q *queuedConn) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	
+	if !q.closed {
+		q.closed = true
+		q.signal()
+		time.AfterFunc(flushTimeout, func() { q.Conn.Close() })
+	}
+	return nil
+}
+
+// signal wakes the writer. Call with q.mu held.
+func (q *queuedConn) signal() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// unqueued returns the connection under a queuedConn, or conn itself.
+func unqueued(conn net.Conn) net.Conn {
+	if q, ok := conn.(*queuedConn); ok {
+		return q.Conn
+	}
+	return conn
+}
+
+func (q *queuedConn) flush() {
+	defer q.Conn.Close()
+	
+	for {
+		q.mu.Lock()
+		data, closed, failed, wake := q.data, q.closed, q.err != nil, q.wake
+		q.data = nil
+		q.mu.Unlock()
+		
+		if failed || (closed && len(data) == 0) {
+			return
+		}
+		if len(data) == 0 {
+			<-wake
+			continue
+		}
+		
+		_, err := q.Conn.Write(data)
+		q.mu.Lock()
+		q.pending -= len(data)
+		if err != nil && q.err == nil {
+			// The read loop notices the closed connection
+			q.err = err
+		}
+		q.mu.Unlock()
+	}
+}
\ No newline at end of file
@@ -1,189 +1,1144 @@
 package network
 
 import (
-	"encoding/json"
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/sam/termchat/internal/session"
 	"github.com/sam/termchat/pkg/protocol"
 )
 
+const DefaultMaxPeers = 5
+
+// approvalTimeout is how long a joiner waits for the host to let them in.
+const approvalTimeout = 2 * time.Minute
+
+// maxOrigins bounds how many relayed message IDs are remembered for routing
+// receipts back to their sender.
+const maxOrigins = 1024
+
+// Server is the hub of a session: every joiner connects to it and text from
+// any participant is fanned out to everyone else.
 type Server struct {
-	session    *session.Session
-	listener   net.Listener
-	conn       net.Conn
-	encoder    *json.Encoder
-	decoder    *json.Decoder
-	mu         sync.Mutex
+	session   *session.Session
+	listeners []net.Listener
+	psk       []byte // Noise pre-shared key derived from the session ID
+	peers     map[string]*peer
+	pending   map[string]bool // names reserved by peers mid-handshake
+	nextPeer  int
+	maxPeers  int
+	offered   []string // capabilities we are willing to negotiate
+	codecs    []string // wire codecs a joiner may pick from, preferred first
+	maxFrame  int      // largest message accepted from a peer
+	receipts  *receiptTracker
+	origins   map[string]*peer // message ID -> peer that sent it
+	order     []string         // origins keys, oldest first
+	stopped   bool
+	mu        sync.Mutex
 	
-	onMessage  func(protocol.Message)
-	onConnect  func()
-	onDisconnect func()
+	limiter  *rateLimiter
+	attempts *attempts
+	
+	resumeWindow     time.Duration
+	approvalTimeout  time.Duration
+	handshakeTimeout time.Duration
+	sendQueueSize    int
+	
+	onMessage func(protocol.Message)
+	onJoin    func(name string)
+	onLeave   func(name string)
+	approve   func(ctx context.Context, req JoinRequest) bool
+	onReject  func(addr net.Addr, reason string)
+}
+
+// errJoinRefused means the host turned a joiner away, so there is nothing to
+// report back to them.
+var errJoinRefused = errors.New("join refused")
+
+// JoinRequest describes someone waiting to be let into the session.
+type JoinRequest struct {
+	Name    string   // display name they were given
+	Addr    net.Addr // where the connection came from
+	SSHUser string   // the SSH user they say they logged in as, if any
+	Key     string   // fingerprint of the SSH key the host verified, if any
+}
+
+type peer struct {
+	name    string
+	caps    []string // negotiated in the handshake
+	token   string   // lets the peer take its seat back after a drop
+	sas     string   // verification code from the peer's first handshake
+	conn    net.Conn // nil while the peer is away
+	encoder protocol.Encoder
+	sent    outbox
+	recvSeq uint64      // last sequence number received from the peer
+	away    *time.Timer // gives up on the peer if it does not come back
+	gone    bool
+	mu      sync.Mutex
 }
 
 func NewServer(sess *session.Session) *Server {
 	return &Server{
-		session: sess,
+		session:  sess,
+		peers:    make(map[string]*peer),
+		pending:  make(map[string]bool),
+		maxPeers: DefaultMaxPeers,
+		offered:  protocol.SupportedCapabilities(),
+		codecs:   protocol.SupportedCodecs(),
+		receipts: newReceiptTracker(),
+		origins:  make(map[string]*peer),
+		
+		limiter:  newRateLimiter(acceptRate, acceptBurst),
+		attempts: newAttempts(),
+		
+		resumeWindow:     resumeWindow,
+		approvalTimeout:  approvalTimeout,
+		handshakeTimeout: handshakeTimeout,
+		sendQueueSize:    sendQueueSize,
 	}
 }
 
-func (s *Server) SetCallbacks(onMessage func(protocol.Message), onConnect, onDisconnect func()) {
+func (s *Server) SetCallbacks(onMessage func(protocol.Message), onJoin, onLeave func(name string)) {
 	s.onMessage = onMessage
-	s.onConnect = onConnect
-	s.onDisconnect = onDisconnect
+	s.onJoin = onJoin
+	s.onLeave = onLeave
 }
 
+func (s *Server) SetMaxPeers(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxPeers = n
+}
+
+// SetApprover makes new joiners wait until approve lets them in. approve must
+// return once ctx is done, which happens if the host takes too long; the
+// joiner is turned away in that case. Resuming peers are not asked about again.
+func (s *Server) SetApprover(approve func(ctx context.Context, req JoinRequest) bool) {
+	s.approve = approve
+}
+
+// SetRejectCallback is told about connections turned away before joining,
+// e.g. for a wrong session ID, so the host can spot someone guessing.
+func (s *Server) SetRejectCallback(onReject func(addr net.Addr, reason string)) {
+	s.onReject = onReject
+}
+
+// SetCapabilities limits the optional features offered to joiners, e.g. to
+// leave out typing indicators the user has turned off.
+func (s *Server) SetCapabilities(caps []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offered = protocol.NegotiateCapabilities(protocol.SupportedCapabilities(), caps)
+}
+
+// SetCodecs limits the wire codecs joiners may switch to after the
+// handshake. JSON is always possible.
+func (s *Server) SetCodecs(codecs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codecs = protocol.NegotiateCapabilities(protocol.SupportedCodecs(), codecs)
+}
+
+// SetMaxMessageSize limits how large a message from a peer may be, in bytes
+// as encoded. Larger ones are skipped and the peer is told why. 0 means
+// protocol.DefaultMaxFrameSize.
+func (s *Server) SetMaxMessageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxFrame = n
+}
+
+// SetStatusCallback is told how each TEXT message the host sends progresses
+// while at least one peer has negotiated receipts.
+func (s *Server) SetStatusCallback(onStatus func(id string, status protocol.DeliveryStatus)) {
+	s.receipts.setCallback(onStatus)
+}
+
+// Start listens on the loopback interface, which is all an SSH tunnel needs.
 func (s *Server) Start(port int) error {
-	addr := fmt.Sprintf(":%d", port)
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	return s.Listen(fmt.Sprintf("127.0.0.1:%d", port))
+}
+
+// Listen accepts joiners on every one of addrs: "host:port" for TCP (IPv6
+// hosts in brackets), "unix:/path" for a Unix socket that only the current
+// user can open, or "relay:host:port" to host through a relay. Nothing is
+// opened unless all of them succeed.
+func (s *Server) Listen(addrs ...string) error {
+	s.psk = sessionKey(s.session.Credential())
+	
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		listener, err := s.listenAt(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, listener)
 	}
 	
-	s.listener = listener
+	s.mu.Lock()
+	s.listeners = listeners
+	s.mu.Unlock()
 	s.session.SetState(session.StateWaiting)
 	
-	go s.acceptConnections()
+	for _, listener := range listeners {
+		go s.acceptConnections(listener)
+	}
+	return nil
+}
+
+// Addr returns the first address we listen on.
+func (s *Server) Addr() net.Addr {
+	if addrs := s.Addrs(); len(addrs) > 0 {
+		return addrs[0]
+	}
 	return nil
 }
 
-func (s *Server) acceptConnections() {
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, listener := range s.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+// Roster returns the names of everyone in the session, host first.
+func (s *Server) Roster() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rosterLocked()
+}
+
+func (s *Server) rosterLocked() []string {
+	names := make([]string, 0, len(s.peers))
+	for name := range s.peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{s.session.GetName()}, names...)
+}
+
+// nameTakenLocked reports whether name is used by the host, a peer, or a
+// peer that is still handshaking.
+func (s *Server) nameTakenLocked(name string) bool {
+	return name == s.session.GetName() || s.peers[name] != nil || s.pending[name]
+}
+
+// uniqueNameLocked returns the requested name, suffixed if it is taken. An
+// unusable request falls back to a generated peer-N name.
+func (s *Server) uniqueNameLocked(requested string) string {
+	s.nextPeer++
+	if session.ValidateName(requested) != nil {
+		requested = fmt.Sprintf("peer-%d", s.nextPeer)
+	}
+	
+	name := requested
+	for i := 2; s.nameTakenLocked(name); i++ {
+		suffix := fmt.Sprintf("-%d", i)
+		base := requested
+		if len(base)+len(suffix) > session.MaxNameLength {
+			base = base[:session.MaxNameLength-len(suffix)]
+		}
+		name = base + suffix
+	}
+	return name
+}
+
+func (s *Server) acceptConnections(listener net.Listener) {
+	flooded := false
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			return
 		}
 		
-		s.mu.Lock()
-		if s.conn != nil {
+		// Report a flood once rather than for every dropped connection
+		if !s.limiter.allow() {
 			conn.Close()
-			s.mu.Unlock()
+			if !flooded {
+				s.reject(conn.RemoteAddr(), "too many connections, dropping new ones for now")
+				flooded = true
+			}
 			continue
 		}
+		flooded = false
 		
-		s.conn = conn
-		s.encoder = json.NewEncoder(conn)
-		s.decoder = json.NewDecoder(conn)
-		s.mu.Unlock()
+		if s.attempts.lockedOut(conn.RemoteAddr()) > 0 {
+			go noiseRefuse(conn, protocol.ErrorCodeRateLimited, s.handshakeTimeout)
+			continue
+		}
 		
-		go s.handleConnection()
+		go s.handleConnection(conn)
 	}
 }
 
-func (s *Server) handleConnection() {
-	defer func() {
+func (s *Server) handleConnection(raw net.Conn) {
+	if wait := s.attempts.backoff(raw.RemoteAddr()); wait > 0 {
+		time.Sleep(wait)
 		s.mu.Lock()
-		if s.conn != nil {
-			s.conn.Close()
-			s.conn = nil
-		}
+		stopped := s.stopped
 		s.mu.Unlock()
-		
-		if s.onDisconnect != nil {
-			s.onDisconnect()
+		if stopped {
+			raw.Close()
+			return
 		}
-	}()
+	}
 	
-	if err := s.performHandshake(); err != nil {
+	raw.SetDeadline(time.Now().Add(s.handshakeTimeout))
+	secured, err := s.secure(raw)
+	if err != nil {
+		raw.Close()
+		s.rejected(raw.RemoteAddr(), err)
 		return
 	}
 	
-	s.session.SetState(session.StateActive)
-	if s.onConnect != nil {
-		s.onConnect()
+	// Closing it closes raw once what is queued has been sent
+	conn := newQueuedConn(secured, s.sendQueueSize)
+	defer conn.Close()
+	
+	p, decoder, resumed, err := s.performHandshake(conn, bufio.NewReader(conn))
+	if err != nil {
+		s.rejected(raw.RemoteAddr(), err)
+		return
+	}
+	s.attempts.succeed(raw.RemoteAddr())
+	raw.SetDeadline(time.Time{})
+	
+	if resumed {
+		s.announce(protocol.MessageTypeBack, p)
+	} else {
+		s.admit(p)
+	}
+	
+	left := s.serve(p, conn, decoder)
+	s.detach(p, conn, left)
+}
+
+// rejected reports why a connection failed to join and counts wrong session
+// IDs towards a lockout of their source.
+func (s *Server) rejected(addr net.Addr, err error) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errWrongKey):
+		reason := "wrong session ID"
+		if lockout := s.attempts.fail(addr); lockout > 0 && s.attempts.shared(addr) {
+			reason += fmt.Sprintf(", holding back local joiners for %s each", lockout)
+		} else if lockout > 0 {
+			reason += fmt.Sprintf(", locked out for %s", lockout)
+		}
+		s.reject(addr, reason)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		s.reject(addr, "handshake timed out")
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, errJoinRefused):
+		// Hung up, or the host already knows
+	default:
+		s.reject(addr, err.Error())
+	}
+}
+
+func (s *Server) reject(addr net.Addr, reason string) {
+	if s.onReject != nil {
+		s.onReject(addr, reason)
+	}
+}
+
+// secure runs the Noise handshake on a new connection. Joiners from before
+// encryption send plaintext JSON straight away; they are told which protocol
+// version we speak so they can explain the failure.
+func (s *Server) secure(conn net.Conn) (net.Conn, error) {
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	
+	if first[0] == '{' {
+		p := &peer{conn: conn, encoder: protocol.JSON.NewEncoder(conn)}
+		p.sendVersionError()
+		return nil, fmt.Errorf("unencrypted HELLO from termchat 1.x")
 	}
 	
+	return noiseServer(conn, reader, s.psk)
+}
+
+// serve handles messages from one of p's connections until it fails. It
+// reports whether the peer left on purpose.
+func (s *Server) serve(p *peer, conn net.Conn, decoder protocol.Decoder) bool {
 	for {
-		var msg protocol.Message
-		if err := s.decoder.Decode(&msg); err != nil {
-			return
+		if p.has(protocol.CapResume) {
+			// Resumable peers ping regularly, so silence means a dead link
+			conn.SetReadDeadline(time.Now().Add(3 * heartbeatInterval))
 		}
 		
-		s.session.AddMessage(msg)
+		var msg protocol.Message
+		if err := decoder.Decode(&msg); err != nil {
+			var frameErr *protocol.FrameError
+			if errors.As(err, &frameErr) {
+				// The peer hears why; after a skipped frame the connection
+				// carries on
+				p.sendError(frameErr.Code(), frameErr.Error())
+				if !frameErr.Fatal {
+					continue
+				}
+				// Not the kind of drop a resume would fix, as the peer
+				// would only send the frame again
+				return true
+			}
+			return false
+		}
 		
-		if s.onMessage != nil {
-			s.onMessage(msg)
+		if !p.received(msg.Seq) {
+			continue
 		}
 		
 		switch msg.Type {
+		case protocol.MessageTypeText:
+			// The hub decides who a message is from, never the sender
+			msg.From = s.nameOf(p)
+			if msg.ID != "" {
+				s.noteOrigin(msg.ID, p)
+				if p.has(protocol.CapReceipts) {
+					ack := protocol.NewReceipt(protocol.MessageTypeAck, msg.ID)
+					ack.From = s.session.GetName()
+					p.send(ack)
+				}
+			}
+			s.session.AddMessage(msg)
+			
+			if s.onMessage != nil {
+				s.onMessage(msg)
+			}
+			
+			s.broadcast(&msg, p)
+		case protocol.MessageTypeTyping:
+			if !p.has(protocol.CapTyping) {
+				continue
+			}
+			msg.From = s.nameOf(p)
+			
+			if s.onMessage != nil {
+				s.onMessage(msg)
+			}
+			
+			s.broadcastTo(&msg, p, protocol.CapTyping)
+		case protocol.MessageTypeAck, protocol.MessageTypeRead:
+			if !p.has(protocol.CapReceipts) {
+				continue
+			}
+			msg.From = s.nameOf(p)
+			s.routeReceipt(&msg, p)
+		case protocol.MessageTypeFileOffer, protocol.MessageTypeFileAccept, protocol.MessageTypeFileChunk,
+			protocol.MessageTypeFileAck, protocol.MessageTypeFileComplete, protocol.MessageTypeFileCancel:
+			if !p.has(protocol.CapFileTransfer) {
+				continue
+			}
+			msg.From = s.nameOf(p)
+			s.routeFile(&msg, p)
+		case protocol.MessageTypeNick:
+			if err := s.rename(p, msg.Content); err != nil {
+				p.sendError(protocol.ErrorCodeInvalidName, err.Error())
+			}
+		case protocol.MessageTypeError:
+			// The peer could not read something we sent
+			msg.From = s.nameOf(p)
+			if s.onMessage != nil {
+				s.onMessage(msg)
+			}
 		case protocol.MessageTypePing:
-			s.SendMessage(protocol.NewMessage(protocol.MessageTypePong, ""))
+			p.write(protocol.NewMessage(protocol.MessageTypePong, ""))
 		case protocol.MessageTypeLeave:
-			return
+			return true
 		}
 	}
 }
 
-func (s *Server) performHandshake() error {
+// performHandshake admits a joiner, or takes a returning one back, and
+// returns the decoder for the rest of the connection. Both read from reader,
+// which starts in JSON.
+func (s *Server) performHandshake(conn net.Conn, reader *bufio.Reader) (*peer, protocol.Decoder, bool, error) {
+	p := &peer{
+		conn:    conn,
+		encoder: protocol.JSON.NewEncoder(conn),
+	}
+	if nc, ok := unqueued(conn).(*noiseConn); ok && !isLocal(nc) {
+		p.sas = shortAuthString(nc.HandshakeHash())
+	}
+	
+	s.mu.Lock()
+	maxFrame := s.maxFrame
+	s.mu.Unlock()
+	
 	var hello protocol.Message
-	if err := s.decoder.Decode(&hello); err != nil {
-		return err
+	if err := protocol.JSON.NewDecoder(reader, maxFrame).Decode(&hello); err != nil {
+		var frameErr *protocol.FrameError
+		if errors.As(err, &frameErr) {
+			p.sendError(frameErr.Code(), frameErr.Error())
+		}
+		return nil, nil, false, err
 	}
 	
 	if hello.Type != protocol.MessageTypeHello {
-		s.sendError("Expected HELLO message")
-		return fmt.Errorf("invalid handshake: expected HELLO, got %s", hello.Type)
+		p.sendError(protocol.ErrorCodeInvalidMessage, "Expected HELLO message")
+		return nil, nil, false, fmt.Errorf("invalid handshake: expected HELLO, got %s", hello.Type)
+	}
+	
+	if err := protocol.CheckVersion(hello.Version); err != nil {
+		p.sendVersionError()
+		return nil, nil, false, err
+	}
+	
+	if subtle.ConstantTimeCompare([]byte(hello.SessionID), []byte(s.session.ID)) != 1 {
+		p.sendError(protocol.ErrorCodeSessionMismatch, "Session ID mismatch")
+		return nil, nil, false, fmt.Errorf("%w in HELLO", errWrongKey)
+	}
+	
+	s.mu.Lock()
+	codec := protocol.ChooseCodec(s.codecs, hello.Codecs)
+	s.mu.Unlock()
+	
+	if hello.Token != "" {
+		return s.resume(p, &hello, codec, reader, maxFrame)
+	}
+	
+	if err := s.reserve(p, hello.Name); err != nil {
+		p.sendError(protocol.ErrorCodeSessionFull, "Session is full")
+		return nil, nil, false, err
 	}
 	
-	if hello.SessionID != s.session.ID {
-		s.sendError("Session ID mismatch")
-		return fmt.Errorf("session ID mismatch: expected %s, got %s", s.session.ID, hello.SessionID)
+	if s.approve != nil {
+		// The host may take a while to decide
+		conn.SetDeadline(time.Time{})
+		reason := s.awaitApproval(p, &hello)
+		conn.SetDeadline(time.Now().Add(s.handshakeTimeout))
+		if reason != "" {
+			p.sendError(protocol.ErrorCodeRejected, reason)
+			s.release(p)
+			return nil, nil, false, fmt.Errorf("%w: %s", errJoinRefused, reason)
+		}
 	}
 	
+	// WELCOME tells the joiner the name it was given and who the host is
 	welcome := protocol.NewHandshakeMessage(protocol.MessageTypeWelcome, s.session.ID)
-	if err := s.encoder.Encode(welcome); err != nil {
-		return err
+	welcome.Name = p.name
+	welcome.From = s.session.GetName()
+	s.mu.Lock()
+	p.caps = protocol.NegotiateCapabilities(s.offered, hello.Capabilities)
+	s.mu.Unlock()
+	welcome.Capabilities = p.caps
+	if p.has(protocol.CapResume) {
+		p.token = newResumeToken()
+		welcome.Token = p.token
+	}
+	if len(hello.Codecs) > 0 {
+		welcome.Codecs = []string{codec.Name()}
+	}
+	if err := p.write(welcome); err != nil {
+		s.release(p)
+		return nil, nil, false, err
+	}
+	
+	decoder := p.switchCodec(codec, reader, maxFrame)
+	if err := expectReady(decoder); err != nil {
+		s.release(p)
+		return nil, nil, false, err
+	}
+	
+	return p, decoder, false, nil
+}
+
+// resume hands a returning peer's seat to its new connection and replays
+// what it missed while it was away.
+func (s *Server) resume(fresh *peer, hello *protocol.Message, codec protocol.Codec, reader *bufio.Reader, maxFrame int) (*peer, protocol.Decoder, bool, error) {
+	p := s.resumable(hello.Token)
+	if p == nil {
+		fresh.sendError(protocol.ErrorCodeSessionExpired, "Session expired, cannot resume")
+		return nil, nil, false, fmt.Errorf("resume refused: unknown token")
+	}
+	
+	welcome := protocol.NewHandshakeMessage(protocol.MessageTypeWelcome, s.session.ID)
+	welcome.Name = s.nameOf(p)
+	welcome.From = s.session.GetName()
+	welcome.Capabilities = p.caps
+	welcome.Token = p.token
+	p.mu.Lock()
+	welcome.Seq = p.recvSeq
+	p.mu.Unlock()
+	if len(hello.Codecs) > 0 {
+		welcome.Codecs = []string{codec.Name()}
+	}
+	if err := fresh.write(welcome); err != nil {
+		return nil, nil, false, err
 	}
 	
+	decoder := fresh.switchCodec(codec, reader, maxFrame)
+	if err := expectReady(decoder); err != nil {
+		return nil, nil, false, err
+	}
+	
+	if err := p.attach(fresh.conn, fresh.encoder, hello.Seq); err != nil {
+		return nil, nil, false, err
+	}
+	return p, decoder, true, nil
+}
+
+// awaitApproval asks the host about a new joiner and returns why they were
+// refused, or "" if they may join.
+func (s *Server) awaitApproval(p *peer, hello *protocol.Message) string {
+	ctx, cancel := context.WithTimeout(context.Background(), s.approvalTimeout)
+	defer cancel()
+	
+	req := JoinRequest{Name: p.name, Addr: p.conn.RemoteAddr(), SSHUser: hello.User, Key: verifiedKey(p.conn)}
+	if s.approve(ctx, req) {
+		return ""
+	}
+	if ctx.Err() != nil {
+		return "Timed out waiting for the host to approve"
+	}
+	return "The host rejected your request to join"
+}
+
+func expectReady(decoder protocol.Decoder) error {
 	var ready protocol.Message
-	if err := s.decoder.Decode(&ready); err != nil {
+	if err := decoder.Decode(&ready); err != nil {
 		return err
 	}
 	
 	if ready.Type != protocol.MessageTypeReady {
 		return fmt.Errorf("invalid handshake: expected READY, got %s", ready.Type)
 	}
+	return nil
+}
+
+// resumable finds the peer holding token, whether it is away or the host
+// has not yet noticed its old connection dying.
+func (s *Server) resumable(token string) *peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	
+	for _, p := range s.peers {
+		if p.token != "" && subtle.ConstantTimeCompare([]byte(p.token), []byte(token)) == 1 {
+			return p
+		}
+	}
 	return nil
 }
 
-func (s *Server) SendMessage(msg *protocol.Message) error {
+// detach handles the end of one of p's connections. A peer that can resume
+// is kept as away for a while; anyone else is removed.
+func (s *Server) detach(p *peer, conn net.Conn, left bool) {
+	s.mu.Lock()
+	stopped := s.stopped
+	s.mu.Unlock()
+	
+	p.mu.Lock()
+	if p.conn != conn {
+		// Replaced by a resumed connection
+		p.mu.Unlock()
+		return
+	}
+	p.conn = nil
+	p.encoder = nil
+	
+	away := !left && !stopped && p.has(protocol.CapResume)
+	if away {
+		p.away = time.AfterFunc(s.resumeWindow, func() { s.expire(p) })
+	} else {
+		p.gone = true
+	}
+	p.mu.Unlock()
+	
+	if away {
+		s.announce(protocol.MessageTypeAway, p)
+	} else {
+		s.remove(p)
+	}
+}
+
+// expire removes a peer that did not come back in time.
+func (s *Server) expire(p *peer) {
+	p.mu.Lock()
+	if p.conn != nil || p.gone {
+		p.mu.Unlock()
+		return
+	}
+	p.gone = true
+	p.away = nil
+	p.mu.Unlock()
+	
+	s.remove(p)
+}
+
+func (s *Server) nameOf(p *peer) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return p.name
+}
+
+// reserve holds a seat and a name for a peer that is part-way through the
+// handshake so concurrent joiners cannot overfill the session.
+func (s *Server) reserve(p *peer, requested string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
-	if s.encoder == nil {
+	if s.maxPeers > 0 && len(s.peers)+len(s.pending) >= s.maxPeers {
+		return fmt.Errorf("session full: %d peers", s.maxPeers)
+	}
+	p.name = s.uniqueNameLocked(requested)
+	s.pending[p.name] = true
+	return nil
+}
+
+func (s *Server) release(p *peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, p.name)
+}
+
+// admit moves a handshaken peer from its reserved seat into the roster and
+// announces it to everyone else.
+func (s *Server) admit(p *peer) {
+	s.mu.Lock()
+	delete(s.pending, p.name)
+	s.peers[p.name] = p
+	roster := s.rosterLocked()
+	s.mu.Unlock()
+	
+	s.session.SetState(session.StateActive)
+	
+	joined := protocol.NewMessage(protocol.MessageTypeJoined, p.name)
+	joined.From = p.name
+	s.broadcast(joined, p)
+	s.broadcastRoster(roster)
+	
+	if s.onJoin != nil {
+		s.onJoin(p.name)
+	}
+}
+
+// announce tells everyone else that p went away or came back.
+func (s *Server) announce(msgType protocol.MessageType, p *peer) {
+	name := s.nameOf(p)
+	msg := protocol.NewMessage(msgType, name)
+	msg.From = name
+	s.broadcast(msg, p)
+	
+	if s.onMessage != nil {
+		s.onMessage(*msg)
+	}
+}
+
+func (s *Server) remove(p *peer) {
+	s.mu.Lock()
+	delete(s.peers, p.name)
+	remaining := len(s.peers)
+	roster := s.rosterLocked()
+	s.mu.Unlock()
+	
+	if remaining == 0 && s.session.GetState() == session.StateActive {
+		s.session.SetState(session.StateWaiting)
+	}
+	
+	left := protocol.NewMessage(protocol.MessageTypeLeft, p.name)
+	left.From = p.name
+	s.broadcast(left, nil)
+	s.broadcastRoster(roster)
+	
+	if s.onLeave != nil {
+		s.onLeave(p.name)
+	}
+}
+
+// rename changes a peer's display name and tells everyone about it.
+func (s *Server) rename(p *peer, name string) error {
+	if err := session.ValidateName(name); err != nil {
+		return err
+	}
+	
+	s.mu.Lock()
+	old := p.name
+	if name == old {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.nameTakenLocked(name) {
+		s.mu.Unlock()
+		return fmt.Errorf("name %q is already taken", name)
+	}
+	delete(s.peers, old)
+	p.name = name
+	s.peers[name] = p
+	roster := s.rosterLocked()
+	s.mu.Unlock()
+	
+	s.announceRename(old, name, roster)
+	return nil
+}
+
+// SetName changes the host's display name and tells every peer about it.
+func (s *Server) SetName(name string) error {
+	if err := session.ValidateName(name); err != nil {
+		return err
+	}
+	
+	s.mu.Lock()
+	old := s.session.GetName()
+	if name == old {
+		s.mu.Unlock()
+		return nil
+	}
+	if s.nameTakenLocked(name) {
+		s.mu.Unlock()
+		return fmt.Errorf("name %q is already taken", name)
+	}
+	s.session.SetName(name)
+	roster := s.rosterLocked()
+	s.mu.Unlock()
+	
+	s.announceRename(old, name, roster)
+	return nil
+}
+
+func (s *Server) announceRename(old, name string, roster []string) {
+	msg := protocol.NewMessage(protocol.MessageTypeNick, name)
+	msg.From = old
+	s.broadcast(msg, nil)
+	s.broadcastRoster(roster)
+	
+	if s.onMessage != nil {
+		s.onMessage(*msg)
+	}
+}
+
+// noteOrigin remembers who sent a relayed message so receipts for it can be
+// routed back.
+func (s *Server) noteOrigin(id string, p *peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	if _, ok := s.origins[id]; !ok {
+		s.order = append(s.order, id)
+	}
+	s.origins[id] = p
+	if len(s.order) > maxOrigins {
+		delete(s.origins, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// routeReceipt delivers an ACK or READ to whoever sent the message it refers
+// to: a peer, or otherwise the host itself.
+func (s *Server) routeReceipt(msg *protocol.Message, from *peer) {
+	s.mu.Lock()
+	origin, relayed := s.origins[msg.Ref]
+	s.mu.Unlock()
+	
+	if !relayed {
+		s.receipts.receipt(*msg)
+		return
+	}
+	if origin != from && origin.has(protocol.CapReceipts) {
+		origin.send(msg)
+	}
+}
+
+// routeFile delivers a file transfer message from a peer to the participant
+// named in To, or for an offer without one, to everyone. Chunks are not kept
+// for replay: after a drop the receiver asks again from where it got to.
+func (s *Server) routeFile(msg *protocol.Message, from *peer) {
+	if msg.To == "" || msg.To == s.session.GetName() {
+		if s.onMessage != nil {
+			s.onMessage(*msg)
+		}
+	}
+	if msg.To == "" {
+		if msg.Type == protocol.MessageTypeFileOffer {
+			s.broadcastTo(msg, from, protocol.CapFileTransfer)
+		}
+		return
+	}
+	if msg.To == s.session.GetName() {
+		return
+	}
+	
+	s.mu.Lock()
+	target := s.peers[msg.To]
+	s.mu.Unlock()
+	if target == nil || !target.has(protocol.CapFileTransfer) {
+		if msg.Type != protocol.MessageTypeFileCancel {
+			cancel := protocol.NewMessage(protocol.MessageTypeFileCancel, msg.To+" cannot receive files")
+			cancel.Ref = msg.Ref
+			cancel.From = msg.To
+			from.send(cancel)
+		}
+		return
+	}
+	
+	if msg.Type == protocol.MessageTypeFileChunk {
+		target.write(msg)
+		return
+	}
+	target.send(msg)
+}
+
+func (s *Server) broadcastRoster(roster []string) {
+	msg := protocol.NewMessage(protocol.MessageTypeRoster, "")
+	msg.Roster = roster
+	s.broadcast(msg, nil)
+}
+
+// broadcast sends msg to every peer except skip. Peers that are away get it
+// when they come back.
+func (s *Server) broadcast(msg *protocol.Message, skip *peer) {
+	s.broadcastTo(msg, skip, "")
+}
+
+// broadcastTo is broadcast limited to peers that negotiated capability.
+func (s *Server) broadcastTo(msg *protocol.Message, skip *peer, capability string) {
+	s.mu.Lock()
+	targets := make([]*peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		if p != skip && (capability == "" || p.has(capability)) {
+			targets = append(targets, p)
+		}
+	}
+	s.mu.Unlock()
+	
+	for _, p := range targets {
+		p.send(msg)
+	}
+}
+
+// SendMessage sends a message from the host to every peer.
+func (s *Server) SendMessage(msg *protocol.Message) error {
+	s.mu.Lock()
+	connected := len(s.peers)
+	receipts := false
+	for _, p := range s.peers {
+		receipts = receipts || p.has(protocol.CapReceipts)
+	}
+	s.mu.Unlock()
+	
+	if connected == 0 {
 		return fmt.Errorf("not connected")
 	}
 	
-	return s.encoder.Encode(msg)
+	msg.From = s.session.GetName()
+	switch msg.Type {
+	case protocol.MessageTypeTyping:
+		s.broadcastTo(msg, nil, protocol.CapTyping)
+		return nil
+	case protocol.MessageTypeAck, protocol.MessageTypeRead:
+		s.routeReceipt(msg, nil)
+		return nil
+	case protocol.MessageTypeFileOffer, protocol.MessageTypeFileAccept, protocol.MessageTypeFileChunk,
+		protocol.MessageTypeFileAck, protocol.MessageTypeFileComplete, protocol.MessageTypeFileCancel:
+		return s.sendFile(msg)
+	case protocol.MessageTypeText:
+		if receipts {
+			s.receipts.track(msg.ID)
+		}
+	}
+	s.broadcast(msg, nil)
+	return nil
 }
 
-func (s *Server) sendError(errMsg string) {
-	msg := protocol.NewMessage(protocol.MessageTypeError, errMsg)
-	s.encoder.Encode(msg)
+// sendFile sends a file transfer message from the host to the peer named in
+// To, or an offer without one to every peer that can take it.
+func (s *Server) sendFile(msg *protocol.Message) error {
+	if msg.To == "" {
+		s.broadcastTo(msg, nil, protocol.CapFileTransfer)
+		return nil
+	}
+	
+	s.mu.Lock()
+	target := s.peers[msg.To]
+	s.mu.Unlock()
+	if target == nil {
+		return fmt.Errorf("nobody called %q is here", msg.To)
+	}
+	if !target.has(protocol.CapFileTransfer) {
+		return fmt.Errorf("%s cannot receive files", msg.To)
+	}
+	
+	if msg.Type == protocol.MessageTypeFileChunk {
+		// Lost while the peer is away; it asks again when it is back
+		target.write(msg)
+		return nil
+	}
+	return target.send(msg)
+}
+
+// Capabilities returns the features negotiated with the named peer.
+func (s *Server) Capabilities(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	if p := s.peers[name]; p != nil {
+		return append([]string{}, p.caps...)
+	}
+	return nil
+}
+
+// SAS returns the verification code shared with the named peer, or "" if
+// there is no such peer. A resumed connection keeps the original code.
+func (s *Server) SAS(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	if p, ok := s.peers[name]; ok {
+		return p.sas
+	}
+	return ""
 }
 
 func (s *Server) Stop() {
 	s.mu.Lock()
 	
-	if s.conn != nil {
-		// Send leave message before closing
-		if s.encoder != nil {
-			msg := protocol.NewMessage(protocol.MessageTypeLeave, "Host disconnected")
-			s.encoder.Encode(msg)
-		}
-		s.conn.Close()
-		s.conn = nil
+	for _, listener := range s.listeners {
+		listener.Close()
 	}
+	s.listeners = nil
+	s.stopped = true
 	
-	if s.listener != nil {
-		s.listener.Close()
-		s.listener = nil
+	peers := make([]*peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		peers = append(peers, p)
 	}
 	
 	s.mu.Unlock()
 	
+	for _, p := range peers {
+		// Send leave message before closing
+		p.send(protocol.NewMessage(protocol.MessageTypeLeave, "Host disconnected"))
+		p.close()
+	}
+	
 	s.session.SetState(session.StateEnded)
+}
+
+// send numbers msg on this peer's link and keeps it for replay. A peer that
+// is away gets it when it comes back; a failed write closes the connection
+// so the read loop notices.
+func (p *peer) send(msg *protocol.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	out := p.sent.add(msg)
+	if p.encoder == nil {
+		return nil
+	}
+	if err := p.encoder.Encode(&out); err != nil {
+		p.conn.Close()
+		return err
+	}
+	return nil
+}
+
+// write sends a message that is not worth replaying, such as a handshake
+// reply or a pong.
+func (p *peer) write(msg *protocol.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	if p.encoder == nil {
+		return fmt.Errorf("peer is away")
+	}
+	return p.encoder.Encode(msg)
+}
+
+// switchCodec moves p's connection to the codec named in WELCOME, which the
+// joiner speaks from its READY on, and returns the decoder to read it with.
+func (p *peer) switchCodec(codec protocol.Codec, reader *bufio.Reader, maxFrame int) protocol.Decoder {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.encoder = codec.NewEncoder(p.conn)
+	return codec.NewDecoder(reader, maxFrame)
+}
+
+// received records the sequence number of an incoming message and reports
+// whether it is new rather than a replay.
+func (p *peer) received(seq uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	if seq == 0 {
+		return true
+	}
+	if seq <= p.recvSeq {
+		return false
+	}
+	p.recvSeq = seq
+	return true
+}
+
+// attach switches p to a resumed connection and replays everything after
+// seq, the last message the peer says it received.
+func (p *peer) attach(conn net.Conn, encoder protocol.Encoder, seq uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	if p.gone {
+		return fmt.Errorf("peer already removed")
+	}
+	if p.away != nil {
+		p.away.Stop()
+		p.away = nil
+	}
+	if p.conn != nil {
+		// The old connection is dead even if we had not noticed yet
+		p.conn.Close()
+	}
+	p.conn = conn
+	p.encoder = encoder
+	
+	for _, msg := range p.sent.since(seq) {
+		if err := encoder.Encode(&msg); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *peer) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	
+	if p.away != nil {
+		p.away.Stop()
+		p.away = nil
+	}
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+func (p *peer) sendError(code protocol.ErrorCode, errMsg string) {
+	p.write(protocol.NewError(code, errMsg))
+}
+
+// sendVersionError rejects a peer with an incompatible protocol, telling it
+// which version we speak so it can explain the problem.
+func (p *peer) sendVersionError() {
+	msg := protocol.NewError(protocol.ErrorCodeVersionUnsupported, "Unsupported protocol version")
+	msg.Version = protocol.ProtocolVersion
+	p.write(msg)
+}
+
+func (p *peer) has(capability string) bool {
+	return protocol.HasCapability(p.caps, capability)
 }
\ No newline at end of file
@@ -0,0 +1,661 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sam/termchat/internal/session"
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+// startTestServer starts a host on a free port. configure runs before the
+// listener opens.
+func startTestServer(t *testing.T, maxPeers int, configure ...func(*Server)) *Server {
+	t.Helper()
+	sess := session.New()
+	sess.SetName("host")
+	server := NewServer(sess)
+	server.SetMaxPeers(maxPeers)
+	for _, f := range configure {
+		f(server)
+	}
+	if err := server.Start(0); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(server.Stop)
+	return server
+}
+
+func joinTestServer(t *testing.T, server *Server, name string) (*Client, chan protocol.Message, error) {
+	t.Helper()
+	received := make(chan protocol.Message, 64)
+	sess := session.New()
+	sess.SetName(name)
+	client := NewClient(sess)
+	client.SetCallbacks(func(msg protocol.Message) { received <- msg }, nil, nil)
+	
+	addr := fmt.Sprintf("127.0.0.1:%d", server.Addr().(*net.TCPAddr).Port)
+	if err := client.ConnectLocal(addr, server.session.ID); err != nil {
+		return nil, nil, err
+	}
+	t.Cleanup(client.Stop)
+	return client, received, nil
+}
+
+// dialTestServer opens an encrypted connection for speaking the protocol by
+// hand.
+func dialTestServer(t *testing.T, server *Server) net.Conn {
+	t.Helper()
+	raw, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { raw.Close() })
+	
+	conn, err := noiseClient(raw, sessionKey(server.session.ID))
+	if err != nil {
+		t.Fatalf("Noise handshake failed: %v", err)
+	}
+	return conn
+}
+
+func waitFor(t *testing.T, ch chan protocol.Message, match func(protocol.Message) bool) protocol.Message {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-ch:
+			if match(msg) {
+				return msg
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for message")
+			return protocol.Message{}
+		}
+	}
+}
+
+func isText(content string) func(protocol.Message) bool {
+	return func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeText && msg.Content == content
+	}
+}
+
+func TestServerFanOut(t *testing.T) {
+	server := startTestServer(t, 5)
+	
+	hostMessages := make(chan protocol.Message, 64)
+	joined := make(chan string, 8)
+	server.SetCallbacks(func(msg protocol.Message) { hostMessages <- msg }, func(name string) { joined <- name }, nil)
+	
+	alice, aliceMessages, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("First join failed: %v", err)
+	}
+	<-joined
+	
+	_, bobMessages, err := joinTestServer(t, server, "bob")
+	if err != nil {
+		t.Fatalf("Second join failed: %v", err)
+	}
+	<-joined
+	
+	// Alice sees Bob arrive along with the updated roster
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeJoined && msg.Content == "bob"
+	})
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	
+	alice.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "hello all"))
+	
+	got := waitFor(t, bobMessages, isText("hello all"))
+	if got.From != "alice" {
+		t.Errorf("Expected message from alice, got %q", got.From)
+	}
+	
+	got = waitFor(t, hostMessages, isText("hello all"))
+	if got.From != "alice" {
+		t.Errorf("Host expected message from alice, got %q", got.From)
+	}
+	
+	server.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "from the host"))
+	for _, ch := range []chan protocol.Message{aliceMessages, bobMessages} {
+		if got := waitFor(t, ch, isText("from the host")); got.From != "host" {
+			t.Errorf("Expected message from host, got %q", got.From)
+		}
+	}
+}
+
+func TestServerSenderCannotSpoofFrom(t *testing.T) {
+	server := startTestServer(t, 5)
+	
+	alice, _, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	_, bobMessages, err := joinTestServer(t, server, "bob")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	waitFor(t, bobMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	
+	msg := protocol.NewMessage(protocol.MessageTypeText, "trust me")
+	msg.From = "host"
+	alice.SendMessage(msg)
+	
+	if got := waitFor(t, bobMessages, isText("trust me")); got.From != "alice" {
+		t.Errorf("Expected hub to stamp sender, got %q", got.From)
+	}
+}
+
+func TestServerMaxPeers(t *testing.T) {
+	server := startTestServer(t, 1)
+	
+	_, messages, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("First join failed: %v", err)
+	}
+	waitFor(t, messages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster
+	})
+	
+	if _, _, err := joinTestServer(t, server, "bob"); !errors.Is(err, ErrSessionFull) {
+		t.Errorf("Expected second join to be refused as full, got %v", err)
+	}
+	
+	if roster := server.Roster(); len(roster) != 2 {
+		t.Errorf("Expected host and one peer, got %v", roster)
+	}
+}
+
+func TestServerLeaveAnnounced(t *testing.T) {
+	server := startTestServer(t, 5)
+	
+	left := make(chan string, 1)
+	server.SetCallbacks(nil, nil, func(name string) { left <- name })
+	
+	alice, _, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	_, bobMessages, err := joinTestServer(t, server, "bob")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	waitFor(t, bobMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	
+	alice.Stop()
+	
+	waitFor(t, bobMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeLeft && msg.Content == "alice"
+	})
+	
+	select {
+	case name := <-left:
+		if name != "alice" {
+			t.Errorf("Expected alice to leave, got %s", name)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Host was not told about the departure")
+	}
+}
+
+func TestServerNames(t *testing.T) {
+	server := startTestServer(t, 5)
+	
+	alice, aliceMessages, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if alice.session.GetName() != "alice" {
+		t.Errorf("Expected name alice, got %s", alice.session.GetName())
+	}
+	
+	// A second alice is kept distinct rather than impersonating the first
+	twin, _, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if twin.session.GetName() != "alice-2" {
+		t.Errorf("Expected duplicate name to become alice-2, got %s", twin.session.GetName())
+	}
+	
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	
+	if err := twin.SetName("bob"); err != nil {
+		t.Fatalf("SetName failed: %v", err)
+	}
+	
+	rename := waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeNick
+	})
+	if rename.From != "alice-2" || rename.Content != "bob" {
+		t.Errorf("Unexpected rename event: %+v", rename)
+	}
+	
+	twin.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "renamed"))
+	if got := waitFor(t, aliceMessages, isText("renamed")); got.From != "bob" {
+		t.Errorf("Expected message from bob, got %q", got.From)
+	}
+	if twin.session.GetName() != "bob" {
+		t.Errorf("Client should track its accepted rename, got %s", twin.session.GetName())
+	}
+	
+	// Taking the host's name is refused
+	alice.SetName("host")
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeError
+	})
+}
+
+func TestServerRejectsIncompatibleVersion(t *testing.T) {
+	server := startTestServer(t, 5)
+	conn := dialTestServer(t, server)
+	
+	hello := protocol.NewHandshakeMessage(protocol.MessageTypeHello, server.session.ID)
+	hello.Version = "3.0"
+	if err := json.NewEncoder(conn).Encode(hello); err != nil {
+		t.Fatalf("Failed to send HELLO: %v", err)
+	}
+	
+	var reply protocol.Message
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	
+	if reply.Type != protocol.MessageTypeError || reply.Code != protocol.ErrorCodeVersionUnsupported || reply.Version != protocol.ProtocolVersion {
+		t.Errorf("Expected version error naming %s, got %+v", protocol.ProtocolVersion, reply)
+	}
+}
+
+func TestServerTypingNeedsCapability(t *testing.T) {
+	server := startTestServer(t, 5)
+	
+	alice, aliceMessages, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	if !alice.HasCapability(protocol.CapTyping) {
+		t.Fatal("Expected typing to be negotiated")
+	}
+	
+	// Bob has turned typing indicators off
+	received := make(chan protocol.Message, 64)
+	sess := session.New()
+	sess.SetName("bob")
+	bob := NewClient(sess)
+	bob.SetCapabilities(nil)
+	bob.SetCallbacks(func(msg protocol.Message) { received <- msg }, nil, nil)
+	if err := bob.ConnectLocal(server.Addr().String(), server.session.ID); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	defer bob.Stop()
+	
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	
+	bob.SendMessage(protocol.NewMessage(protocol.MessageTypeTyping, "true"))
+	alice.SendMessage(protocol.NewMessage(protocol.MessageTypeTyping, "true"))
+	alice.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "done"))
+	
+	waitFor(t, received, isText("done"))
+	for len(received) > 0 {
+		if msg := <-received; msg.Type == protocol.MessageTypeTyping {
+			t.Error("Typing event forwarded to a peer without the capability")
+		}
+	}
+	
+	server.SendMessage(protocol.NewMessage(protocol.MessageTypeTyping, "true"))
+	typing := waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeTyping
+	})
+	if typing.From != "host" {
+		t.Errorf("Expected host typing event, got %+v", typing)
+	}
+}
+
+func TestServerReceipts(t *testing.T) {
+	server := startTestServer(t, 5)
+	hostEvents, onHostStatus := newStatusRecorder()
+	server.SetStatusCallback(onHostStatus)
+	
+	alice, aliceMessages, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	aliceEvents, onAliceStatus := newStatusRecorder()
+	alice.SetStatusCallback(onAliceStatus)
+	
+	bob, bobMessages, err := joinTestServer(t, server, "bob")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	
+	// The host acknowledges on receipt; Bob's READ is routed back to Alice
+	hi := protocol.NewMessage(protocol.MessageTypeText, "hi")
+	if err := alice.SendMessage(hi); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitForStatus(t, aliceEvents, hi.ID, protocol.StatusDelivered)
+	
+	got := waitFor(t, bobMessages, isText("hi"))
+	if got.ID != hi.ID || got.Seq == 0 {
+		t.Errorf("Relayed message should keep its ID and carry a sequence number, got %+v", got)
+	}
+	bob.SendMessage(protocol.NewReceipt(protocol.MessageTypeRead, got.ID))
+	waitForStatus(t, aliceEvents, hi.ID, protocol.StatusRead)
+	
+	// Receipts for the host's own messages end up with the host
+	yo := protocol.NewMessage(protocol.MessageTypeText, "yo")
+	if err := server.SendMessage(yo); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitForStatus(t, hostEvents, yo.ID, protocol.StatusDelivered)
+}
+
+func TestServerRoutesFiles(t *testing.T) {
+	server := startTestServer(t, 5)
+	hostMessages := make(chan protocol.Message, 64)
+	server.SetCallbacks(func(msg protocol.Message) { hostMessages <- msg }, nil, nil)
+	
+	alice, aliceMessages, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	_, bobMessages, err := joinTestServer(t, server, "bob")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	
+	// An offer goes to everyone, the host included
+	offer := protocol.NewMessage(protocol.MessageTypeFileOffer, "")
+	offer.File = &protocol.FileInfo{Name: "notes.txt", Size: 5}
+	alice.SendMessage(offer)
+	isOffer := func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeFileOffer && msg.ID == offer.ID && msg.From == "alice"
+	}
+	waitFor(t, bobMessages, isOffer)
+	waitFor(t, hostMessages, isOffer)
+	
+	// Everything after it goes only to the participant named in To
+	chunk := protocol.NewMessage(protocol.MessageTypeFileChunk, "")
+	chunk.Ref = offer.ID
+	chunk.To = "bob"
+	chunk.Data = []byte("hello")
+	alice.SendMessage(chunk)
+	got := waitFor(t, bobMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeFileChunk
+	})
+	if got.From != "alice" || string(got.Data) != "hello" || got.Seq != 0 {
+		t.Errorf("Unexpected chunk %+v", got)
+	}
+	
+	accept := protocol.NewMessage(protocol.MessageTypeFileAccept, "")
+	accept.Ref = offer.ID
+	accept.To = "alice"
+	if err := server.SendMessage(accept); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeFileAccept && msg.From == "host"
+	})
+	
+	// Nobody by that name: the sender hears straight away
+	chunk.To = "carol"
+	alice.SendMessage(chunk)
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeFileCancel && msg.From == "carol" && msg.Ref == offer.ID
+	})
+}
+
+func TestServerCodecs(t *testing.T) {
+	server := startTestServer(t, 5)
+	
+	// Alice takes the default, bob stays in JSON
+	alice, aliceMessages, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	received := make(chan protocol.Message, 64)
+	sess := session.New()
+	sess.SetName("bob")
+	bob := NewClient(sess)
+	bob.SetCodecs([]string{"json"})
+	bob.SetCallbacks(func(msg protocol.Message) { received <- msg }, nil, nil)
+	if err := bob.ConnectLocal(server.Addr().String(), server.session.ID); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	defer bob.Stop()
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	
+	alice.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "from binary"))
+	waitFor(t, received, isText("from binary"))
+	bob.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "from json"))
+	waitFor(t, aliceMessages, isText("from json"))
+	
+	// By hand: WELCOME names the codec and everything after it uses it
+	conn := dialTestServer(t, server)
+	hello := protocol.NewHandshakeMessage(protocol.MessageTypeHello, server.session.ID)
+	hello.Name = "carol"
+	hello.Codecs = []string{"cbor", "binary"}
+	protocol.JSON.NewEncoder(conn).Encode(hello)
+	
+	reader := bufio.NewReader(conn)
+	var welcome protocol.Message
+	if err := protocol.JSON.NewDecoder(reader, 0).Decode(&welcome); err != nil {
+		t.Fatalf("Failed to read WELCOME: %v", err)
+	}
+	if len(welcome.Codecs) != 1 || welcome.Codecs[0] != "binary" {
+		t.Fatalf("Expected the host to pick binary, got %v", welcome.Codecs)
+	}
+	protocol.Binary.NewEncoder(conn).Encode(protocol.NewMessage(protocol.MessageTypeReady, ""))
+	
+	var roster protocol.Message
+	if err := protocol.Binary.NewDecoder(reader, 0).Decode(&roster); err != nil || roster.Type != protocol.MessageTypeRoster {
+		t.Errorf("Expected a binary ROSTER, got %+v (%v)", roster, err)
+	}
+}
+
+func TestServerMaxMessageSize(t *testing.T) {
+	server := startTestServer(t, 5, func(s *Server) { s.SetMaxMessageSize(4096) })
+	hostMessages := make(chan protocol.Message, 64)
+	left := make(chan string, 4)
+	server.SetCallbacks(func(msg protocol.Message) { hostMessages <- msg }, nil, func(name string) { left <- name })
+	
+	join := func(name, codec string) (*Client, chan protocol.Message, chan struct{}) {
+		received := make(chan protocol.Message, 64)
+		disconnected := make(chan struct{}, 1)
+		sess := session.New()
+		sess.SetName(name)
+		client := NewClient(sess)
+		client.SetCodecs([]string{codec})
+		client.SetMaxMessageSize(4096)
+		client.SetCallbacks(func(msg protocol.Message) { received <- msg }, nil, func() { disconnected <- struct{}{} })
+		if err := client.ConnectLocal(server.Addr().String(), server.session.ID); err != nil {
+			t.Fatalf("Join failed: %v", err)
+		}
+		t.Cleanup(client.Stop)
+		return client, received, disconnected
+	}
+	tooLarge := func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeError && msg.Code == protocol.ErrorCodeTooLarge
+	}
+	
+	// A JSON line is skipped and the connection carries on
+	alice, aliceMessages, _ := join("alice", "json")
+	alice.SendMessage(protocol.NewMessage(protocol.MessageTypeText, strings.Repeat("x", 5000)))
+	waitFor(t, aliceMessages, tooLarge)
+	alice.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "short"))
+	waitFor(t, hostMessages, isText("short"))
+	
+	// A binary frame is refused by its length and the sender dropped
+	bob, bobMessages, _ := join("bob", "binary")
+	bob.SendMessage(protocol.NewMessage(protocol.MessageTypeText, strings.Repeat("x", 5000)))
+	refused := waitFor(t, bobMessages, tooLarge)
+	if !strings.Contains(refused.Content, "too large") {
+		t.Errorf("Expected to hear the message was too large, got %q", refused.Content)
+	}
+	select {
+	case name := <-left:
+		if name != "bob" {
+			t.Errorf("Expected bob to be dropped, %s left", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bob was not dropped")
+	}
+	
+	// The other way round, carol tells the host what she could not read
+	_, carolMessages, carolGone := join("carol", "binary")
+	waitFor(t, carolMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	server.SendMessage(protocol.NewMessage(protocol.MessageTypeText, strings.Repeat("y", 5000)))
+	server.SendMessage(protocol.NewMessage(protocol.MessageTypeText, "after"))
+	waitFor(t, hostMessages, func(msg protocol.Message) bool {
+		return tooLarge(msg) && msg.From == "carol"
+	})
+	select {
+	case <-carolGone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("carol stayed connected to a broken stream")
+	}
+	
+	// alice, on JSON, skips it and reads on
+	waitFor(t, aliceMessages, isText("after"))
+}
+
+func TestServerStalledPeerDoesNotBlockOthers(t *testing.T) {
+	server := startTestServer(t, 5, func(s *Server) { s.sendQueueSize = 64 << 10 })
+	left := make(chan string, 4)
+	server.SetCallbacks(nil, nil, func(name string) { left <- name })
+	
+	// mallory joins by hand and then never reads
+	conn := dialTestServer(t, server)
+	hello := protocol.NewHandshakeMessage(protocol.MessageTypeHello, server.session.ID)
+	hello.Name = "mallory"
+	protocol.JSON.NewEncoder(conn).Encode(hello)
+	var welcome protocol.Message
+	if err := protocol.JSON.NewDecoder(bufio.NewReader(conn), 0).Decode(&welcome); err != nil {
+		t.Fatalf("Failed to read WELCOME: %v", err)
+	}
+	protocol.JSON.NewEncoder(conn).Encode(protocol.NewMessage(protocol.MessageTypeReady, ""))
+	
+	_, aliceMessages, err := joinTestServer(t, server, "alice")
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 3
+	})
+	
+	// Far more than mallory's socket buffers hold, one at a time so alice
+	// keeps up
+	for i := 0; i < 600; i++ {
+		server.SendMessage(protocol.NewMessage(protocol.MessageTypeText, strings.Repeat("x", 32<<10)))
+		waitFor(t, aliceMessages, func(msg protocol.Message) bool {
+			return msg.Type == protocol.MessageTypeText
+		})
+	}
+	
+	select {
+	case name := <-left:
+		if name != "mallory" {
+			t.Errorf("Expected mallory to be dropped, %s left", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("mallory was not dropped")
+	}
+}
+
+func TestServerJoinApproval(t *testing.T) {
+	requests := make(chan JoinRequest, 4)
+	server := startTestServer(t, 5, func(s *Server) {
+		s.SetApprover(func(ctx context.Context, req JoinRequest) bool {
+			requests <- req
+			return req.Name == "alice"
+		})
+	})
+	
+	if _, _, err := joinTestServer(t, server, "alice"); err != nil {
+		t.Fatalf("Approved join failed: %v", err)
+	}
+	req := <-requests
+	// Only the host can vouch for a key; a joiner over the network has none
+	if req.Name != "alice" || req.Addr == nil || req.Key != "" {
+		t.Errorf("Unexpected join request: %+v", req)
+	}
+	
+	_, _, err := joinTestServer(t, server, "mallory")
+	var hostErr *HostError
+	if !errors.As(err, &hostErr) || !strings.Contains(hostErr.Message, "rejected") || !errors.Is(err, ErrRejected) {
+		t.Errorf("Expected a rejection from the host, got %v", err)
+	}
+	
+	if roster := server.Roster(); len(roster) != 2 {
+		t.Errorf("Rejected joiner should not be in the roster: %v", roster)
+	}
+}
+
+func TestServerJoinApprovalTimeout(t *testing.T) {
+	server := startTestServer(t, 5, func(s *Server) {
+		s.approvalTimeout = 50 * time.Millisecond
+		s.SetApprover(func(ctx context.Context, req JoinRequest) bool {
+			<-ctx.Done()
+			return false
+		})
+	})
+	
+	_, _, err := joinTestServer(t, server, "alice")
+	var hostErr *HostError
+	if !errors.As(err, &hostErr) || !strings.Contains(hostErr.Message, "Timed out") || !errors.Is(err, ErrRejected) {
+		t.Errorf("Expected the join to time out, got %v", err)
+	}
+}
+
+func TestServerSecret(t *testing.T) {
+	sess := session.New()
+	sess.SetName("host")
+	sess.Secret = "mfrggzdfmztwq2lk"
+	server := NewServer(sess)
+	if err := server.Start(0); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(server.Stop)
+	
+	if _, _, err := joinTestServer(t, server, "mallory"); err == nil {
+		t.Error("Joining with the ID alone should fail")
+	}
+	
+	joiner := session.New()
+	joiner.Secret = sess.Secret
+	client := NewClient(joiner)
+	addr := fmt.Sprintf("127.0.0.1:%d", server.Addr().(*net.TCPAddr).Port)
+	if err := client.ConnectLocal(addr, server.session.ID); err != nil {
+		t.Fatalf("Joining with the secret failed: %v", err)
+	}
+	client.Stop()
+}
\ No newline at end of file
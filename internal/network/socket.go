@@ -0,0 +1,73 @@
+package network
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// socketDirCommand prints the directory SocketDir would pick, when run by a
+// joiner's SSH session on the host.
+const socketDirCommand = `if [ -n "$XDG_RUNTIME_DIR" ]; then printf '%s/termchat' "$XDG_RUNTIME_DIR"; else printf '/tmp/termchat-%s' "$(id -u)"; fi`
+
+// SocketDir is where sessions started with --socket listen:
+// $XDG_RUNTIME_DIR/termchat, or /tmp/termchat-<uid> without one. It is created
+// with mode 0700 and refused if anyone else could get into it.
+func SocketDir() (string, error) {
+	dir := filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "termchat")
+	if os.Getenv("XDG_RUNTIME_DIR") == "" {
+		dir = fmt.Sprintf("/tmp/termchat-%d", os.Getuid())
+	}
+	
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() || info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("%s must be a directory only you can access (chmod 700 %s)", dir, dir)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return "", fmt.Errorf("%s belongs to another user", dir)
+	}
+	return dir, nil
+}
+
+// socketName names a session's socket after a hash of its ID, so the ID does
+// not show up in directory listings.
+func socketName(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:8]) + ".sock"
+}
+
+// SocketPath returns the socket a session listens on under dir.
+func SocketPath(dir, sessionID string) string {
+	return filepath.Join(dir, socketName(sessionID))
+}
+
+// remoteSocketPath asks the host, over SSH, where its session socket is.
+func remoteSocketPath(client *ssh.Client, sessionID string) (string, error) {
+	sess, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer sess.Close()
+	
+	out, err := sess.Output(socketDirCommand)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the session socket on the host: %w", err)
+	}
+	dir := strings.TrimSpace(string(out))
+	if dir == "" {
+		return "", fmt.Errorf("failed to find the session socket on the host")
+	}
+	return dir + "/" + socketName(sessionID), nil
+}
\ No newline at end of file
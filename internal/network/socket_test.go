@@ -0,0 +1,71 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sam/termchat/internal/session"
+)
+
+func TestSocketDir(t *testing.T) {
+	runtime := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtime)
+	
+	dir, err := SocketDir()
+	if err != nil {
+		t.Fatalf("SocketDir failed: %v", err)
+	}
+	if dir != filepath.Join(runtime, "termchat") {
+		t.Errorf("Unexpected socket dir %s", dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Socket dir should be private, got %v (%v)", info.Mode().Perm(), err)
+	}
+	
+	// Refuse a directory others can get into
+	os.Chmod(dir, 0755)
+	if _, err := SocketDir(); err == nil {
+		t.Error("Expected a shared directory to be refused")
+	}
+}
+
+func TestSocketPathHidesSessionID(t *testing.T) {
+	path := SocketPath("/run/user/1000/termchat", "cosmic-turtle-7823")
+	if strings.Contains(path, "cosmic") {
+		t.Errorf("Socket name should not reveal the session ID: %s", path)
+	}
+	if path != SocketPath("/run/user/1000/termchat", "cosmic-turtle-7823") {
+		t.Error("Socket path should be stable")
+	}
+}
+
+func TestJoinOverSocket(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	dir, err := SocketDir()
+	if err != nil {
+		t.Fatalf("SocketDir failed: %v", err)
+	}
+	
+	sess := session.New()
+	sess.SetName("host")
+	server := NewServer(sess)
+	if err := server.Listen("unix:" + SocketPath(dir, sess.ID)); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer server.Stop()
+	
+	joiner := session.New()
+	joiner.SetName("alice")
+	client := NewClient(joiner)
+	if err := client.ConnectLocal("unix:"+SocketPath(dir, sess.ID), sess.ID); err != nil {
+		t.Fatalf("Join over socket failed: %v", err)
+	}
+	defer client.Stop()
+	
+	if client.HostName() != "host" {
+		t.Errorf("Expected to reach the host, got %q", client.HostName())
+	}
+}
\ No newline at end of file
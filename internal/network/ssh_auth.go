@@ -1,6 +1,7 @@
 package network
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"os"
@@ -10,43 +11,115 @@ import (
 	"golang.org/x/crypto/ssh/agent"
 )
 
-func getSSHAuthMethod() ssh.AuthMethod {
-	if authMethod := sshAgentAuth(); authMethod != nil {
-		return authMethod
+// getSSHAuthMethods offers keys from agentClient first, if there is one, then
+// the configured identity files, then the default key paths. With
+// IdentitiesOnly only the configured identities are offered, whether they
+// live on disk or in the agent.
+func getSSHAuthMethods(agentClient agent.Agent, identityFiles []string, identitiesOnly bool) []ssh.AuthMethod {
+	return []ssh.AuthMethod{
+		ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			return collectSigners(agentClient, identityFiles, identitiesOnly), nil
+		}),
 	}
-	
-	return publicKeyAuth()
 }
 
-func sshAgentAuth() ssh.AuthMethod {
-	if sshAuthSock := os.Getenv("SSH_AUTH_SOCK"); sshAuthSock != "" {
-		if conn, err := net.Dial("unix", sshAuthSock); err == nil {
-			agentClient := agent.NewClient(conn)
-			return ssh.PublicKeysCallback(agentClient.Signers)
+func collectSigners(agentClient agent.Agent, identityFiles []string, identitiesOnly bool) []ssh.Signer {
+	var signers []ssh.Signer
+	seen := make(map[string]bool)
+	add := func(s ssh.Signer) {
+		key := string(s.PublicKey().Marshal())
+		if !seen[key] {
+			seen[key] = true
+			signers = append(signers, s)
 		}
 	}
-	return nil
+	
+	var wanted [][]byte
+	if identitiesOnly {
+		for _, path := range identityFiles {
+			if pub := readPublicKey(path); pub != nil {
+				wanted = append(wanted, pub.Marshal())
+			}
+		}
+	}
+	
+	for _, s := range sshAgentSigners(agentClient) {
+		if !identitiesOnly || containsKey(wanted, s.PublicKey().Marshal()) {
+			add(s)
+		}
+	}
+	
+	keyPaths := identityFiles
+	if !identitiesOnly {
+		keyPaths = append(append([]string{}, identityFiles...), defaultKeyPaths()...)
+	}
+	
+	for _, keyPath := range keyPaths {
+		if key, err := readPrivateKey(keyPath); err == nil {
+			add(key)
+		}
+	}
+	
+	return signers
 }
 
-func publicKeyAuth() ssh.AuthMethod {
+// dialSSHAgent connects to the ssh-agent in SSH_AUTH_SOCK. It returns nil
+// when there is none; otherwise the caller closes conn once it has signed in.
+func dialSSHAgent() (agent.Agent, net.Conn) {
+	sshAuthSock := os.Getenv("SSH_AUTH_SOCK")
+	if sshAuthSock == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("unix", sshAuthSock)
+	if err != nil {
+		return nil, nil
+	}
+	return agent.NewClient(conn), conn
+}
+
+func sshAgentSigners(agentClient agent.Agent) []ssh.Signer {
+	if agentClient == nil {
+		return nil
+	}
+	signers, err := agentClient.Signers()
+	if err != nil {
+		return nil
+	}
+	return signers
+}
+
+func defaultKeyPaths() []string {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return nil
 	}
 	
-	keyPaths := []string{
+	return []string{
 		filepath.Join(home, ".ssh", "id_ed25519"),
 		filepath.Join(home, ".ssh", "id_rsa"),
 		filepath.Join(home, ".ssh", "id_ecdsa"),
 		filepath.Join(home, ".ssh", "id_dsa"),
 	}
-	
-	for _, keyPath := range keyPaths {
-		if key, err := readPrivateKey(keyPath); err == nil {
-			return ssh.PublicKeys(key)
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
 		}
 	}
-	
+	return false
+}
+
+func readPublicKey(privatePath string) ssh.PublicKey {
+	if data, err := os.ReadFile(privatePath + ".pub"); err == nil {
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey(data); err == nil {
+			return pub
+		}
+	}
+	if signer, err := readPrivateKey(privatePath); err == nil {
+		return signer.PublicKey()
+	}
 	return nil
 }
 
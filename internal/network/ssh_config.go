@@ -0,0 +1,360 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SSHHostConfig holds the subset of ssh_config(5) settings termchat uses to
+// reach a host the same way `ssh <alias>` would.
+type SSHHostConfig struct {
+	HostName       string
+	User           string
+	Port           int
+	IdentityFiles  []string
+	IdentitiesOnly bool
+	ProxyJump      string
+}
+
+type sshConfigLine struct {
+	hosts   []string // patterns of the enclosing Host block, nil for global
+	match   bool     // inside an unsupported Match block, never applies
+	keyword string
+	args    []string
+}
+
+type sshConfig struct {
+	lines []sshConfigLine
+}
+
+const maxIncludeDepth = 16
+
+func sshConfigFiles() []string {
+	var files []string
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".ssh", "config"))
+	}
+	return append(files, "/etc/ssh/ssh_config")
+}
+
+// LookupSSHConfig resolves alias through ~/.ssh/config and /etc/ssh/ssh_config.
+// Missing files are ignored; the first value obtained for a keyword wins.
+func LookupSSHConfig(alias string) (*SSHHostConfig, error) {
+	cfg := &sshConfig{}
+	for _, file := range sshConfigFiles() {
+		if err := cfg.load(file, 0); err != nil {
+			return nil, err
+		}
+	}
+	return cfg.resolve(alias), nil
+}
+
+func parseSSHConfig(r io.Reader) (*sshConfig, error) {
+	cfg := &sshConfig{}
+	if err := cfg.parse(r, "", 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *sshConfig) load(file string, depth int) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	defer f.Close()
+	
+	return c.parse(f, filepath.Dir(file), depth)
+}
+
+func (c *sshConfig) parse(r io.Reader, dir string, depth int) error {
+	var hosts []string
+	inMatch := false
+	
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		keyword, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("ssh config line %d: %w", lineNum, err)
+		}
+		if keyword == "" {
+			continue
+		}
+		
+		switch keyword {
+		case "host":
+			hosts = args
+			inMatch = false
+			continue
+		case "match":
+			// Only "Match all" is understood; other criteria are skipped
+			// rather than guessed at.
+			hosts = nil
+			inMatch = !(len(args) == 1 && strings.EqualFold(args[0], "all"))
+			continue
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("ssh config: Include nested too deeply")
+			}
+			if err := c.include(args, dir, hosts, inMatch, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		
+		c.lines = append(c.lines, sshConfigLine{
+			hosts:   hosts,
+			match:   inMatch,
+			keyword: keyword,
+			args:    args,
+		})
+	}
+	
+	return scanner.Err()
+}
+
+// include splices the lines of each matching file into the current block,
+// as OpenSSH does for Include inside a Host section.
+func (c *sshConfig) include(patterns []string, dir string, hosts []string, inMatch bool, depth int) error {
+	for _, pattern := range patterns {
+		pattern = expandTilde(pattern)
+		if !filepath.IsAbs(pattern) && dir != "" {
+			pattern = filepath.Join(dir, pattern)
+		}
+		
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("ssh config: bad Include pattern %q: %w", pattern, err)
+		}
+		
+		for _, file := range matches {
+			sub := &sshConfig{}
+			if err := sub.load(file, depth); err != nil {
+				return err
+			}
+			for _, line := range sub.lines {
+				if line.hosts == nil && !line.match {
+					line.hosts = hosts
+					line.match = inMatch
+				}
+				c.lines = append(c.lines, line)
+			}
+		}
+	}
+	return nil
+}
+
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+	
+	var args []string
+	var current strings.Builder
+	inQuote := false
+	hasArg := false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuote:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		case r == '#' && !inQuote && !hasArg:
+			return keyword, args, nil
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if inQuote {
+		return "", nil, fmt.Errorf("unterminated quote")
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	
+	return keyword, args, nil
+}
+
+func (c *sshConfig) resolve(alias string) *SSHHostConfig {
+	result := &SSHHostConfig{}
+	seen := make(map[string]bool)
+	
+	for _, line := range c.lines {
+		if line.match || len(line.args) == 0 {
+			continue
+		}
+		if line.hosts != nil && !matchHostPatterns(line.hosts, alias) {
+			continue
+		}
+		
+		if line.keyword == "identityfile" {
+			result.IdentityFiles = append(result.IdentityFiles, line.args[0])
+			continue
+		}
+		
+		if seen[line.keyword] {
+			continue
+		}
+		seen[line.keyword] = true
+		
+		value := line.args[0]
+		switch line.keyword {
+		case "hostname":
+			result.HostName = value
+		case "user":
+			result.User = value
+		case "port":
+			if p, err := strconv.Atoi(value); err == nil && p > 0 && p <= 65535 {
+				result.Port = p
+			}
+		case "identitiesonly":
+			result.IdentitiesOnly = strings.EqualFold(value, "yes")
+		case "proxyjump":
+			result.ProxyJump = value
+		}
+	}
+	
+	if result.HostName == "" {
+		result.HostName = alias
+	} else {
+		result.HostName = strings.ReplaceAll(result.HostName, "%h", alias)
+	}
+	
+	return result
+}
+
+// ApplySSHConfig rewrites the connection target using the ssh config entry for
+// info.Host. An explicit user in the join string takes precedence.
+func (info *ConnectionInfo) ApplySSHConfig() error {
+	cfg, err := LookupSSHConfig(info.Host)
+	if err != nil {
+		return err
+	}
+	info.applyHostConfig(cfg)
+	return nil
+}
+
+func (info *ConnectionInfo) applyHostConfig(cfg *SSHHostConfig) {
+	info.Host = cfg.HostName
+	
+	if info.User == "" {
+		info.User = cfg.User
+	}
+	if info.User == "" {
+		info.User = currentUsername()
+	}
+	cfg.User = info.User
+	
+	if info.SSHPort == 0 {
+		info.SSHPort = cfg.Port
+	}
+	if info.SSHPort == 0 {
+		info.SSHPort = 22
+	}
+	
+	info.IdentityFiles = nil
+	for _, file := range cfg.IdentityFiles {
+		info.IdentityFiles = append(info.IdentityFiles, expandSSHTokens(file, cfg))
+	}
+	info.IdentitiesOnly = cfg.IdentitiesOnly
+	
+	if info.ProxyJump == "" && cfg.ProxyJump != "none" {
+		info.ProxyJump = cfg.ProxyJump
+	}
+}
+
+func matchHostPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, p := range patterns {
+		negated := strings.HasPrefix(p, "!")
+		p = strings.TrimPrefix(p, "!")
+		
+		ok, err := path.Match(strings.ToLower(p), strings.ToLower(host))
+		if err != nil || !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// expandSSHTokens handles the ssh_config tokens that make sense for
+// IdentityFile paths: %d, %h, %r, %u, %% and a leading ~.
+func expandSSHTokens(s string, cfg *SSHHostConfig) string {
+	home, _ := os.UserHomeDir()
+	localUser := currentUsername()
+	
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'd':
+			b.WriteString(home)
+		case 'h':
+			b.WriteString(cfg.HostName)
+		case 'r':
+			b.WriteString(cfg.User)
+		case 'u':
+			b.WriteString(localUser)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(s[i])
+		}
+	}
+	return expandTilde(b.String())
+}
+
+func expandTilde(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, strings.TrimPrefix(p, "~"))
+}
+
+func currentUsername() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
\ No newline at end of file
@@ -0,0 +1,139 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSSHConfig = `
+# Team hosts
+Host devbox
+    HostName devbox.corp.example.com
+    User alice
+    Port 2222
+    IdentityFile ~/.ssh/id_devbox
+    IdentitiesOnly yes
+    ProxyJump bastion
+
+Host *.corp !legacy.corp
+    User corp-user
+
+Host = quoted
+    IdentityFile "/keys/with space"
+
+Match exec "true"
+    User never
+
+Host *
+    User fallback
+    IdentityFile ~/.ssh/id_%h
+`
+
+func TestSSHConfigAlias(t *testing.T) {
+	cfg, err := parseSSHConfig(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	
+	got := cfg.resolve("devbox")
+	if got.HostName != "devbox.corp.example.com" {
+		t.Errorf("HostName = %q", got.HostName)
+	}
+	if got.User != "alice" {
+		t.Errorf("User = %q, first match should win", got.User)
+	}
+	if got.Port != 2222 {
+		t.Errorf("Port = %d", got.Port)
+	}
+	if !got.IdentitiesOnly {
+		t.Error("IdentitiesOnly should be set")
+	}
+	if got.ProxyJump != "bastion" {
+		t.Errorf("ProxyJump = %q", got.ProxyJump)
+	}
+	
+	wantFiles := []string{"~/.ssh/id_devbox", "~/.ssh/id_%h"}
+	if !reflect.DeepEqual(got.IdentityFiles, wantFiles) {
+		t.Errorf("IdentityFiles = %v, want %v", got.IdentityFiles, wantFiles)
+	}
+}
+
+func TestSSHConfigPatterns(t *testing.T) {
+	cfg, err := parseSSHConfig(strings.NewReader(testSSHConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	
+	tests := []struct {
+		host string
+		user string
+	}{
+		{"web.corp", "corp-user"},
+		{"WEB.CORP", "corp-user"},
+		{"legacy.corp", "fallback"},
+		{"elsewhere", "fallback"},
+	}
+	
+	for _, tt := range tests {
+		if got := cfg.resolve(tt.host); got.User != tt.user {
+			t.Errorf("resolve(%q).User = %q, want %q", tt.host, got.User, tt.user)
+		}
+		if got := cfg.resolve(tt.host); got.HostName != tt.host {
+			t.Errorf("resolve(%q).HostName = %q, want alias unchanged", tt.host, got.HostName)
+		}
+	}
+	
+	if got := cfg.resolve("quoted"); got.IdentityFiles[0] != "/keys/with space" {
+		t.Errorf("Quoted IdentityFile = %q", got.IdentityFiles[0])
+	}
+}
+
+func TestSSHConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "team.conf"), []byte("Host jump\n  HostName 10.0.0.1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config")
+	if err := os.WriteFile(configPath, []byte("Include team.conf\nHost *\n  Port 2200\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	
+	cfg := &sshConfig{}
+	if err := cfg.load(configPath, 0); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	
+	got := cfg.resolve("jump")
+	if got.HostName != "10.0.0.1" || got.Port != 2200 {
+		t.Errorf("Included config not applied: %+v", got)
+	}
+}
+
+func TestApplyHostConfig(t *testing.T) {
+	info := &ConnectionInfo{User: "bob", Host: "devbox", SessionID: "s", Port: 9999}
+	info.applyHostConfig(&SSHHostConfig{
+		HostName:      "10.1.2.3",
+		User:          "alice",
+		IdentityFiles: []string{"/keys/%r@%h"},
+		ProxyJump:     "bastion",
+	})
+	
+	if info.User != "bob" {
+		t.Errorf("Explicit user should win, got %q", info.User)
+	}
+	if info.Host != "10.1.2.3" {
+		t.Errorf("Host = %q", info.Host)
+	}
+	if info.SSHPort != 22 {
+		t.Errorf("SSHPort = %d, want default 22", info.SSHPort)
+	}
+	if info.IdentityFiles[0] != "/keys/bob@10.1.2.3" {
+		t.Errorf("IdentityFile tokens not expanded: %q", info.IdentityFiles[0])
+	}
+	if info.ProxyJump != "bastion" {
+		t.Errorf("ProxyJump = %q", info.ProxyJump)
+	}
+}
\ No newline at end of file
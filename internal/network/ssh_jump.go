@@ -0,0 +1,201 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshDialTimeout bounds connecting to each hop and its SSH handshake.
+const sshDialTimeout = 15 * time.Second
+
+// maxJumpDepth bounds jump hosts that name their own ProxyJump, in case the
+// config loops.
+const maxJumpDepth = 8
+
+// JumpError reports which host in an SSH jump chain could not be reached.
+// Hop is 1-based; the final hop is the join target itself.
+type JumpError struct {
+	Hop   int
+	Total int
+	Addr  string
+	Stage string
+	Err   error
+}
+
+func (e *JumpError) Error() string {
+	switch {
+	case e.Total == 1:
+		return fmt.Sprintf("SSH connection to %s failed (%s): %v", e.Addr, e.Stage, e.Err)
+	case e.Hop == e.Total:
+		return fmt.Sprintf("SSH connection to target %s via %d jump host(s) failed (%s): %v",
+			e.Addr, e.Total-1, e.Stage, e.Err)
+	default:
+		return fmt.Sprintf("SSH jump host %d/%d %s failed (%s): %v",
+			e.Hop, e.Total-1, e.Addr, e.Stage, e.Err)
+	}
+}
+
+func (e *JumpError) Unwrap() error {
+	return e.Err
+}
+
+// parseJumpHosts expands a ProxyJump / --jump value into hops, each resolved
+// through the ssh config so jump hosts can be aliases too. As with OpenSSH,
+// the first jump host's own ProxyJump is followed, since it is reached as if
+// dialled directly, while those of later hops are ignored.
+func parseJumpHosts(spec string) ([]*ConnectionInfo, error) {
+	return expandJumpHosts(spec, 0)
+}
+
+func expandJumpHosts(spec string, depth int) ([]*ConnectionInfo, error) {
+	if spec == "" || spec == "none" {
+		return nil, nil
+	}
+	if depth >= maxJumpDepth {
+		return nil, fmt.Errorf("ProxyJump nests more than %d deep; does the ssh config loop?", maxJumpDepth)
+	}
+	
+	var hops []*ConnectionInfo
+	for _, part := range strings.Split(spec, ",") {
+		hop, err := parseJumpHost(part)
+		if err != nil {
+			return nil, err
+		}
+		if err := hop.ApplySSHConfig(); err != nil {
+			return nil, err
+		}
+		hops = append(hops, hop)
+	}
+	
+	if first := hops[0]; first.ProxyJump != "" {
+		before, err := expandJumpHosts(first.ProxyJump, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		hops = append(before, hops...)
+	}
+	return hops, nil
+}
+
+func parseJumpHost(spec string) (*ConnectionInfo, error) {
+	spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+	info := &ConnectionInfo{}
+	
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		info.User = spec[:at]
+		spec = spec[at+1:]
+	}
+	
+	info.Host = spec
+	if host, port, err := net.SplitHostPort(spec); err == nil {
+		p, err := strconv.Atoi(port)
+		if err != nil || p < 1 || p > 65535 {
+			return nil, fmt.Errorf("invalid jump host port: %s", port)
+		}
+		info.Host = host
+		info.SSHPort = p
+	}
+	
+	if info.Host == "" {
+		return nil, fmt.Errorf("invalid jump host: %q", spec)
+	}
+	return info, nil
+}
+
+// dialSSHChain opens an SSH connection to each hop in turn, tunnelling every
+// connection after the first through the previous one. Each hop gets its own
+// credentials and host key check. On failure every opened client is closed.
+func dialSSHChain(hops []*ConnectionInfo, hostKeys *hostKeyChecker) ([]*ssh.Client, error) {
+	var clients []*ssh.Client
+	closeAll := func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}
+	
+	for i, hop := range hops {
+		port := hop.SSHPort
+		if port == 0 {
+			port = 22
+		}
+		
+		addr := net.JoinHostPort(hop.Host, strconv.Itoa(port))
+		agentClient, agentConn := dialSSHAgent()
+		sshConfig := &ssh.ClientConfig{
+			User:              hop.User,
+			Auth:              getSSHAuthMethods(agentClient, hop.IdentityFiles, hop.IdentitiesOnly),
+			HostKeyCallback:   hostKeys.Callback(),
+			HostKeyAlgorithms: hostKeys.KeyAlgorithms(addr),
+			Timeout:           sshDialTimeout,
+		}
+		
+		var via *ssh.Client
+		if len(clients) > 0 {
+			via = clients[len(clients)-1]
+		}
+		
+		client, stage, err := dialSSHHop(via, addr, sshConfig)
+		if agentConn != nil {
+			// Only needed to sign in
+			agentConn.Close()
+		}
+		if err != nil {
+			closeAll()
+			return nil, &JumpError{
+				Hop:   i + 1,
+				Total: len(hops),
+				Addr:  fmt.Sprintf("%s@%s", hop.User, addr),
+				Stage: stage,
+				Err:   err,
+			}
+		}
+		clients = append(clients, client)
+	}
+	
+	return clients, nil
+}
+
+// dialSSHHop connects to addr, through via unless it is the first hop, and
+// runs the SSH handshake. Each step must finish within config.Timeout, so a
+// stalled jump host cannot hang the chain.
+func dialSSHHop(via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+	
+	var conn net.Conn
+	var err error
+	if via == nil {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = via.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, "connect", err
+	}
+	
+	// A tunnelled connection has no deadlines, so close it to give up
+	expired := time.AfterFunc(config.Timeout, func() { conn.Close() })
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !expired.Stop() {
+		if sshConn != nil {
+			sshConn.Close()
+		}
+		return nil, "handshake", errSSHTimeout
+	}
+	if err != nil {
+		conn.Close()
+		return nil, "handshake", err
+	}
+	
+	return ssh.NewClient(sshConn, chans, reqs), "", nil
+}
+
+var errSSHTimeout = errors.New("timed out")
\ No newline at end of file
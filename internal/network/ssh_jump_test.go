@@ -0,0 +1,137 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseJumpHost(t *testing.T) {
+	hop, err := parseJumpHost("ops@bastion.example.com:2022")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if hop.User != "ops" || hop.Host != "bastion.example.com" || hop.SSHPort != 2022 {
+		t.Errorf("Unexpected hop: %+v", hop)
+	}
+	
+	if _, err := parseJumpHost("bastion:notaport"); err == nil {
+		t.Error("Expected error for invalid port")
+	}
+	
+	hops, err := parseJumpHosts("none")
+	if err != nil || hops != nil {
+		t.Errorf("ProxyJump none should disable jumping, got %v, %v", hops, err)
+	}
+}
+
+func TestDialSSHChainReportsFailedHop(t *testing.T) {
+	// Grab a free port and close it so the first hop is refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+	
+	hops := []*ConnectionInfo{
+		{User: "ops", Host: "127.0.0.1", SSHPort: addr.Port},
+		{User: "alice", Host: "devbox", SSHPort: 22},
+	}
+	
+	_, err = dialSSHChain(hops, &hostKeyChecker{})
+	var jumpErr *JumpError
+	if !errors.As(err, &jumpErr) {
+		t.Fatalf("Expected JumpError, got %v", err)
+	}
+	
+	if jumpErr.Hop != 1 || jumpErr.Total != 2 || jumpErr.Stage != "connect" {
+		t.Errorf("Unexpected hop error: %+v", jumpErr)
+	}
+	
+	if !strings.Contains(err.Error(), "jump host 1/1 ops@127.0.0.1") {
+		t.Errorf("Error should name the failing jump host: %v", err)
+	}
+}
+
+func TestJumpErrorTarget(t *testing.T) {
+	err := &JumpError{Hop: 3, Total: 3, Addr: "alice@devbox:22", Stage: "handshake", Err: errors.New("boom")}
+	if !strings.Contains(err.Error(), "target alice@devbox:22 via 2 jump host(s)") {
+		t.Errorf("Unexpected message: %v", err)
+	}
+}
+
+func TestParseJumpHostsFollowsFirstHop(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	config := `
+Host outer
+    HostName 192.0.2.1
+    ProxyJump inner
+Host inner
+    HostName 192.0.2.0
+Host middle
+    HostName 192.0.2.2
+    ProxyJump ignored
+Host loop
+    ProxyJump loop
+`
+	os.MkdirAll(filepath.Join(home, ".ssh"), 0700)
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	
+	hops, err := parseJumpHosts("outer,middle")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var got []string
+	for _, hop := range hops {
+		got = append(got, hop.Host)
+	}
+	if strings.Join(got, ",") != "192.0.2.0,192.0.2.1,192.0.2.2" {
+		t.Errorf("Expected inner, outer, then middle, got %v", got)
+	}
+	
+	if _, err := parseJumpHosts("loop"); err == nil {
+		t.Error("Expected a ProxyJump loop to be an error")
+	}
+}
+
+func TestDialSSHHopTimesOut(t *testing.T) {
+	// A jump host that accepts but never says anything
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	
+	config := &ssh.ClientConfig{
+		User:            "ops",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         100 * time.Millisecond,
+	}
+	start := time.Now()
+	_, stage, err := dialSSHHop(nil, l.Addr().String(), config)
+	if err == nil || stage != "handshake" {
+		t.Fatalf("Expected the handshake to fail, got %q: %v", stage, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Gave up after %s, want about %s", elapsed, config.Timeout)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,37 @@
+package network
+
+import "net"
+
+// Transport carries a client's connection to the host: an SSH tunnel, TCP or
+// a Unix socket, a relay, a punched UDP path, or a pipe within the host's own
+// process. The protocol runs the same over all of them, and Dial is called
+// again to resume after a drop.
+type Transport interface {
+	Dial() (net.Conn, error)
+}
+
+// TransportFunc lets an ordinary function be a Transport.
+type TransportFunc func() (net.Conn, error)
+
+func (f TransportFunc) Dial() (net.Conn, error) {
+	return f()
+}
+
+// Connect joins sessionID over t.
+func (c *Client) Connect(sessionID string, t Transport) error {
+	c.session.ID = sessionID
+	c.transport = t
+	return c.connect()
+}
+
+// RemoteAddr is the host's address on the current connection, or nil. Its
+// network tells which path we took, e.g. "udp" once hole punching worked or
+// "relay" when the relay carries the session.
+func (c *Client) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.RemoteAddr()
+}
\ No newline at end of file
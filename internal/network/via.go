@@ -0,0 +1,170 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// viaKeepalive is how often we check the SSH connection a forwarded
+	// port depends on; a dead one is only noticed when we try
+	viaKeepalive = 30 * time.Second
+	
+	// viaRetryInterval is how long to wait before reconnecting
+	viaRetryInterval = 5 * time.Second
+)
+
+// ParseSSHHost reads [user@]host[:port], as given to --via, and applies the
+// ssh config so it can be an alias.
+func ParseSSHHost(spec string) (*ConnectionInfo, error) {
+	info, err := parseJumpHost(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err := info.ApplySSHConfig(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ListenVia has the SSH server at info forward a port on its loopback
+// interface to us, for a host that accepts no inbound connections but can
+// reach a machine its joiners can too. Port 0 lets the server pick one.
+// Joiners then join user@that-host:session-id:port as usual. Call it after
+// Listen; it returns the forwarded port.
+func (s *Server) ListenVia(info *ConnectionInfo, port int, prompt HostKeyPrompt) (int, error) {
+	return s.listenVia(info, port, newHostKeyChecker(prompt))
+}
+
+func (s *Server) listenVia(info *ConnectionInfo, port int, hostKeys *hostKeyChecker) (int, error) {
+	l := &viaListener{info: info, hostKeys: hostKeys, port: port}
+	if err := l.open(); err != nil {
+		return 0, err
+	}
+	l.hostKeys = hostKeys.unattended()
+	
+	s.mu.Lock()
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+	
+	go s.acceptConnections(l)
+	return l.port, nil
+}
+
+// viaListener accepts joiners on a port an SSH server forwards to us. When
+// the SSH connection drops it reconnects and asks for the same port again,
+// so the join string stays valid.
+type viaListener struct {
+	info     *ConnectionInfo
+	hostKeys *hostKeyChecker
+	port     int
+	
+	mu       sync.Mutex
+	clients  []*ssh.Client // jump hosts, then the server itself
+	listener net.Listener
+	closed   bool
+}
+
+func (l *viaListener) open() error {
+	jumps, err := parseJumpHosts(l.info.ProxyJump)
+	if err != nil {
+		return err
+	}
+	clients, err := dialSSHChain(append(jumps, l.info), l.hostKeys)
+	if err != nil {
+		return err
+	}
+	closeAll := func() {
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}
+	
+	sshClient := clients[len(clients)-1]
+	listener, err := sshClient.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(l.port)))
+	if err != nil {
+		closeAll()
+		return fmt.Errorf("%s would not forward a port to us (is AllowTcpForwarding on?): %w", l.info.Host, err)
+	}
+	
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		closeAll()
+		return net.ErrClosed
+	}
+	l.clients = clients
+	l.listener = listener
+	l.port = listener.Addr().(*net.TCPAddr).Port
+	go l.keepalive(sshClient)
+	return nil
+}
+
+func (l *viaListener) keepalive(sshClient *ssh.Client) {
+	ticker := time.NewTicker(viaKeepalive)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, _, err := sshClient.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			sshClient.Close()
+			return
+		}
+	}
+}
+
+func (l *viaListener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		listener, closed := l.listener, l.closed
+		l.mu.Unlock()
+		if closed {
+			return nil, net.ErrClosed
+		}
+		
+		if listener != nil {
+			conn, err := listener.Accept()
+			if err == nil {
+				return conn, nil
+			}
+			l.disconnect()
+			continue
+		}
+		
+		time.Sleep(viaRetryInterval)
+		l.open()
+	}
+}
+
+// disconnect drops a broken SSH connection so Accept reconnects.
+func (l *viaListener) disconnect() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	if l.listener != nil {
+		l.listener.Close()
+		l.listener = nil
+	}
+	for i := len(l.clients) - 1; i >= 0; i-- {
+		l.clients[i].Close()
+	}
+	l.clients = nil
+}
+
+func (l *viaListener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.disconnect()
+	return nil
+}
+
+// Addr is the forwarded port on the SSH server.
+func (l *viaListener) Addr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: l.port}
+}
\ No newline at end of file
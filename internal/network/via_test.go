@@ -0,0 +1,162 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sam/termchat/internal/session"
+	"github.com/sam/termchat/pkg/protocol"
+	"golang.org/x/crypto/ssh"
+)
+
+// startForwardingSSHServer runs an SSH server that lets anyone in and honors
+// remote port forwards (ssh -R), as a shared dev server would.
+func startForwardingSSHServer(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	_, hostKey, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(hostKey)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	
+	go func() {
+		for {
+			raw, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveForwards(t, raw, config)
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func serveForwards(t *testing.T, raw net.Conn, config *ssh.ServerConfig) {
+	conn, chans, reqs, err := ssh.NewServerConn(raw, config)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(nil)
+	go func() {
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "no channels here")
+		}
+	}()
+	
+	for req := range reqs {
+		if req.Type != "tcpip-forward" {
+			req.Reply(false, nil)
+			continue
+		}
+		var fwd struct {
+			Addr string
+			Port uint32
+		}
+		ssh.Unmarshal(req.Payload, &fwd)
+		forwarded, err := net.Listen("tcp", net.JoinHostPort(fwd.Addr, fmt.Sprint(fwd.Port)))
+		if err != nil {
+			req.Reply(false, nil)
+			continue
+		}
+		t.Cleanup(func() { forwarded.Close() })
+		port := uint32(forwarded.Addr().(*net.TCPAddr).Port)
+		req.Reply(true, ssh.Marshal(struct{ Port uint32 }{port}))
+		
+		go func() {
+			for {
+				joiner, err := forwarded.Accept()
+				if err != nil {
+					return
+				}
+				origin := joiner.RemoteAddr().(*net.TCPAddr)
+				ch, chReqs, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+					Addr       string
+					Port       uint32
+					OriginAddr string
+					OriginPort uint32
+				}{fwd.Addr, port, origin.IP.String(), uint32(origin.Port)}))
+				if err != nil {
+					joiner.Close()
+					continue
+				}
+				go ssh.DiscardRequests(chReqs)
+				go func() {
+					io.Copy(ch, joiner)
+					ch.CloseWrite()
+				}()
+				go func() {
+					io.Copy(joiner, ch)
+					joiner.Close()
+				}()
+			}
+		}()
+	}
+}
+
+func writeTestIdentity(t *testing.T) string {
+	t.Helper()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestListenVia(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	shared := startForwardingSSHServer(t)
+	server := startTestServer(t, 5)
+	
+	info := &ConnectionInfo{
+		User:           "alice",
+		Host:           "127.0.0.1",
+		SSHPort:        shared.Port,
+		IdentityFiles:  []string{writeTestIdentity(t)},
+		IdentitiesOnly: true,
+	}
+	hostKeys := newTestChecker(t, "", func(string, net.Addr, ssh.PublicKey) bool { return true })
+	port, err := server.listenVia(info, 0, hostKeys)
+	if err != nil {
+		t.Fatalf("listenVia failed: %v", err)
+	}
+	if port == 0 {
+		t.Fatal("Expected the server to pick a port")
+	}
+	
+	// A joiner on the shared machine reaches us through the forwarded port
+	received := make(chan protocol.Message, 64)
+	sess := session.New()
+	sess.SetName("bob")
+	client := NewClient(sess)
+	client.SetCallbacks(func(msg protocol.Message) { received <- msg }, nil, nil)
+	if err := client.ConnectLocal(fmt.Sprintf("127.0.0.1:%d", port), server.session.ID); err != nil {
+		t.Fatalf("Join through the forwarded port failed: %v", err)
+	}
+	defer client.Stop()
+	
+	waitFor(t, received, func(msg protocol.Message) bool {
+		return msg.Type == protocol.MessageTypeRoster && len(msg.Roster) == 2
+	})
+}
\ No newline at end of file
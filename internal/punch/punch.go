@@ -0,0 +1,228 @@
+// Package punch gets two peers behind NATs talking over UDP. Each learns the
+// address its NAT maps it to from a rendezvous, the two swap candidate
+// addresses through it, and then both send probes at once so each NAT sees
+// outgoing traffic before the other side's packets arrive. A Stream then
+// carries a reliable byte stream over the punched path.
+package punch
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Timeout is how long peers probe before giving up on a direct path.
+const Timeout = 3 * time.Second
+
+const (
+	probeEvery    = 100 * time.Millisecond
+	registerEvery = 200 * time.Millisecond
+	registerFor   = time.Second
+)
+
+// probePrefix starts every probe; no Stream packet type uses this byte.
+const probePrefix = 'P'
+
+// Candidates are the addresses a peer might be reachable at.
+type Candidates struct {
+	Nonce    string   `json:"nonce"`              // identifies the peer's probes
+	Observed string   `json:"observed,omitempty"` // as the rendezvous saw it
+	Local    []string `json:"local,omitempty"`    // on the peer's own interfaces
+}
+
+// Open creates the socket to punch from and lists where it can be reached
+// on this machine's interfaces.
+func Open() (*net.UDPConn, *Candidates, error) {
+	sock, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	cands := &Candidates{Nonce: hex.EncodeToString(nonce)}
+	
+	port := sock.LocalAddr().(*net.UDPAddr).Port
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() || ipNet.IP.IsMulticast() {
+			continue
+		}
+		cands.Local = append(cands.Local, (&net.UDPAddr{IP: ipNet.IP, Port: port}).String())
+	}
+	return sock, cands, nil
+}
+
+type registration struct {
+	Op       string `json:"op"`
+	Nonce    string `json:"nonce,omitempty"`
+	Observed string `json:"observed,omitempty"`
+}
+
+// Register tells the rendezvous about sock so it can pass on the address our
+// NAT maps it to, and fills that address in.
+func Register(sock *net.UDPConn, rendezvous string, cands *Candidates) error {
+	addr, err := net.ResolveUDPAddr("udp", rendezvous)
+	if err != nil {
+		return err
+	}
+	request, _ := json.Marshal(registration{Op: "register", Nonce: cands.Nonce})
+	
+	defer sock.SetReadDeadline(time.Time{})
+	buf := make([]byte, 512)
+	deadline := time.Now().Add(registerFor)
+	for time.Now().Before(deadline) {
+		if _, err := sock.WriteToUDP(request, addr); err != nil {
+			return err
+		}
+		
+		sock.SetReadDeadline(time.Now().Add(registerEvery))
+		n, from, err := sock.ReadFromUDP(buf)
+		if err != nil {
+			continue
+		}
+		var reply registration
+		if from.Port != addr.Port || json.Unmarshal(buf[:n], &reply) != nil || reply.Op != "registered" {
+			continue
+		}
+		cands.Observed = reply.Observed
+		return nil
+	}
+	return errors.New("rendezvous did not answer over UDP")
+}
+
+// Attempt probes a peer's candidates from sock.
+type Attempt struct {
+	sock   *net.UDPConn
+	ours   *Candidates
+	theirs *Candidates
+	
+	mu        sync.Mutex
+	peer      *net.UDPAddr // where their probes come from
+	confirmed bool         // they have seen ours too
+	changed   chan struct{}
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// Start begins probing. Probing continues until Stop, so the peer can still
+// hear from us while the two sides agree on the outcome.
+func Start(sock *net.UDPConn, ours, theirs *Candidates) *Attempt {
+	a := &Attempt{
+		sock:    sock,
+		ours:    ours,
+		theirs:  theirs,
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	a.wg.Add(2)
+	go a.send()
+	go a.listen()
+	return a
+}
+
+func (a *Attempt) targets() []*net.UDPAddr {
+	a.mu.Lock()
+	peer := a.peer
+	a.mu.Unlock()
+	if peer != nil {
+		return []*net.UDPAddr{peer}
+	}
+	
+	var targets []*net.UDPAddr
+	for _, cand := range append([]string{a.theirs.Observed}, a.theirs.Local...) {
+		if addr, err := net.ResolveUDPAddr("udp", cand); err == nil && cand != "" {
+			targets = append(targets, addr)
+		}
+	}
+	return targets
+}
+
+func (a *Attempt) send() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(probeEvery)
+	defer ticker.Stop()
+	
+	for {
+		a.mu.Lock()
+		seen := byte('0')
+		if a.peer != nil {
+			seen = '1'
+		}
+		a.mu.Unlock()
+		
+		probe := append([]byte{probePrefix, seen}, a.ours.Nonce...)
+		for _, target := range a.targets() {
+			a.sock.WriteToUDP(probe, target)
+		}
+		
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Attempt) listen() {
+	defer a.wg.Done()
+	buf := make([]byte, 512)
+	for {
+		n, from, err := a.sock.ReadFromUDP(buf)
+		if err != nil {
+			return // Stop sets a deadline to get us here
+		}
+		if n < 2 || buf[0] != probePrefix || !bytes.Equal(buf[2:n], []byte(a.theirs.Nonce)) {
+			continue
+		}
+		
+		a.mu.Lock()
+		if a.peer == nil {
+			a.peer = from
+		}
+		if buf[1] == '1' && from.String() == a.peer.String() {
+			a.confirmed = true
+		}
+		a.mu.Unlock()
+		
+		select {
+		case a.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Wait returns the peer's address once probes have got through both ways,
+// or nil if that has not happened within timeout.
+func (a *Attempt) Wait(timeout time.Duration) *net.UDPAddr {
+	deadline := time.After(timeout)
+	for {
+		a.mu.Lock()
+		if a.confirmed {
+			peer := a.peer
+			a.mu.Unlock()
+			return peer
+		}
+		a.mu.Unlock()
+		
+		select {
+		case <-a.changed:
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+// Stop ends probing and hands sock back to the caller.
+func (a *Attempt) Stop() {
+	close(a.stop)
+	a.sock.SetReadDeadline(time.Now())
+	a.wg.Wait()
+	a.sock.SetReadDeadline(time.Time{})
+}
\ No newline at end of file
@@ -0,0 +1,63 @@
+package punch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttempt(t *testing.T) {
+	sockA, sockB := listenLoopback(t), listenLoopback(t)
+	defer sockA.Close()
+	defer sockB.Close()
+	
+	candsA := &Candidates{Nonce: "aaaa", Local: []string{sockA.LocalAddr().String()}}
+	candsB := &Candidates{Nonce: "bbbb", Local: []string{"192.0.2.1:9", sockB.LocalAddr().String()}}
+	
+	a := Start(sockA, candsA, candsB)
+	b := Start(sockB, candsB, candsA)
+	peerA, peerB := a.Wait(Timeout), b.Wait(Timeout)
+	a.Stop()
+	b.Stop()
+	
+	if peerA == nil || peerA.String() != sockB.LocalAddr().String() {
+		t.Errorf("A should have found B at %s, got %v", sockB.LocalAddr(), peerA)
+	}
+	if peerB == nil || peerB.String() != sockA.LocalAddr().String() {
+		t.Errorf("B should have found A at %s, got %v", sockA.LocalAddr(), peerB)
+	}
+}
+
+func TestAttemptGivesUp(t *testing.T) {
+	sock := listenLoopback(t)
+	defer sock.Close()
+	
+	// Nobody is probing back from a documentation address
+	a := Start(sock, &Candidates{Nonce: "aaaa"}, &Candidates{Nonce: "bbbb", Observed: "192.0.2.1:9"})
+	defer a.Stop()
+	if peer := a.Wait(200 * time.Millisecond); peer != nil {
+		t.Errorf("Expected no path, got %v", peer)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	rendezvous := listenLoopback(t)
+	defer rendezvous.Close()
+	go func() {
+		buf := make([]byte, 512)
+		n, from, err := rendezvous.ReadFromUDP(buf)
+		if err != nil || string(buf[:n]) != `{"op":"register","nonce":"aaaa"}` {
+			return
+		}
+		rendezvous.WriteToUDP([]byte(`{"op":"registered","observed":"203.0.113.7:4000"}`), from)
+	}()
+	
+	sock := listenLoopback(t)
+	defer sock.Close()
+	cands := &Candidates{Nonce: "aaaa"}
+	if err := Register(sock, rendezvous.LocalAddr().String(), cands); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if cands.Observed != "203.0.113.7:4000" {
+		t.Errorf("Expected the observed address, got %q", cands.Observed)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,343 @@
+package punch
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Packets on a Stream start with a type byte, then the sequence number of
+// the segment and the cumulative acknowledgement, both big-endian uint32.
+const (
+	typeData byte = 1 + iota
+	typeAck
+	typeFin
+	typePing
+)
+
+const (
+	headerSize  = 9
+	maxSegment  = 1200 // fits in one datagram on any path we are likely to see
+	window      = 64   // segments in flight
+	minRTO      = 200 * time.Millisecond
+	maxRTO      = 2 * time.Second
+	tickEvery   = 50 * time.Millisecond
+	keepalive   = 10 * time.Second // also keeps NAT mappings open
+	idleTimeout = 45 * time.Second
+	lingerFor   = 5 * time.Second // Close gives up on a peer that is silent this long
+)
+
+// errPeerGone means the other side stopped answering.
+var errPeerGone = errors.New("peer stopped answering")
+
+type segment struct {
+	seq    uint32
+	kind   byte
+	data   []byte
+	sentAt time.Time
+	rto    time.Duration
+}
+
+// Stream is a reliable, ordered byte stream over UDP: a sliding window of
+// numbered segments, cumulative acks and retransmission with backoff. It is
+// only as much TCP as a chat needs.
+type Stream struct {
+	sock *net.UDPConn
+	peer *net.UDPAddr
+	
+	mu       sync.Mutex
+	cond     *sync.Cond
+	sendNext uint32     // sequence number for the next segment
+	inFlight []*segment // sent but not acknowledged, oldest first
+	recvNext uint32     // next sequence number we expect
+	early    map[uint32]*segment
+	readBuf  []byte
+	eof      bool // the peer's FIN has been read
+	err      error
+	closed   bool
+	lastSent time.Time
+	heard    time.Time
+	
+	readDeadline  time.Time
+	writeDeadline time.Time
+	done          chan struct{}
+}
+
+// NewStream runs a Stream to peer over sock, which it takes over.
+func NewStream(sock *net.UDPConn, peer *net.UDPAddr) *Stream {
+	s := &Stream{
+		sock:  sock,
+		peer:  peer,
+		early: make(map[uint32]*segment),
+		heard: time.Now(),
+		done:  make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.receive()
+	go s.tick()
+	return s
+}
+
+func (s *Stream) receive() {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := s.sock.ReadFromUDP(buf)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if n < headerSize || buf[0] < typeData || buf[0] > typePing || !from.IP.Equal(s.peer.IP) || from.Port != s.peer.Port {
+			// Late probes or someone else's packets
+			continue
+		}
+		s.handle(buf[0], binary.BigEndian.Uint32(buf[1:5]), binary.BigEndian.Uint32(buf[5:9]), buf[headerSize:n])
+	}
+}
+
+func (s *Stream) handle(kind byte, seq, ack uint32, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	s.heard = time.Now()
+	s.acked(ack)
+	
+	switch kind {
+	case typeData, typeFin:
+		if before(seq, s.recvNext) || seq-s.recvNext >= window {
+			// A duplicate, or too far ahead to keep; the ack sorts it out
+		} else if _, seen := s.early[seq]; !seen {
+			s.early[seq] = &segment{seq: seq, kind: kind, data: append([]byte(nil), payload...)}
+		}
+		for seg := s.early[s.recvNext]; seg != nil; seg = s.early[s.recvNext] {
+			delete(s.early, s.recvNext)
+			s.recvNext++
+			if seg.kind == typeFin {
+				s.eof = true
+			} else {
+				s.readBuf = append(s.readBuf, seg.data...)
+			}
+		}
+		s.sendLocked(typeAck, 0, nil)
+	case typePing:
+		s.sendLocked(typeAck, 0, nil)
+	}
+	s.cond.Broadcast()
+}
+
+// acked drops every segment the peer has confirmed.
+func (s *Stream) acked(ack uint32) {
+	n := 0
+	for n < len(s.inFlight) && before(s.inFlight[n].seq, ack) {
+		n++
+	}
+	if n > 0 {
+		s.inFlight = s.inFlight[n:]
+		s.cond.Broadcast()
+	}
+}
+
+// tick retransmits what the peer has not acknowledged in time, keeps the
+// path alive and notices when the peer has gone.
+func (s *Stream) tick() {
+	ticker := time.NewTicker(tickEvery)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		
+		s.mu.Lock()
+		now := time.Now()
+		if now.Sub(s.heard) > idleTimeout {
+			s.mu.Unlock()
+			s.fail(errPeerGone)
+			return
+		}
+		for _, seg := range s.inFlight {
+			if now.Sub(seg.sentAt) >= seg.rto {
+				seg.rto = min(seg.rto*2, maxRTO)
+				seg.sentAt = now
+				s.sendLocked(seg.kind, seg.seq, seg.data)
+			}
+		}
+		if now.Sub(s.lastSent) >= keepalive {
+			s.sendLocked(typePing, 0, nil)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Stream) sendLocked(kind byte, seq uint32, data []byte) {
+	packet := make([]byte, headerSize+len(data))
+	packet[0] = kind
+	binary.BigEndian.PutUint32(packet[1:5], seq)
+	binary.BigEndian.PutUint32(packet[5:9], s.recvNext)
+	copy(packet[headerSize:], data)
+	s.sock.WriteToUDP(packet, s.peer)
+	s.lastSent = time.Now()
+}
+
+// queueLocked sends a new segment and keeps it until it is acknowledged.
+func (s *Stream) queueLocked(kind byte, data []byte) {
+	seg := &segment{seq: s.sendNext, kind: kind, data: data, sentAt: time.Now(), rto: minRTO}
+	s.sendNext++
+	s.inFlight = append(s.inFlight, seg)
+	s.sendLocked(kind, seg.seq, data)
+}
+
+func (s *Stream) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	for len(s.readBuf) == 0 {
+		switch {
+		case s.eof:
+			return 0, io.EOF
+		case s.err != nil:
+			return 0, s.err
+		case s.closed:
+			return 0, net.ErrClosed
+		}
+		if err := s.waitLocked(s.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+	
+	n := copy(b, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	return n, nil
+}
+
+func (s *Stream) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	written := 0
+	for written < len(b) {
+		for len(s.inFlight) >= window {
+			if err := s.writableLocked(); err != nil {
+				return written, err
+			}
+			if err := s.waitLocked(s.writeDeadline); err != nil {
+				return written, err
+			}
+		}
+		if err := s.writableLocked(); err != nil {
+			return written, err
+		}
+		
+		chunk := b[written:]
+		if len(chunk) > maxSegment {
+			chunk = chunk[:maxSegment]
+		}
+		s.queueLocked(typeData, append([]byte(nil), chunk...))
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+func (s *Stream) writableLocked() error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.closed {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+// waitLocked sleeps until something changes or the deadline passes.
+func (s *Stream) waitLocked(deadline time.Time) error {
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.AfterFunc(wait, func() {
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		})
+		defer timer.Stop()
+	}
+	s.cond.Wait()
+	return nil
+}
+
+func (s *Stream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil && !s.closed {
+		s.err = err
+		close(s.done)
+		s.sock.Close()
+	}
+	s.cond.Broadcast()
+}
+
+// Close sends a FIN after any data still in flight and waits for the peer to
+// acknowledge it all.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.closed || s.err != nil {
+		s.closed = true
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.queueLocked(typeFin, nil)
+	
+	// Wait for what is still in flight, unless the peer has gone quiet or
+	// has sent its own FIN and may already be gone
+	for len(s.inFlight) > 0 && s.err == nil && !s.eof && time.Since(s.heard) < lingerFor {
+		s.waitLocked(time.Now().Add(tickEvery))
+	}
+	
+	if s.err == nil {
+		close(s.done)
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return s.sock.Close()
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return s.sock.LocalAddr()
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.peer
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.readDeadline = t
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	s.writeDeadline = t
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	return nil
+}
+
+// before compares sequence numbers, allowing for wraparound.
+func before(a, b uint32) bool {
+	return int32(a-b) < 0
+}
\ No newline at end of file
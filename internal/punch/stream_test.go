@@ -0,0 +1,99 @@
+package punch
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	mathrand "math/rand/v2"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func listenLoopback(t *testing.T) *net.UDPConn {
+	t.Helper()
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	return sock
+}
+
+// lossyLink forwards datagrams between a and b, dropping a fifth of them and
+// holding back some more so they arrive out of order.
+func lossyLink(t *testing.T, a, b *net.UDPAddr) *net.UDPAddr {
+	t.Helper()
+	proxy := listenLoopback(t)
+	t.Cleanup(func() { proxy.Close() })
+	
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			size, from, err := proxy.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			to := b
+			if from.Port == b.Port {
+				to = a
+			}
+			packet := append([]byte(nil), buf[:size]...)
+			switch n := mathrand.IntN(10); {
+			case n < 2:
+			case n < 3:
+				time.AfterFunc(30*time.Millisecond, func() { proxy.WriteToUDP(packet, to) })
+			default:
+				proxy.WriteToUDP(packet, to)
+			}
+		}
+	}()
+	return proxy.LocalAddr().(*net.UDPAddr)
+}
+
+func TestStreamOverLossyLink(t *testing.T) {
+	sockA, sockB := listenLoopback(t), listenLoopback(t)
+	link := lossyLink(t, sockA.LocalAddr().(*net.UDPAddr), sockB.LocalAddr().(*net.UDPAddr))
+	a, b := NewStream(sockA, link), NewStream(sockB, link)
+	defer a.Close()
+	
+	payload := make([]byte, 200*1024)
+	rand.Read(payload)
+	go func() {
+		a.Write(payload)
+		a.Close()
+	}()
+	
+	b.SetReadDeadline(time.Now().Add(20 * time.Second))
+	got, err := io.ReadAll(b)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("Received %d bytes that differ from the %d sent", len(got), len(payload))
+	}
+	b.Close()
+}
+
+func TestStreamDeadline(t *testing.T) {
+	sockA, sockB := listenLoopback(t), listenLoopback(t)
+	a := NewStream(sockA, sockB.LocalAddr().(*net.UDPAddr))
+	b := NewStream(sockB, sockA.LocalAddr().(*net.UDPAddr))
+	defer a.Close()
+	defer b.Close()
+	
+	b.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	var netErr net.Error
+	if _, err := b.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) || !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	
+	// The stream still works once the deadline is lifted
+	b.SetReadDeadline(time.Time{})
+	a.Write([]byte("hi"))
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(b, buf); err != nil || string(buf) != "hi" {
+		t.Errorf("Read %q (%v)", buf, err)
+	}
+}
\ No newline at end of file
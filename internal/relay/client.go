@@ -0,0 +1,275 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sam/termchat/internal/punch"
+)
+
+// retryInterval is how long a host waits before registering again after
+// losing the relay.
+const retryInterval = 5 * time.Second
+
+// Addr is the address of a peer reached through a relay.
+type Addr struct {
+	Relay string // the relay's host:port
+	Peer  string // the peer's address as the relay saw it, if known
+}
+
+func (a Addr) Network() string {
+	return "relay"
+}
+
+// String is the peer's address, so callers that track peers by IP still can.
+func (a Addr) String() string {
+	if a.Peer == "" {
+		return a.Relay
+	}
+	return a.Peer
+}
+
+// Error is a refusal from the relay.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "relay: " + e.Message
+}
+
+type conn struct {
+	net.Conn
+	remote Addr
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Dial joins the session registered under tag at the relay. The connection
+// goes straight to the host if hole punching works and through the relay
+// otherwise; it behaves the same either way.
+func Dial(relayAddr, tag string) (net.Conn, error) {
+	sock, cands := prepare(relayAddr)
+	c, r, err := open(relayAddr, request{Op: "join", Tag: tag, Punch: cands}, acceptTimeout)
+	if err != nil {
+		if sock != nil {
+			sock.Close()
+		}
+		return nil, err
+	}
+	return upgrade(c, sock, cands, r.Punch, Addr{Relay: relayAddr})
+}
+
+// prepare opens a socket to punch from and registers it with the relay's
+// rendezvous. It returns nil if that fails, and we just stay relayed.
+func prepare(relayAddr string) (*net.UDPConn, *punch.Candidates) {
+	sock, cands, err := punch.Open()
+	if err != nil {
+		return nil, nil
+	}
+	if err := punch.Register(sock, relayAddr, cands); err != nil {
+		sock.Close()
+		return nil, nil
+	}
+	return sock, cands
+}
+
+// upgrade tries to replace a relayed connection with a direct one. Both
+// sides then tell each other over the relay whether probes got through, and
+// switch only if both say yes; otherwise one could be left talking to a
+// path the other has given up on.
+func upgrade(c net.Conn, sock *net.UDPConn, ours, theirs *punch.Candidates, remote Addr) (net.Conn, error) {
+	relayed := &conn{Conn: c, remote: remote}
+	if sock == nil || theirs == nil {
+		if sock != nil {
+			sock.Close()
+		}
+		return relayed, nil
+	}
+	
+	attempt := punch.Start(sock, ours, theirs)
+	peer := attempt.Wait(punch.Timeout)
+	verdict := []byte{'T'}
+	if peer != nil {
+		verdict[0] = 'U'
+	}
+	
+	c.SetDeadline(time.Now().Add(requestTimeout))
+	_, err := c.Write(verdict)
+	if err == nil {
+		_, err = io.ReadFull(c, verdict)
+	}
+	c.SetDeadline(time.Time{})
+	attempt.Stop()
+	
+	switch {
+	case err != nil:
+		c.Close()
+		sock.Close()
+		return nil, fmt.Errorf("relay connection failed: %w", err)
+	case peer != nil && verdict[0] == 'U':
+		c.Close()
+		return punch.NewStream(sock, peer), nil
+	default:
+		sock.Close()
+		return relayed, nil
+	}
+}
+
+// open sends req on a new connection to the relay and waits up to wait for a
+// reply.
+func open(relayAddr string, req request, wait time.Duration) (net.Conn, *reply, error) {
+	c, err := net.DialTimeout("tcp", relayAddr, requestTimeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to reach relay: %w", err)
+	}
+	
+	c.SetDeadline(time.Now().Add(requestTimeout + wait))
+	var r reply
+	if err := writeLine(c, req); err == nil {
+		err = readLine(c, &r)
+	}
+	if err != nil {
+		c.Close()
+		return nil, nil, fmt.Errorf("relay did not answer: %w", err)
+	}
+	c.SetDeadline(time.Time{})
+	
+	if !r.OK {
+		c.Close()
+		return nil, nil, &Error{Message: r.Error}
+	}
+	return c, &r, nil
+}
+
+// Listener hosts a session at a relay. It stays registered for as long as it
+// is open, registering again if the relay restarts.
+type Listener struct {
+	relay   string
+	tag     string
+	ready   chan net.Conn
+	closed  chan struct{}
+	control net.Conn
+	mu      sync.Mutex
+}
+
+// Listen registers as the host of tag at the relay.
+func Listen(relayAddr, tag string) (*Listener, error) {
+	control, _, err := open(relayAddr, request{Op: "host", Tag: tag}, 0)
+	if err != nil {
+		return nil, err
+	}
+	
+	l := &Listener{
+		relay:   relayAddr,
+		tag:     tag,
+		ready:   make(chan net.Conn),
+		closed:  make(chan struct{}),
+		control: control,
+	}
+	go l.watch(control)
+	return l, nil
+}
+
+// watch picks up incoming joiners and re-registers when the relay goes
+// away.
+func (l *Listener) watch(control net.Conn) {
+	for {
+		for {
+			var r reply
+			if err := readLine(control, &r); err != nil {
+				break
+			}
+			if r.Op == "incoming" {
+				go l.pickUp(r)
+			}
+		}
+		control.Close()
+		
+		for {
+			select {
+			case <-l.closed:
+				return
+			case <-time.After(retryInterval):
+			}
+			
+			var err error
+			if control, _, err = open(l.relay, request{Op: "host", Tag: l.tag}, 0); err == nil {
+				break
+			}
+		}
+		
+		l.mu.Lock()
+		l.control = control
+		l.mu.Unlock()
+		select {
+		case <-l.closed:
+			control.Close()
+			return
+		default:
+		}
+	}
+}
+
+// pickUp opens the connection for one joiner and queues it for Accept.
+// Punching takes a few seconds, so joiners are picked up side by side.
+func (l *Listener) pickUp(incoming reply) {
+	var sock *net.UDPConn
+	var cands *punch.Candidates
+	if incoming.Punch != nil {
+		sock, cands = prepare(l.relay)
+	}
+	
+	c, r, err := open(l.relay, request{Op: "accept", Tag: l.tag, ID: incoming.ID, Punch: cands}, 0)
+	if err != nil {
+		// The joiner gave up or the relay went away
+		if sock != nil {
+			sock.Close()
+		}
+		return
+	}
+	
+	conn, err := upgrade(c, sock, cands, r.Punch, Addr{Relay: l.relay, Peer: incoming.Addr})
+	if err != nil {
+		return
+	}
+	select {
+	case l.ready <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// Accept waits for the next joiner.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case conn := <-l.ready:
+		return conn, nil
+	}
+}
+
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	select {
+	case <-l.closed:
+		return errors.New("relay listener already closed")
+	default:
+	}
+	close(l.closed)
+	return l.control.Close()
+}
+
+// Addr is the relay the session is registered at.
+func (l *Listener) Addr() net.Addr {
+	return Addr{Relay: l.relay}
+}
\ No newline at end of file
@@ -0,0 +1,444 @@
+// Package relay pairs peers that cannot reach each other. Both sides dial
+// out to the relay and name the session they want by an opaque tag; the
+// relay then splices their connections together and forwards bytes it
+// cannot read, since everything on a session is end-to-end encrypted.
+//
+// Every connection starts with one JSON request line from the peer, answered
+// by one JSON line from the relay:
+//
+//	{"op":"host","tag":T}        register as the host of T; the connection
+//	                             stays open and receives {"op":"incoming"}
+//	                             lines, one per joiner
+//	{"op":"join","tag":T}        join T; answered once the host accepts
+//	{"op":"accept","tag":T,"id":N}  the host's connection for joiner N
+//
+// A reply of {"ok":true} on a join or accept means the connection now leads
+// straight to the other side.
+//
+// The relay is also a rendezvous for UDP hole punching. Peers register a UDP
+// socket on the relay's port to learn their public address, and send their
+// candidate addresses with join and accept; each side's ok then carries the
+// other's, and the two try to reach each other directly before settling for
+// the relayed connection.
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sam/termchat/internal/punch"
+)
+
+// DefaultPort is where relays listen unless told otherwise.
+const DefaultPort = 7777
+
+const (
+	// requestTimeout bounds how long a new connection may take to say what
+	// it wants
+	requestTimeout = 10 * time.Second
+	
+	// acceptTimeout bounds how long a joiner waits for the host to pick up
+	acceptTimeout = 10 * time.Second
+	
+	// maxWaiting caps joiners waiting on one host, so a flood of joins
+	// cannot make the relay hold unlimited connections
+	maxWaiting = 16
+	
+	// maxLine caps a request or reply line
+	maxLine = 512
+)
+
+type request struct {
+	Op    string            `json:"op"`
+	Tag   string            `json:"tag"`
+	ID    uint64            `json:"id,omitempty"`
+	Punch *punch.Candidates `json:"punch,omitempty"`
+}
+
+type reply struct {
+	OK    bool              `json:"ok,omitempty"`
+	Op    string            `json:"op,omitempty"`
+	ID    uint64            `json:"id,omitempty"`
+	Addr  string            `json:"addr,omitempty"`  // the joiner's address, on "incoming"
+	Punch *punch.Candidates `json:"punch,omitempty"` // the other side's, if both can punch
+	Error string            `json:"error,omitempty"`
+}
+
+// registered is a punching socket seen by the rendezvous.
+type registered struct {
+	addr string
+	at   time.Time
+}
+
+// forgetRegistrations is how long the rendezvous remembers a socket.
+const forgetRegistrations = time.Minute
+
+// Server is a relay. It keeps no state beyond the hosts connected to it.
+type Server struct {
+	listener net.Listener
+	udp      *net.UDPConn
+	observed map[string]registered // by nonce
+	hosts    map[string]*host
+	conns    map[net.Conn]bool
+	nextID   uint64
+	noPunch  bool
+	onEvent  func(event string)
+	mu       sync.Mutex
+}
+
+type host struct {
+	control net.Conn
+	waiting map[uint64]*joiner
+	writeMu sync.Mutex
+}
+
+type joiner struct {
+	conn   net.Conn
+	punch  *punch.Candidates
+	paired chan net.Conn // the host's end, or nil once conn is closed
+}
+
+func NewServer() *Server {
+	return &Server{
+		hosts:    make(map[string]*host),
+		observed: make(map[string]registered),
+		conns:    make(map[net.Conn]bool),
+	}
+}
+
+// SetEventCallback is told when hosts come and go and joiners are paired.
+func (s *Server) SetEventCallback(onEvent func(event string)) {
+	s.onEvent = onEvent
+}
+
+// DisablePunching makes the relay carry every session rather than helping
+// peers reach each other directly, which also keeps their addresses from each
+// other. Call it before Listen.
+func (s *Server) DisablePunching() {
+	s.noPunch = true
+}
+
+func (s *Server) event(format string, args ...any) {
+	if s.onEvent != nil {
+		s.onEvent(fmt.Sprintf(format, args...))
+	}
+}
+
+// Listen starts relaying on addr, e.g. ":7777".
+func (s *Server) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start relay: %w", err)
+	}
+	
+	// Punching is optional; without UDP everyone is relayed
+	var udp *net.UDPConn
+	if !s.noPunch {
+		bound := listener.Addr().(*net.TCPAddr)
+		udp, err = net.ListenUDP("udp", &net.UDPAddr{IP: bound.IP, Port: bound.Port})
+		if err != nil {
+			s.event("no UDP rendezvous, every session will be relayed: %v", err)
+		}
+	}
+	
+	s.mu.Lock()
+	s.listener = listener
+	s.udp = udp
+	s.mu.Unlock()
+	
+	go s.acceptConnections(listener)
+	if udp != nil {
+		go s.rendezvous(udp)
+	}
+	return nil
+}
+
+// rendezvous tells punching sockets the address they came from and
+// remembers it for the join or accept that follows.
+func (s *Server) rendezvous(udp *net.UDPConn) {
+	buf := make([]byte, maxLine)
+	for {
+		n, from, err := udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var req struct {
+			Op    string `json:"op"`
+			Nonce string `json:"nonce"`
+		}
+		if json.Unmarshal(buf[:n], &req) != nil || req.Op != "register" || req.Nonce == "" {
+			continue
+		}
+		
+		now := time.Now()
+		s.mu.Lock()
+		for nonce, r := range s.observed {
+			if now.Sub(r.at) > forgetRegistrations {
+				delete(s.observed, nonce)
+			}
+		}
+		s.observed[req.Nonce] = registered{addr: from.String(), at: now}
+		s.mu.Unlock()
+		
+		ack, _ := json.Marshal(map[string]string{"op": "registered", "observed": from.String()})
+		udp.WriteToUDP(ack, from)
+	}
+}
+
+// candidates fills in the public address we saw for a peer's socket.
+func (s *Server) candidates(c *punch.Candidates) *punch.Candidates {
+	if c == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	filled := *c
+	if r, ok := s.observed[c.Nonce]; ok {
+		filled.Observed = r.addr
+	}
+	return &filled
+}
+
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Server) acceptConnections(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+	
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+	var req request
+	if err := readLine(conn, &req); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	
+	if req.Tag == "" {
+		refuse(conn, "missing tag")
+		return
+	}
+	
+	switch req.Op {
+	case "host":
+		s.serveHost(conn, req.Tag)
+	case "join":
+		s.serveJoiner(conn, req.Tag, s.candidates(req.Punch))
+	case "accept":
+		s.pair(conn, req.Tag, req.ID, s.candidates(req.Punch))
+	default:
+		refuse(conn, fmt.Sprintf("unknown op %q", req.Op))
+	}
+}
+
+// serveHost registers a host until its control connection closes.
+func (s *Server) serveHost(conn net.Conn, tag string) {
+	h := &host{control: conn, waiting: make(map[uint64]*joiner)}
+	
+	s.mu.Lock()
+	if _, taken := s.hosts[tag]; taken {
+		s.mu.Unlock()
+		refuse(conn, "that session is already hosted here")
+		return
+	}
+	s.hosts[tag] = h
+	s.mu.Unlock()
+	
+	h.write(reply{OK: true})
+	s.event("host registered from %s", conn.RemoteAddr())
+	
+	// Hosts send nothing more; reading just notices when they go
+	io.Copy(io.Discard, conn)
+	
+	s.mu.Lock()
+	delete(s.hosts, tag)
+	waiting := h.waiting
+	h.waiting = nil
+	s.mu.Unlock()
+	
+	for _, j := range waiting {
+		refuse(j.conn, "the host left")
+		j.paired <- nil
+	}
+	conn.Close()
+	s.event("host from %s left", conn.RemoteAddr())
+}
+
+// serveJoiner asks the host to pick up and waits for it to do so.
+func (s *Server) serveJoiner(conn net.Conn, tag string, cands *punch.Candidates) {
+	j := &joiner{conn: conn, punch: cands, paired: make(chan net.Conn, 1)}
+	
+	s.mu.Lock()
+	h := s.hosts[tag]
+	if h == nil {
+		s.mu.Unlock()
+		refuse(conn, "no session with that ID on this relay")
+		return
+	}
+	if len(h.waiting) >= maxWaiting {
+		s.mu.Unlock()
+		refuse(conn, "too many people joining at once, try again")
+		return
+	}
+	s.nextID++
+	id := s.nextID
+	h.waiting[id] = j
+	s.mu.Unlock()
+	
+	h.write(reply{Op: "incoming", ID: id, Addr: conn.RemoteAddr().String(), Punch: cands})
+	
+	var other net.Conn
+	select {
+	case other = <-j.paired:
+	case <-time.After(acceptTimeout):
+		s.mu.Lock()
+		_, stillWaiting := h.waiting[id]
+		delete(h.waiting, id)
+		s.mu.Unlock()
+		if stillWaiting {
+			refuse(conn, "the host did not answer")
+			return
+		}
+		
+		// Someone else took it off the list just now and will hand it over
+		other = <-j.paired
+	}
+	
+	if other != nil {
+		s.event("paired %s with its host", conn.RemoteAddr())
+		splice(conn, other)
+	}
+}
+
+// pair hands the host's connection to the joiner it was opened for.
+func (s *Server) pair(conn net.Conn, tag string, id uint64, cands *punch.Candidates) {
+	s.mu.Lock()
+	var j *joiner
+	if h := s.hosts[tag]; h != nil {
+		j = h.waiting[id]
+		delete(h.waiting, id)
+	}
+	s.mu.Unlock()
+	
+	if j == nil {
+		refuse(conn, "that joiner is gone")
+		return
+	}
+	
+	// Tell both ends before any of their bytes can cross. Candidates go
+	// out only if both sides can punch, so they agree on whether to try.
+	toHost, toJoiner := reply{OK: true}, reply{OK: true}
+	if cands != nil && j.punch != nil {
+		toHost.Punch, toJoiner.Punch = j.punch, cands
+	}
+	if writeLine(conn, toHost) != nil || writeLine(j.conn, toJoiner) != nil {
+		conn.Close()
+		j.conn.Close()
+		j.paired <- nil
+		return
+	}
+	j.paired <- conn
+}
+
+func (h *host) write(r reply) {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	if err := writeLine(h.control, r); err != nil {
+		h.control.Close()
+	}
+}
+
+// Stop closes the listener and every connection.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	if s.udp != nil {
+		s.udp.Close()
+		s.udp = nil
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func refuse(conn net.Conn, reason string) {
+	conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+	writeLine(conn, reply{Error: reason})
+	conn.Close()
+}
+
+// splice copies both ways until either side hangs up.
+func splice(a, b net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+		close(done)
+	}()
+	io.Copy(b, a)
+	b.Close()
+	a.Close()
+	<-done
+}
+
+func writeLine(conn net.Conn, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
+
+// readLine reads one JSON line a byte at a time, so nothing after it is
+// consumed; those bytes belong to the other side.
+func readLine(conn net.Conn, v any) error {
+	var line []byte
+	var b [1]byte
+	for {
+		if _, err := conn.Read(b[:]); err != nil {
+			return err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if len(line) == maxLine {
+			return errors.New("relay line too long")
+		}
+		line = append(line, b[0])
+	}
+	return json.Unmarshal(line, v)
+}
\ No newline at end of file
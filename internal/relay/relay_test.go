@@ -0,0 +1,135 @@
+package relay
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startTestRelay(t *testing.T, punching bool) *Server {
+	t.Helper()
+	server := NewServer()
+	if !punching {
+		server.DisablePunching()
+	}
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(server.Stop)
+	return server
+}
+
+// pairTestPeers hosts a session at the relay and joins it, returning the
+// host's and the joiner's ends.
+func pairTestPeers(t *testing.T, server *Server) (net.Conn, net.Conn) {
+	t.Helper()
+	addr := server.Addr().String()
+	
+	listener, err := Listen(addr, "tag")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("Accept failed: %v", err)
+		}
+		accepted <- conn
+	}()
+	
+	joiner, err := Dial(addr, "tag")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { joiner.Close() })
+	
+	host := <-accepted
+	if host == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { host.Close() })
+	return host, joiner
+}
+
+func checkPath(t *testing.T, host, joiner net.Conn) {
+	t.Helper()
+	joiner.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(host, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Host read %q (%v)", buf, err)
+	}
+	host.Write([]byte("pong"))
+	if _, err := io.ReadFull(joiner, buf); err != nil || string(buf) != "pong" {
+		t.Errorf("Joiner read %q (%v)", buf, err)
+	}
+	
+	// Hanging up on one side reaches the other
+	host.Close()
+	joiner.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := joiner.Read(buf); err != io.EOF {
+		t.Errorf("Expected EOF after the host hung up, got %v", err)
+	}
+}
+
+func TestRelayPairs(t *testing.T) {
+	host, joiner := pairTestPeers(t, startTestRelay(t, false))
+	
+	if got, want := host.RemoteAddr().String(), joiner.LocalAddr().String(); got != want {
+		t.Errorf("Host should see the joiner as %s, got %s", want, got)
+	}
+	if host.RemoteAddr().Network() != "relay" || joiner.RemoteAddr().Network() != "relay" {
+		t.Errorf("Expected a relayed path, got %s and %s", host.RemoteAddr().Network(), joiner.RemoteAddr().Network())
+	}
+	checkPath(t, host, joiner)
+}
+
+func TestRelayPunches(t *testing.T) {
+	host, joiner := pairTestPeers(t, startTestRelay(t, true))
+	
+	if host.RemoteAddr().Network() != "udp" || joiner.RemoteAddr().Network() != "udp" {
+		t.Errorf("Expected a punched path, got %s and %s", host.RemoteAddr().Network(), joiner.RemoteAddr().Network())
+	}
+	checkPath(t, host, joiner)
+}
+
+func TestRelayRefuses(t *testing.T) {
+	server := startTestRelay(t, false)
+	addr := server.Addr().String()
+	
+	var relayErr *Error
+	if _, err := Dial(addr, "nobody"); !errors.As(err, &relayErr) || !strings.Contains(relayErr.Message, "no session") {
+		t.Errorf("Expected no session, got %v", err)
+	}
+	
+	listener, err := Listen(addr, "tag")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	if _, err := Listen(addr, "tag"); !errors.As(err, &relayErr) {
+		t.Errorf("A second host for the same session should be refused, got %v", err)
+	}
+	
+	// Once the host is gone the session is too
+	listener.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := Dial(addr, "tag")
+		if errors.As(err, &relayErr) && strings.Contains(relayErr.Message, "no session") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Session still registered after the host left: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept on a closed listener should fail, got %v", err)
+	}
+}
\ No newline at end of file
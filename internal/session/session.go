@@ -1,10 +1,17 @@
 package session
 
 import (
+	"crypto/rand"
+	"encoding/base32"
 	"fmt"
-	"math/rand"
+	"math"
+	"math/big"
+	"os"
+	"os/user"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/sam/termchat/pkg/protocol"
 )
@@ -15,56 +22,227 @@ const (
 	StateCreated State = iota
 	StateWaiting
 	StateActive
+	StateReconnecting
 	StateEnded
 )
 
 type Session struct {
 	ID        string
+	Secret    string // optional high-entropy part of the join string
+	Name      string // our own display name in this session
 	StartTime time.Time
 	Messages  []protocol.Message
 	State     State
 	mu        sync.RWMutex
 }
 
-var (
-	adjectives = []string{
-		"cosmic", "mystic", "stellar", "quantum",
-		"cyber", "neon", "turbo", "ultra",
-		"alpha", "omega", "shadow", "ghost",
-		"plasma", "lunar", "solar", "astral",
+const (
+	// DefaultIDWords gives IDs like cosmic-turtle-lunar-falcon-7823, about 41
+	// bits of entropy
+	DefaultIDWords = 4
+	MaxIDWords     = 12
+	
+	// MinIDBits is the least estimated entropy accepted for an ID the user
+	// picks themselves, unless a secret protects the session as well
+	MinIDBits = 40
+	
+	maxIDLength = 128
+	
+	// MaxHistory is how many messages a session keeps; older ones are
+	// forgotten
+	MaxHistory = 1000
+)
+
+func GenerateSessionID() string {
+	return GenerateSessionIDWords(DefaultIDWords)
+}
+
+// GenerateSessionIDWords returns an ID of the given number of words,
+// alternating adjectives and nouns, followed by a 4-digit number. Each word
+// adds 7 bits and the number about 13.
+func GenerateSessionIDWords(words int) string {
+	words = max(1, min(words, MaxIDWords))
+	parts := make([]string, 0, words+1)
+	for i := 0; i < words; i++ {
+		list := adjectives
+		if i%2 == 1 {
+			list = nouns
+		}
+		parts = append(parts, list[randomInt(len(list))])
+	}
+	parts = append(parts, fmt.Sprintf("%04d", randomInt(10000)))
+	return strings.Join(parts, "-")
+}
+
+// GenerateSecret returns 128 random bits in lowercase base32, to be added to
+// the join string when a memorable ID alone is not enough.
+func GenerateSecret() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b[:]))
+}
+
+func randomInt(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return int(v.Int64())
+}
+
+// ValidateSessionID checks that an ID can be written in a join string.
+func ValidateSessionID(id string) error {
+	if id == "" {
+		return fmt.Errorf("session ID cannot be empty")
+	}
+	if len(id) > maxIDLength {
+		return fmt.Errorf("session ID must be at most %d characters", maxIDLength)
+	}
+	for _, r := range id {
+		if !isIDChar(r) {
+			return fmt.Errorf("session ID may only contain letters, digits, '-', '_' and '.'")
+		}
+	}
+	return nil
+}
+
+func isIDChar(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.')
+}
+
+// IDStrength roughly estimates how many bits of guessing a session ID takes.
+// Words from our lists count 7 bits, numbers 3.3 bits per digit, and other
+// lowercase words no more than a pick from a few thousand common words.
+func IDStrength(id string) float64 {
+	bits := 0.0
+	parts := strings.FieldsFunc(id, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	})
+	for _, part := range parts {
+		bits += partStrength(part)
+	}
+	return bits
+}
+
+func partStrength(part string) float64 {
+	if isListWord(part) {
+		return math.Log2(float64(len(adjectives)))
 	}
 	
-	nouns = []string{
-		"phoenix", "dragon", "tiger", "eagle",
-		"shark", "wolf", "bear", "fox",
-		"turtle", "falcon", "raven", "nebula",
-		"comet", "meteor", "galaxy", "nova",
+	var lower, upper, digit bool
+	for _, r := range part {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
 	}
-)
+	
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if pool == 0 {
+		return 0
+	}
+	bits := float64(len(part)) * math.Log2(float64(pool))
+	if !upper && !digit {
+		// Probably a real word, and people pick common ones
+		bits = math.Min(bits, math.Log2(4096))
+	}
+	return bits
+}
 
-// No need for init() - Go 1.20+ automatically seeds rand
+func isListWord(word string) bool {
+	for _, list := range [][]string{adjectives, nouns} {
+		for _, w := range list {
+			if w == word {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-func GenerateSessionID() string {
-	adj := adjectives[rand.Intn(len(adjectives))]
-	noun := nouns[rand.Intn(len(nouns))]
-	num := rand.Intn(10000)
-	return fmt.Sprintf("%s-%s-%d", adj, noun, num)
+// CheckIDStrength rejects a user-chosen session ID that is invalid or too
+// easy to guess.
+func CheckIDStrength(id string) error {
+	if err := ValidateSessionID(id); err != nil {
+		return err
+	}
+	if bits := IDStrength(id); bits < MinIDBits {
+		return fmt.Errorf("session ID is too easy to guess (about %.0f bits, need %d); add more words or numbers", bits, MinIDBits)
+	}
+	return nil
+}
+
+const MaxNameLength = 32
+
+// DefaultName is the display name used when none is given: the login name.
+func DefaultName() string {
+	if name := os.Getenv("USER"); ValidateName(name) == nil {
+		return name
+	}
+	if u, err := user.Current(); err == nil && ValidateName(u.Username) == nil {
+		return u.Username
+	}
+	return "anonymous"
+}
+
+// ValidateName checks that a display name is short, printable and has no
+// whitespace, so it renders cleanly as a message label.
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	if len(name) > MaxNameLength {
+		return fmt.Errorf("name must be at most %d bytes", MaxNameLength)
+	}
+	if strings.IndexFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || !unicode.IsPrint(r)
+	}) >= 0 {
+		return fmt.Errorf("name must not contain spaces or control characters")
+	}
+	return nil
 }
 
 func New() *Session {
 	return &Session{
 		ID:        GenerateSessionID(),
+		Name:      DefaultName(),
 		StartTime: time.Now(),
 		Messages:  make([]protocol.Message, 0),
 		State:     StateCreated,
 	}
 }
 
+// Credential is what a joiner has to know to get in, as written in the join
+// string: the ID, followed by "+secret" if the session has a secret.
+func (s *Session) Credential() string {
+	if s.Secret == "" {
+		return s.ID
+	}
+	return s.ID + "+" + s.Secret
+}
+
 func (s *Session) AddMessage(msg protocol.Message) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
 	msg.Timestamp = time.Now().UnixMilli()
+	if len(s.Messages) >= MaxHistory {
+		// The next append that grows the slice leaves the old ones behind
+		s.Messages = s.Messages[len(s.Messages)-MaxHistory+1:]
+	}
 	s.Messages = append(s.Messages, msg)
 }
 
@@ -75,6 +253,18 @@ func (s *Session) GetMessages() []protocol.Message {
 	return append([]protocol.Message{}, s.Messages...)
 }
 
+func (s *Session) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Name = name
+}
+
+func (s *Session) GetName() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Name
+}
+
 func (s *Session) SetState(state State) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -1,9 +1,10 @@
 package session
 
 import (
+	"fmt"
 	"strings"
 	"testing"
-	
+
 	"github.com/sam/termchat/pkg/protocol"
 )
 
@@ -12,8 +13,11 @@ func TestGenerateSessionID(t *testing.T) {
 	id := GenerateSessionID()
 	parts := strings.Split(id, "-")
 	
-	if len(parts) != 3 {
-		t.Errorf("Expected 3 parts in session ID, got %d: %s", len(parts), id)
+	if len(parts) != DefaultIDWords+1 {
+		t.Errorf("Expected %d parts in session ID, got %d: %s", DefaultIDWords+1, len(parts), id)
+	}
+	if err := CheckIDStrength(id); err != nil {
+		t.Errorf("Generated ID should pass its own strength check: %v", err)
 	}
 	
 	// Test uniqueness
@@ -87,6 +91,21 @@ func TestAddMessage(t *testing.T) {
 	}
 }
 
+func TestAddMessageKeepsRecent(t *testing.T) {
+	s := New()
+	for i := 0; i < MaxHistory+10; i++ {
+		s.AddMessage(protocol.Message{Type: protocol.MessageTypeText, Content: fmt.Sprint(i)})
+	}
+	
+	messages := s.GetMessages()
+	if len(messages) != MaxHistory {
+		t.Fatalf("Expected %d messages, got %d", MaxHistory, len(messages))
+	}
+	if messages[0].Content != "10" || messages[MaxHistory-1].Content != fmt.Sprint(MaxHistory+9) {
+		t.Errorf("Expected the most recent messages, got %s to %s", messages[0].Content, messages[MaxHistory-1].Content)
+	}
+}
+
 func TestConcurrentAccess(t *testing.T) {
 	s := New()
 	done := make(chan bool)
@@ -130,4 +149,82 @@ func TestConcurrentAccess(t *testing.T) {
 	if len(messages) != 200 {
 		t.Errorf("Expected 200 messages, got %d", len(messages))
 	}
+}
+
+func TestValidateName(t *testing.T) {
+	valid := []string{"alice", "bob_smith", "dev-ops.2", "ñandú"}
+	for _, name := range valid {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%q) unexpected error: %v", name, err)
+		}
+	}
+	
+	invalid := []string{"", "two words", "tab\tname", "bell\a", strings.Repeat("x", MaxNameLength+1)}
+	for _, name := range invalid {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("ValidateName(%q) expected error", name)
+		}
+	}
+}
+
+func TestDefaultName(t *testing.T) {
+	t.Setenv("USER", "carol")
+	if name := DefaultName(); name != "carol" {
+		t.Errorf("Expected $USER as default name, got %s", name)
+	}
+	
+	if s := New(); s.GetName() != "carol" {
+		t.Errorf("New session should use default name, got %s", s.GetName())
+	}
+}
+
+func TestGenerateSessionIDWords(t *testing.T) {
+	id := GenerateSessionIDWords(6)
+	if parts := strings.Split(id, "-"); len(parts) != 7 {
+		t.Errorf("Expected 6 words and a number, got %s", id)
+	}
+	if bits := IDStrength(id); bits < 55 {
+		t.Errorf("Six words should be worth over 55 bits, got %.1f", bits)
+	}
+	
+	if secret := GenerateSecret(); len(secret) != 26 || ValidateSessionID(secret) != nil {
+		t.Errorf("Unexpected secret %q", secret)
+	}
+}
+
+func TestCheckIDStrength(t *testing.T) {
+	tests := []struct {
+		id      string
+		wantErr bool
+	}{
+		{"cosmic-turtle-7823", true},
+		{"password", true},
+		{"our-team-standup", true},
+		{"", true},
+		{"has space", true},
+		{"colon:in-id", true},
+		{"plus+sign", true},
+		{"lunar-falcon-bright-cedar-4821", false},
+		{"Tr0ub4dor-and-3-more-words-here-9", false},
+		{"x7Kp2-qM9vR-w3Lz8", false},
+	}
+	
+	for _, tt := range tests {
+		err := CheckIDStrength(tt.id)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckIDStrength(%q) = %v, wantErr %v (%.1f bits)", tt.id, err, tt.wantErr, IDStrength(tt.id))
+		}
+	}
+}
+
+func TestCredential(t *testing.T) {
+	s := New()
+	if s.Credential() != s.ID {
+		t.Error("Without a secret the credential is the ID")
+	}
+	
+	s.Secret = "abc"
+	if s.Credential() != s.ID+"+abc" {
+		t.Errorf("Unexpected credential %q", s.Credential())
+	}
 }
\ No newline at end of file
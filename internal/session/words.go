@@ -0,0 +1,43 @@
+package session
+
+// Session IDs alternate adjectives and nouns from these lists. Each list has
+// 128 distinct entries, so every word adds 7 bits.
+var (
+	adjectives = []string{
+		"able", "alpha", "amber", "ancient", "arctic", "astral", "autumn", "azure",
+		"bold", "brave", "breezy", "bright", "brisk", "calm", "candid", "cheerful",
+		"clever", "cloudy", "cobalt", "copper", "cosmic", "crimson", "crisp", "curious",
+		"cyber", "daring", "dawn", "deep", "eager", "early", "electric", "emerald",
+		"epic", "fearless", "fierce", "fluffy", "flying", "frosty", "gentle", "ghost",
+		"giant", "gilded", "glad", "golden", "grand", "green", "happy", "hazel",
+		"hidden", "hollow", "humble", "icy", "indigo", "ivory", "jade", "jolly",
+		"keen", "kind", "lively", "lone", "lucky", "lunar", "magic", "marble",
+		"mellow", "merry", "mighty", "misty", "molten", "mystic", "neon", "nimble",
+		"noble", "north", "olive", "omega", "orange", "pale", "patient", "plasma",
+		"polar", "proud", "purple", "quantum", "quick", "quiet", "rapid", "rare",
+		"ready", "red", "rocky", "rosy", "royal", "ruby", "rustic", "salty",
+		"sandy", "scarlet", "secret", "shadow", "shiny", "silent", "silver", "sleepy",
+		"smooth", "snowy", "solar", "sonic", "spicy", "spring", "stellar", "stormy",
+		"sturdy", "sunny", "swift", "tiny", "turbo", "ultra", "upbeat", "velvet",
+		"vivid", "warm", "wild", "windy", "wise", "witty", "young", "zesty",
+	}
+	
+	nouns = []string{
+		"acorn", "anchor", "antler", "apple", "arrow", "aspen", "badger", "bamboo",
+		"banjo", "beacon", "bear", "beetle", "birch", "bison", "bramble", "breeze",
+		"brook", "buffalo", "cactus", "canyon", "cedar", "cello", "cherry", "cloud",
+		"clover", "cobra", "comet", "coral", "coyote", "cricket", "crystal", "cypress",
+		"daisy", "delta", "desert", "dolphin", "dragon", "eagle", "ember", "falcon",
+		"fern", "finch", "fjord", "flame", "forest", "fox", "galaxy", "garden",
+		"gecko", "geyser", "glacier", "harbor", "hawk", "heather", "heron", "hill",
+		"horizon", "iris", "island", "jaguar", "jasmine", "kestrel", "kettle", "koala",
+		"lagoon", "lantern", "lark", "leopard", "lily", "lotus", "lynx", "mango",
+		"maple", "marsh", "meadow", "meteor", "moose", "moth", "nebula", "nova",
+		"oak", "ocean", "orchid", "osprey", "otter", "owl", "panda", "panther",
+		"pebble", "pepper", "phoenix", "pine", "planet", "plum", "pony", "prairie",
+		"puffin", "quail", "quartz", "rabbit", "raven", "reef", "river", "robin",
+		"rocket", "saddle", "salmon", "shark", "sparrow", "spruce", "squid", "summit",
+		"swan", "thistle", "thunder", "tiger", "tulip", "tundra", "turtle", "valley",
+		"violet", "walrus", "whale", "willow", "wolf", "wren", "yak", "zebra",
+	}
+)
\ No newline at end of file
@@ -0,0 +1,89 @@
+package sshserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoadHostKey reads the server's private key from path, creating an ed25519
+// key there the first time so guests see the same host key on every start.
+func LoadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(key, "termchat host key")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save host key: %w", err)
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// AuthorizedKey is a key that may log in, with the comment from its
+// authorized_keys line, which usually names its owner.
+type AuthorizedKey struct {
+	ssh.PublicKey
+	Comment string
+}
+
+// ParseAuthorizedKeys reads keys in authorized_keys format, which is also
+// what https://github.com/<user>.keys serves. Comment lines and lines that
+// are not keys are skipped; options are ignored.
+func ParseAuthorizedKeys(data []byte) ([]AuthorizedKey, error) {
+	var keys []AuthorizedKey
+	for {
+		key, comment, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			break
+		}
+		keys = append(keys, AuthorizedKey{PublicKey: key, Comment: comment})
+		data = rest
+	}
+	
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	return keys, nil
+}
+
+// LoadAuthorizedKeys reads and merges authorized_keys files.
+func LoadAuthorizedKeys(paths ...string) ([]AuthorizedKey, error) {
+	var keys []AuthorizedKey
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		found, err := ParseAuthorizedKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, found...)
+	}
+	return keys, nil
+}
\ No newline at end of file
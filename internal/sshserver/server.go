@@ -0,0 +1,171 @@
+// Package sshserver lets guests join a chat with a plain ssh client. Each
+// guest's session is handed to a Handler that draws the chat on their PTY.
+package sshserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// handshakeTimeout bounds how long a connection may take to authenticate.
+const handshakeTimeout = 10 * time.Second
+
+// Handler runs the chat for one guest. The SSH session ends when it returns.
+type Handler func(term *Terminal)
+
+type Server struct {
+	config     *ssh.ServerConfig
+	authorized []AuthorizedKey
+	handler    Handler
+	listener   net.Listener
+	conns      map[*ssh.ServerConn]bool
+	onReject   func(addr net.Addr, reason string)
+	mu         sync.Mutex
+}
+
+// New returns a server that identifies itself with hostKey and lets in only
+// the holders of the authorized keys.
+func New(hostKey ssh.Signer, authorized []AuthorizedKey, handler Handler) *Server {
+	s := &Server{
+		authorized: authorized,
+		handler:    handler,
+		conns:      make(map[*ssh.ServerConn]bool),
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: s.checkKey,
+		ServerVersion:     "SSH-2.0-termchat",
+	}
+	s.config.AddHostKey(hostKey)
+	return s
+}
+
+// SetRejectCallback is told about connections that fail to authenticate.
+func (s *Server) SetRejectCallback(onReject func(addr net.Addr, reason string)) {
+	s.onReject = onReject
+}
+
+func (s *Server) checkKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	wire := key.Marshal()
+	for _, allowed := range s.authorized {
+		if bytes.Equal(allowed.Marshal(), wire) {
+			return &ssh.Permissions{
+				Extensions: map[string]string{
+					"fingerprint": ssh.FingerprintSHA256(key),
+					"comment":     allowed.Comment,
+				},
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("key %s is not authorized", ssh.FingerprintSHA256(key))
+}
+
+// Listen starts accepting guests on addr, e.g. ":2222".
+func (s *Server) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start SSH server: %w", err)
+	}
+	
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+	
+	go s.acceptConnections(listener)
+	return nil
+}
+
+// Addr is the address guests connect to, or nil before Listen.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Server) acceptConnections(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *Server) handleConnection(raw net.Conn) {
+	raw.SetDeadline(time.Now().Add(handshakeTimeout))
+	conn, chans, reqs, err := ssh.NewServerConn(raw, s.config)
+	if err != nil {
+		raw.Close()
+		s.rejected(raw.RemoteAddr(), err)
+		return
+	}
+	raw.SetDeadline(time.Time{})
+	
+	s.mu.Lock()
+	s.conns[conn] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveSession(conn, channel, requests)
+	}
+}
+
+// rejected reports why a connection failed to log in. Clients that just
+// looked at the host key and left are not worth mentioning.
+func (s *Server) rejected(addr net.Addr, err error) {
+	var authErr *ssh.ServerAuthError
+	switch {
+	case errors.As(err, &authErr) && len(authErr.Errors) > 0:
+		s.reject(addr, authErr.Errors[len(authErr.Errors)-1].Error())
+	case errors.Is(err, io.EOF):
+		// Hung up without trying to log in
+	default:
+		s.reject(addr, err.Error())
+	}
+}
+
+func (s *Server) reject(addr net.Addr, reason string) {
+	if s.onReject != nil {
+		s.onReject(addr, reason)
+	}
+}
+
+// Stop closes the listener and hangs up on every guest.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
\ No newline at end of file
@@ -0,0 +1,212 @@
+package sshserver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func startTestServer(t *testing.T, authorized []AuthorizedKey, handler Handler) *Server {
+	t.Helper()
+	server := New(newTestKey(t), authorized, handler)
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(server.Stop)
+	return server
+}
+
+func dialTestServer(server *Server, user string, key ssh.Signer) (*ssh.Client, error) {
+	return ssh.Dial("tcp", server.Addr().String(), &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func TestServerRejectsUnknownKeys(t *testing.T) {
+	allowed := newTestKey(t)
+	rejected := make(chan string, 1)
+	server := New(newTestKey(t), []AuthorizedKey{{PublicKey: allowed.PublicKey()}}, func(*Terminal) {})
+	server.SetRejectCallback(func(addr net.Addr, reason string) {
+		rejected <- reason
+	})
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer server.Stop()
+	
+	if _, err := dialTestServer(server, "mallory", newTestKey(t)); err == nil {
+		t.Fatal("An unknown key should not get in")
+	}
+	select {
+	case reason := <-rejected:
+		if !strings.Contains(reason, "not authorized") {
+			t.Errorf("Unexpected reason %q", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("The rejection was not reported")
+	}
+	
+	client, err := dialTestServer(server, "alice", allowed)
+	if err != nil {
+		t.Fatalf("An authorized key should get in: %v", err)
+	}
+	client.Close()
+}
+
+func TestServerRunsHandlerOnPty(t *testing.T) {
+	key := newTestKey(t)
+	guests := make(chan *Terminal, 1)
+	server := startTestServer(t, []AuthorizedKey{{PublicKey: key.PublicKey(), Comment: "alice@laptop"}}, func(term *Terminal) {
+		guests <- term
+		io.WriteString(term, "hello "+term.User+"\r\n")
+		
+		// Echo one line back, then hang up
+		buf := make([]byte, 64)
+		n, _ := term.Read(buf)
+		term.Write(buf[:n])
+	})
+	
+	client, err := dialTestServer(server, "alice", key)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	
+	sess, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	if err := sess.RequestPty("xterm-256color", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("RequestPty failed: %v", err)
+	}
+	stdin, _ := sess.StdinPipe()
+	stdout, _ := sess.StdoutPipe()
+	if err := sess.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	
+	term := <-guests
+	if term.User != "alice" || term.Term != "xterm-256color" || term.Fingerprint != ssh.FingerprintSHA256(key.PublicKey()) || term.Comment != "alice@laptop" {
+		t.Errorf("Unexpected terminal %+v", term)
+	}
+	if width, height := term.Size(); width != 80 || height != 24 {
+		t.Errorf("Expected 80x24, got %dx%d", width, height)
+	}
+	
+	resized := make(chan struct{}, 1)
+	term.NotifyResize(func() { resized <- struct{}{} })
+	sess.WindowChange(40, 100)
+	select {
+	case <-resized:
+		if width, height := term.Size(); width != 100 || height != 40 {
+			t.Errorf("Expected 100x40, got %dx%d", width, height)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Window change was not reported")
+	}
+	
+	stdin.Write([]byte("ping"))
+	out, _ := io.ReadAll(stdout)
+	if string(out) != "hello alice\r\nping" {
+		t.Errorf("Unexpected output %q", out)
+	}
+	if err := sess.Wait(); err != nil {
+		t.Errorf("Expected a clean exit, got %v", err)
+	}
+}
+
+func TestServerNeedsPty(t *testing.T) {
+	key := newTestKey(t)
+	server := startTestServer(t, []AuthorizedKey{{PublicKey: key.PublicKey()}}, func(*Terminal) {
+		t.Error("The handler should not run without a terminal")
+	})
+	
+	client, err := dialTestServer(server, "alice", key)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	
+	sess, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	out, err := sess.Output("ls")
+	if err == nil {
+		t.Errorf("Commands should be refused, got %q", out)
+	}
+	
+	sess, _ = client.NewSession()
+	stdout, _ := sess.StdoutPipe()
+	if err := sess.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	out, _ = io.ReadAll(stdout)
+	if !strings.Contains(string(out), "ssh -t") {
+		t.Errorf("Expected a hint about ssh -t, got %q", out)
+	}
+	if err := sess.Wait(); err == nil {
+		t.Error("Expected a non-zero exit status")
+	}
+}
+
+func TestLoadHostKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "host")
+	first, err := LoadHostKey(path)
+	if err != nil {
+		t.Fatalf("LoadHostKey failed: %v", err)
+	}
+	second, err := LoadHostKey(path)
+	if err != nil {
+		t.Fatalf("LoadHostKey failed: %v", err)
+	}
+	if ssh.FingerprintSHA256(first.PublicKey()) != ssh.FingerprintSHA256(second.PublicKey()) {
+		t.Error("The host key should be the same on every start")
+	}
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	alice, bob := newTestKey(t).PublicKey(), newTestKey(t).PublicKey()
+	data := "# team\n" +
+		strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(alice)), "\n") + " alice@laptop\n" +
+		"\nnot a key\n" +
+		`no-pty,command="true" ` + string(ssh.MarshalAuthorizedKey(bob))
+		
+	keys, err := ParseAuthorizedKeys([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKeys failed: %v", err)
+	}
+	if len(keys) != 2 || string(keys[0].Marshal()) != string(alice.Marshal()) || string(keys[1].Marshal()) != string(bob.Marshal()) {
+		t.Fatalf("Expected alice's and bob's keys, got %d keys", len(keys))
+	}
+	if keys[0].Comment != "alice@laptop" || keys[1].Comment != "" {
+		t.Errorf("Expected only alice's key to have a comment, got %q and %q", keys[0].Comment, keys[1].Comment)
+	}
+	
+	if _, err := ParseAuthorizedKeys([]byte("# nobody yet\n")); err == nil {
+		t.Error("A file without keys should be an error")
+	}
+}
\ No newline at end of file
@@ -0,0 +1,124 @@
+package sshserver
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Terminal is a guest's PTY. Reads return their keystrokes and writes go to
+// their screen.
+type Terminal struct {
+	ssh.Channel
+	User        string // the name the guest logged in as, which they choose freely
+	Fingerprint string // SHA256 fingerprint of the key they used
+	Comment     string // from that key's authorized_keys line
+	Term        string // their $TERM
+	
+	mu       sync.Mutex
+	width    int
+	height   int
+	onResize func()
+}
+
+// Size reports the guest's window in columns and rows.
+func (t *Terminal) Size() (width, height int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.width, t.height
+}
+
+// NotifyResize calls cb whenever the guest's window changes size.
+func (t *Terminal) NotifyResize(cb func()) {
+	t.mu.Lock()
+	t.onResize = cb
+	t.mu.Unlock()
+}
+
+func (t *Terminal) resize(width, height uint32) {
+	t.mu.Lock()
+	t.width, t.height = int(width), int(height)
+	cb := t.onResize
+	t.mu.Unlock()
+	
+	if cb != nil {
+		cb()
+	}
+}
+
+// RFC 4254 section 6.2
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+// RFC 4254 section 6.7
+type windowChange struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+// serveSession waits for the guest's PTY and shell requests and then runs the
+// handler. Commands and subsystems are refused; the chat is all there is.
+func (s *Server) serveSession(conn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request) {
+	term := &Terminal{
+		Channel:     channel,
+		User:        conn.User(),
+		Fingerprint: conn.Permissions.Extensions["fingerprint"],
+		Comment:     conn.Permissions.Extensions["comment"],
+	}
+	hasPty, started := false, false
+	
+	for req := range requests {
+		ok := false
+		switch req.Type {
+		case "pty-req":
+			var pty ptyRequest
+			if err := ssh.Unmarshal(req.Payload, &pty); err == nil && !started {
+				term.Term = pty.Term
+				term.resize(pty.Columns, pty.Rows)
+				hasPty, ok = true, true
+			}
+		case "window-change":
+			var change windowChange
+			if err := ssh.Unmarshal(req.Payload, &change); err == nil {
+				term.resize(change.Columns, change.Rows)
+			}
+			continue // never wants a reply
+		case "env":
+			// Accepted so clients don't warn, but nothing reads them
+			ok = true
+		case "shell":
+			if started {
+				break
+			}
+			started, ok = true, true
+			if !hasPty {
+				req.Reply(true, nil)
+				channel.Write([]byte("termchat needs a terminal, try ssh -t\r\n"))
+				exit(channel, 1)
+				continue
+			}
+			go func() {
+				s.handler(term)
+				exit(channel, 0)
+			}()
+		}
+		
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+	}
+	channel.Close()
+}
+
+func exit(channel ssh.Channel, status uint32) {
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	channel.Close()
+}
\ No newline at end of file
@@ -0,0 +1,301 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+// maxNameLength keeps saved file names within what file systems allow.
+const maxNameLength = 200
+
+// incoming receives one accepted file into a partial file next to where it
+// will be saved.
+type incoming struct {
+	t         Transfer
+	ref       string
+	info      protocol.FileInfo
+	part      string
+	file      *os.File
+	received  int64
+	acked     int64 // what we last told the sender we have
+	verifying bool
+	reported  time.Time
+}
+
+// Accept takes up an offer, by its number or, if only one is waiting, with
+// an empty arg. A partial download of the same file is picked up where it
+// left off.
+func (m *Manager) Accept(arg string) (Offer, error) {
+	o, err := m.pick(arg)
+	if err != nil {
+		return Offer{}, err
+	}
+	
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		m.cancel(o.ref, o.From, "the receiver cannot save files")
+		return *o, err
+	}
+	part := filepath.Join(m.dir, fmt.Sprintf("%s.%s.part", o.Name, o.info.SHA256[:12]))
+	// Private until it is complete, and never through a link someone
+	// planted in its place
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		m.cancel(o.ref, o.From, "the receiver cannot save files")
+		return *o, err
+	}
+	stat, err := f.Stat()
+	if err == nil && !stat.Mode().IsRegular() {
+		err = fmt.Errorf("%s is not a regular file", part)
+	}
+	if err == nil {
+		err = f.Chmod(0600)
+	}
+	if err != nil {
+		f.Close()
+		m.cancel(o.ref, o.From, "the receiver cannot save files")
+		return *o, err
+	}
+	
+	have := int64(0)
+	if stat.Size() <= o.Size {
+		have = stat.Size()
+	} else if err := f.Truncate(0); err != nil {
+		f.Close()
+		m.cancel(o.ref, o.From, "the receiver cannot save files")
+		return *o, err
+	}
+	
+	key := peerRef{o.ref, o.From}
+	in := &incoming{
+		t: Transfer{
+			Key:  o.ref + "/" + o.From,
+			Name: o.Name,
+			Peer: o.From,
+			Size: o.Size,
+			Done: have,
+		},
+		ref:      o.ref,
+		info:     o.info,
+		part:     part,
+		file:     f,
+		received: have,
+		acked:    have,
+	}
+	
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		f.Close()
+		return *o, errors.New("stopped")
+	}
+	m.incoming[key] = in
+	request := in.request()
+	report := m.progress(in.t, &in.reported, true)
+	// If everything is already here the sender only needs to hear so
+	complete := have == o.Size
+	in.verifying = complete
+	m.mu.Unlock()
+	
+	report()
+	if err := m.send(request); err != nil {
+		m.mu.Lock()
+		report := m.finishIncoming(key, in, err)
+		m.mu.Unlock()
+		report()
+		return *o, err
+	}
+	if complete {
+		go m.verify(key, in)
+	}
+	return *o, nil
+}
+
+// request asks the sender to send from where we are. m.mu must be held.
+func (in *incoming) request() *protocol.Message {
+	msg := protocol.NewMessage(protocol.MessageTypeFileAccept, "")
+	msg.Ref = in.ref
+	msg.To = in.t.Peer
+	msg.Offset = in.received
+	return msg
+}
+
+// chunk writes file data that carries on exactly where we are. Anything
+// else is a copy sent before the sender heard where to resume from.
+func (m *Manager) chunk(msg protocol.Message) {
+	key := peerRef{msg.Ref, msg.From}
+	
+	m.mu.Lock()
+	in := m.incoming[key]
+	if in == nil || in.verifying || msg.Offset != in.received || len(msg.Data) == 0 {
+		m.mu.Unlock()
+		return
+	}
+	if in.received+int64(len(msg.Data)) > in.t.Size {
+		report := m.finishIncoming(key, in, errors.New("the sender sent more than it offered"))
+		m.mu.Unlock()
+		m.cancel(key.ref, key.peer, "more data than offered")
+		report()
+		return
+	}
+	if _, err := in.file.WriteAt(msg.Data, msg.Offset); err != nil {
+		report := m.finishIncoming(key, in, err)
+		m.mu.Unlock()
+		m.cancel(key.ref, key.peer, "the receiver cannot save files")
+		report()
+		return
+	}
+	in.received += int64(len(msg.Data))
+	in.t.Done = in.received
+	
+	var ack *protocol.Message
+	complete := in.received == in.t.Size
+	if complete {
+		in.verifying = true
+	} else if in.received-in.acked >= ackEvery {
+		in.acked = in.received
+		ack = protocol.NewMessage(protocol.MessageTypeFileAck, "")
+		ack.Ref = in.ref
+		ack.To = key.peer
+		ack.Offset = in.received
+	}
+	report := m.progress(in.t, &in.reported, complete)
+	m.mu.Unlock()
+	
+	report()
+	if ack != nil {
+		m.send(ack)
+	}
+	if complete {
+		// Hashing a large file would hold up the chat
+		go m.verify(key, in)
+	}
+}
+
+// verify checks a complete download against the offered SHA-256 and, if it
+// matches, moves it to its final name.
+func (m *Manager) verify(key peerRef, in *incoming) {
+	in.file.Close()
+	var path string
+	err := checkSum(in.part, in.info.SHA256)
+	if err == nil {
+		path, err = keep(in.part, filepath.Join(m.dir, in.t.Name))
+	}
+	
+	if err != nil {
+		os.Remove(in.part)
+		m.cancel(key.ref, key.peer, "the file did not arrive intact")
+	} else {
+		msg := protocol.NewMessage(protocol.MessageTypeFileComplete, "")
+		msg.Ref = key.ref
+		msg.To = key.peer
+		m.send(msg)
+	}
+	
+	m.mu.Lock()
+	in.t.Path = path
+	// Follow any rename while we were hashing
+	for k, v := range m.incoming {
+		if v == in {
+			key = k
+		}
+	}
+	report := m.finishIncoming(key, in, err)
+	m.mu.Unlock()
+	report()
+}
+
+func checkSum(path, want string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != want {
+		return errors.New("checksum mismatch")
+	}
+	return nil
+}
+
+// keep renames a finished download to dest, or to "name (2).ext" and so on
+// if that is taken, and returns where it ended up.
+func keep(part, dest string) (string, error) {
+	// Readable like any other saved file now that it is whole
+	if err := os.Chmod(part, 0644); err != nil {
+		return "", err
+	}
+	
+	ext := filepath.Ext(dest)
+	base := strings.TrimSuffix(dest, ext)
+	for i := 1; i < 1000; i++ {
+		path := dest
+		if i > 1 {
+			path = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		// A hard link fails rather than replace a file that appeared since
+		err := os.Link(part, path)
+		if err == nil {
+			os.Remove(part)
+			return path, nil
+		}
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		// Some file systems have no hard links
+		if _, statErr := os.Lstat(path); os.IsNotExist(statErr) {
+			return path, os.Rename(part, path)
+		}
+	}
+	return "", fmt.Errorf("too many files named %s", filepath.Base(dest))
+}
+
+// finishIncoming stops receiving from one peer, keeping whatever arrived for
+// a later offer to resume. m.mu must be held; the returned function reports
+// the outcome once it is released.
+func (m *Manager) finishIncoming(key peerRef, in *incoming, err error) func() {
+	if m.incoming[key] != in {
+		return func() {}
+	}
+	delete(m.incoming, key)
+	in.file.Close()
+	return m.done(in.t, err)
+}
+
+// safeName turns an offered name into a plain file name in the download
+// directory: no directories, control characters or dot names.
+func safeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(name, ".")
+	if len(name) > maxNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		name = name[:maxNameLength-len(ext)] + ext
+		name = strings.ToValidUTF8(name, "")
+	}
+	if name == "" {
+		name = "file"
+	}
+	return name
+}
\ No newline at end of file
@@ -0,0 +1,248 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+// offered is a file we offered, which anyone may accept until we stop.
+type offered struct {
+	path string
+	info protocol.FileInfo
+}
+
+// outgoing sends one offered file to one peer.
+type outgoing struct {
+	t        Transfer
+	ref      string
+	file     *os.File
+	next     int64 // next byte to send
+	acked    int64 // bytes the receiver has confirmed
+	wake     chan struct{}
+	done     chan struct{}
+	finished bool
+	reported time.Time
+}
+
+// Send offers the file at path to everyone in the session. It returns once
+// the offer is out; each peer who accepts is sent the file in the
+// background.
+func (m *Manager) Send(path string) (protocol.FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return protocol.FileInfo{}, err
+	}
+	defer f.Close()
+	
+	stat, err := f.Stat()
+	if err != nil {
+		return protocol.FileInfo{}, err
+	}
+	if !stat.Mode().IsRegular() {
+		return protocol.FileInfo{}, fmt.Errorf("%s is not a regular file", path)
+	}
+	
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return protocol.FileInfo{}, err
+	}
+	info := protocol.FileInfo{
+		Name:   filepath.Base(path),
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}
+	
+	msg := protocol.NewMessage(protocol.MessageTypeFileOffer, "")
+	msg.File = &info
+	m.mu.Lock()
+	m.offered[msg.ID] = &offered{path: path, info: info}
+	m.mu.Unlock()
+	
+	if err := m.send(msg); err != nil {
+		m.mu.Lock()
+		delete(m.offered, msg.ID)
+		m.mu.Unlock()
+		return protocol.FileInfo{}, err
+	}
+	return info, nil
+}
+
+// accepted starts sending, or rewinds to where the receiver asks, when a
+// FILE_ACCEPT arrives.
+func (m *Manager) accepted(msg protocol.Message) {
+	key := peerRef{msg.Ref, msg.From}
+	
+	m.mu.Lock()
+	if out := m.outgoing[key]; out != nil {
+		if msg.Offset >= 0 && msg.Offset <= out.t.Size {
+			out.next = msg.Offset
+			out.acked = msg.Offset
+			out.signal()
+		}
+		m.mu.Unlock()
+		return
+	}
+	
+	offer := m.offered[msg.Ref]
+	if offer == nil || m.stopped || msg.Offset < 0 || msg.Offset > offer.info.Size {
+		m.mu.Unlock()
+		m.cancel(msg.Ref, msg.From, "no such file offer")
+		return
+	}
+	
+	f, err := os.Open(offer.path)
+	if err != nil {
+		m.mu.Unlock()
+		m.cancel(msg.Ref, msg.From, "the file is no longer available")
+		return
+	}
+	out := &outgoing{
+		t: Transfer{
+			Key:      msg.Ref + "/" + msg.From,
+			Name:     offer.info.Name,
+			Peer:     msg.From,
+			Size:     offer.info.Size,
+			Done:     msg.Offset,
+			Outgoing: true,
+		},
+		ref:   msg.Ref,
+		file:  f,
+		next:  msg.Offset,
+		acked: msg.Offset,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	m.outgoing[key] = out
+	report := m.progress(out.t, &out.reported, true)
+	m.mu.Unlock()
+	
+	report()
+	go m.push(out)
+}
+
+// push sends chunks while the receiver's window allows, until the transfer
+// finishes one way or another.
+func (m *Manager) push(out *outgoing) {
+	buf := make([]byte, ChunkSize)
+	for {
+		m.mu.Lock()
+		if out.finished {
+			m.mu.Unlock()
+			return
+		}
+		next, peer := out.next, out.t.Peer
+		if next >= out.t.Size || next >= out.acked+window {
+			m.mu.Unlock()
+			select {
+			case <-out.wake:
+			case <-out.done:
+			}
+			continue
+		}
+		m.mu.Unlock()
+		
+		n, err := out.file.ReadAt(buf, next)
+		if n == 0 {
+			if err == nil || err == io.EOF {
+				err = fmt.Errorf("%s got shorter while sending", out.t.Name)
+			}
+			m.abort(out, err)
+			return
+		}
+		
+		chunk := protocol.NewMessage(protocol.MessageTypeFileChunk, "")
+		chunk.Ref = out.ref
+		chunk.To = peer
+		chunk.Offset = next
+		chunk.Data = buf[:n]
+		if err := m.send(chunk); err != nil {
+			m.abort(out, err)
+			return
+		}
+		
+		m.mu.Lock()
+		// Unless the receiver asked to rewind in the meantime
+		if out.next == next {
+			out.next = next + int64(n)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// acked moves the window on when the receiver confirms progress.
+func (m *Manager) acked(msg protocol.Message) {
+	m.mu.Lock()
+	out := m.outgoing[peerRef{msg.Ref, msg.From}]
+	if out == nil || msg.Offset <= out.acked || msg.Offset > out.t.Size {
+		m.mu.Unlock()
+		return
+	}
+	out.acked = msg.Offset
+	if out.next < out.acked {
+		out.next = out.acked
+	}
+	out.t.Done = out.acked
+	out.signal()
+	report := m.progress(out.t, &out.reported, false)
+	m.mu.Unlock()
+	
+	report()
+}
+
+// completed finishes a transfer the receiver has verified.
+func (m *Manager) completed(msg protocol.Message) {
+	key := peerRef{msg.Ref, msg.From}
+	
+	m.mu.Lock()
+	out := m.outgoing[key]
+	if out == nil {
+		m.mu.Unlock()
+		return
+	}
+	out.t.Done = out.t.Size
+	report := m.finishOutgoing(key, out, nil)
+	m.mu.Unlock()
+	
+	report()
+}
+
+// abort gives up sending after a local failure and tells the receiver.
+func (m *Manager) abort(out *outgoing, err error) {
+	m.mu.Lock()
+	key := peerRef{out.ref, out.t.Peer}
+	if out.finished || m.outgoing[key] != out {
+		m.mu.Unlock()
+		return
+	}
+	report := m.finishOutgoing(key, out, err)
+	m.mu.Unlock()
+	
+	m.cancel(key.ref, key.peer, "the sender hit an error")
+	report()
+}
+
+// finishOutgoing stops sending to one peer. m.mu must be held; the returned
+// function reports the outcome once it is released.
+func (m *Manager) finishOutgoing(key peerRef, out *outgoing, err error) func() {
+	delete(m.outgoing, key)
+	out.finished = true
+	close(out.done)
+	out.file.Close()
+	return m.done(out.t, err)
+}
+
+// signal wakes push without blocking.
+func (out *outgoing) signal() {
+	select {
+	case out.wake <- struct{}{}:
+	default:
+	}
+}
\ No newline at end of file
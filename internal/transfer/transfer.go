@@ -0,0 +1,393 @@
+// Package transfer sends files between participants over the chat
+// connection. The sender offers a file to everyone; each participant who
+// accepts gets it in chunks, paced by their acknowledgements so chat keeps
+// flowing alongside, and checks it against the offered SHA-256 before
+// keeping it.
+//
+// Chunks are not replayed after a dropped connection. Instead the receiver
+// asks again from where it got to once the link is back, and a partial
+// download stays on disk so a later offer of the same file picks up there.
+package transfer
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+const (
+	// ChunkSize is how much file data goes in one FILE_CHUNK.
+	ChunkSize = 16 << 10
+	
+	// window is how far the sender may run ahead of the receiver's last
+	// acknowledgement, which bounds how long chat waits behind file data.
+	window = 8 * ChunkSize
+	
+	// ackEvery is how often the receiver acknowledges, well inside the window
+	// so the sender never stalls on a healthy link.
+	ackEvery = window / 2
+	
+	// progressEvery limits how often progress is reported.
+	progressEvery = 100 * time.Millisecond
+)
+
+// Transfer is a snapshot of one file on its way to or from one peer.
+type Transfer struct {
+	Key      string // unique for the file and peer
+	Name     string
+	Peer     string
+	Size     int64
+	Done     int64 // bytes the receiver has confirmed
+	Outgoing bool
+	Path     string // where a received file was saved, once it is complete
+}
+
+// Offer is a file someone offered us that we have not yet accepted or
+// declined. Number is what the user types to pick it.
+type Offer struct {
+	Number int
+	From   string
+	Name   string
+	Size   int64
+	
+	ref  string
+	info protocol.FileInfo
+}
+
+// Manager runs all of one participant's transfers.
+type Manager struct {
+	send func(*protocol.Message) error
+	dir  string
+	
+	mu       sync.Mutex
+	offered  map[string]*offered // our own offers, by message ID
+	outgoing map[peerRef]*outgoing
+	incoming map[peerRef]*incoming
+	offers   map[int]*Offer
+	next     int
+	stopped  bool
+	
+	onOffer    func(Offer)
+	onProgress func(Transfer)
+	onDone     func(Transfer, error)
+}
+
+// peerRef names one peer's part in a transfer: the offer's message ID and
+// the peer's display name.
+type peerRef struct {
+	ref  string
+	peer string
+}
+
+// NewManager sends through send, normally the Server's or Client's
+// SendMessage, and saves accepted files in dir.
+func NewManager(send func(*protocol.Message) error, dir string) *Manager {
+	return &Manager{
+		send:     send,
+		dir:      dir,
+		offered:  make(map[string]*offered),
+		outgoing: make(map[peerRef]*outgoing),
+		incoming: make(map[peerRef]*incoming),
+		offers:   make(map[int]*Offer),
+	}
+}
+
+// SetCallbacks registers who hears about new offers, progress and finished
+// transfers; err is nil for a file that arrived intact. They are called on
+// the network's goroutines and must not block.
+func (m *Manager) SetCallbacks(onOffer func(Offer), onProgress func(Transfer), onDone func(Transfer, error)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onOffer = onOffer
+	m.onProgress = onProgress
+	m.onDone = onDone
+}
+
+// Dir is where accepted files are saved.
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Handle takes an incoming message and reports whether it was part of a
+// file transfer. It also watches LEFT, BACK and NICK, which end, resume and
+// rename transfers, but leaves those for the caller to show.
+func (m *Manager) Handle(msg protocol.Message) bool {
+	switch msg.Type {
+	case protocol.MessageTypeFileOffer:
+		m.received(msg)
+	case protocol.MessageTypeFileAccept:
+		m.accepted(msg)
+	case protocol.MessageTypeFileChunk:
+		m.chunk(msg)
+	case protocol.MessageTypeFileAck:
+		m.acked(msg)
+	case protocol.MessageTypeFileComplete:
+		m.completed(msg)
+	case protocol.MessageTypeFileCancel:
+		m.cancelled(msg)
+	case protocol.MessageTypeLeft:
+		m.PeerLeft(msg.Content)
+		return false
+	case protocol.MessageTypeBack:
+		m.Resume(msg.Content)
+		return false
+	case protocol.MessageTypeNick:
+		m.rename(msg.From, msg.Content)
+		return false
+	default:
+		return false
+	}
+	return true
+}
+
+// received records an offer from someone else.
+func (m *Manager) received(msg protocol.Message) {
+	if msg.From == "" || msg.ID == "" || validInfo(msg.File) != nil {
+		return
+	}
+	
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return
+	}
+	m.next++
+	o := &Offer{
+		Number: m.next,
+		From:   msg.From,
+		Name:   safeName(msg.File.Name),
+		Size:   msg.File.Size,
+		ref:    msg.ID,
+		info:   *msg.File,
+	}
+	m.offers[o.Number] = o
+	onOffer := m.onOffer
+	m.mu.Unlock()
+	
+	if onOffer != nil {
+		onOffer(*o)
+	}
+}
+
+func validInfo(info *protocol.FileInfo) error {
+	if info == nil {
+		return errors.New("no file described")
+	}
+	if info.Size < 0 {
+		return errors.New("negative size")
+	}
+	if sum, err := hex.DecodeString(info.SHA256); err != nil || len(sum) != 32 {
+		return errors.New("invalid SHA-256")
+	}
+	return nil
+}
+
+// pick finds the offer the user means: by number, or the only one if they
+// gave none. It is removed from the pending offers.
+func (m *Manager) pick(arg string) (*Offer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	if arg == "" {
+		switch len(m.offers) {
+		case 0:
+			return nil, errors.New("nobody has offered you a file")
+		case 1:
+			for n := range m.offers {
+				arg = strconv.Itoa(n)
+			}
+		default:
+			numbers := make([]int, 0, len(m.offers))
+			for n := range m.offers {
+				numbers = append(numbers, n)
+			}
+			sort.Ints(numbers)
+			return nil, fmt.Errorf("several files are on offer, say which: %v", numbers)
+		}
+	}
+	
+	n, err := strconv.Atoi(arg)
+	if err != nil || m.offers[n] == nil {
+		return nil, fmt.Errorf("no file offer %s", arg)
+	}
+	o := m.offers[n]
+	delete(m.offers, n)
+	return o, nil
+}
+
+// Decline refuses an offer, picked as for Accept.
+func (m *Manager) Decline(arg string) (Offer, error) {
+	o, err := m.pick(arg)
+	if err != nil {
+		return Offer{}, err
+	}
+	m.cancel(o.ref, o.From, "declined")
+	return *o, nil
+}
+
+// Resume asks peer to carry on sending from where each of its files got to,
+// after either side's connection came back. An empty peer means everyone,
+// for when our own connection dropped.
+func (m *Manager) Resume(peer string) {
+	m.mu.Lock()
+	var resumed []*incoming
+	for key, in := range m.incoming {
+		if (peer == "" || key.peer == peer) && !in.verifying {
+			in.acked = in.received
+			resumed = append(resumed, in)
+		}
+	}
+	m.mu.Unlock()
+	
+	for _, in := range resumed {
+		m.mu.Lock()
+		msg := in.request()
+		m.mu.Unlock()
+		m.send(msg)
+	}
+}
+
+// PeerLeft ends every transfer with someone who left. Partial downloads
+// stay on disk for a later offer to resume. Handle calls it for LEFT; the
+// host, which hears of departures another way, calls it directly.
+func (m *Manager) PeerLeft(peer string) {
+	m.mu.Lock()
+	var ended []func()
+	for key, out := range m.outgoing {
+		if key.peer == peer {
+			ended = append(ended, m.finishOutgoing(key, out, fmt.Errorf("%s left", peer)))
+		}
+	}
+	for key, in := range m.incoming {
+		if key.peer == peer && !in.verifying {
+			ended = append(ended, m.finishIncoming(key, in, fmt.Errorf("%s left", peer)))
+		}
+	}
+	for n, o := range m.offers {
+		if o.From == peer {
+			delete(m.offers, n)
+		}
+	}
+	m.mu.Unlock()
+	
+	for _, report := range ended {
+		report()
+	}
+}
+
+// rename follows a peer's new display name, which is how messages to them
+// are addressed.
+func (m *Manager) rename(old, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	for key, out := range m.outgoing {
+		if key.peer == old {
+			delete(m.outgoing, key)
+			out.t.Peer = name
+			m.outgoing[peerRef{key.ref, name}] = out
+		}
+	}
+	for key, in := range m.incoming {
+		if key.peer == old {
+			delete(m.incoming, key)
+			in.t.Peer = name
+			m.incoming[peerRef{key.ref, name}] = in
+		}
+	}
+	for _, o := range m.offers {
+		if o.From == old {
+			o.From = name
+		}
+	}
+}
+
+// Stop abandons every transfer, e.g. when we leave the session.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	m.stopped = true
+	var ended []func()
+	for key, out := range m.outgoing {
+		ended = append(ended, m.finishOutgoing(key, out, errors.New("stopped")))
+	}
+	for key, in := range m.incoming {
+		ended = append(ended, m.finishIncoming(key, in, errors.New("stopped")))
+	}
+	m.offers = make(map[int]*Offer)
+	m.mu.Unlock()
+	
+	for _, report := range ended {
+		report()
+	}
+}
+
+// cancelled handles a FILE_CANCEL: the peer declined or gave up.
+func (m *Manager) cancelled(msg protocol.Message) {
+	key := peerRef{msg.Ref, msg.From}
+	err := errors.New(msg.Content)
+	if msg.Content == "" {
+		err = errors.New("cancelled")
+	}
+	
+	m.mu.Lock()
+	var report func()
+	if out := m.outgoing[key]; out != nil {
+		report = m.finishOutgoing(key, out, err)
+	} else if in := m.incoming[key]; in != nil && !in.verifying {
+		report = m.finishIncoming(key, in, err)
+	} else if offer := m.offered[msg.Ref]; offer != nil {
+		// Declined before anything was sent
+		report = m.done(Transfer{
+			Key:      key.ref + "/" + key.peer,
+			Name:     offer.info.Name,
+			Peer:     key.peer,
+			Size:     offer.info.Size,
+			Outgoing: true,
+		}, err)
+	}
+	for n, o := range m.offers {
+		if o.ref == msg.Ref && o.From == msg.From {
+			delete(m.offers, n)
+		}
+	}
+	m.mu.Unlock()
+	
+	if report != nil {
+		report()
+	}
+}
+
+// cancel tells peer we are giving up on a transfer.
+func (m *Manager) cancel(ref, peer, reason string) {
+	msg := protocol.NewMessage(protocol.MessageTypeFileCancel, reason)
+	msg.Ref = ref
+	msg.To = peer
+	m.send(msg)
+}
+
+// progress reports t unless it was reported very recently. m.mu must be
+// held; the returned function makes the call once it is released.
+func (m *Manager) progress(t Transfer, last *time.Time, force bool) func() {
+	if m.onProgress == nil || (!force && time.Since(*last) < progressEvery) {
+		return func() {}
+	}
+	*last = time.Now()
+	onProgress := m.onProgress
+	return func() { onProgress(t) }
+}
+
+// done reports a finished transfer. m.mu must be held; the returned function
+// makes the call once it is released.
+func (m *Manager) done(t Transfer, err error) func() {
+	onDone := m.onDone
+	if onDone == nil {
+		return func() {}
+	}
+	return func() { onDone(t, err) }
+}
\ No newline at end of file
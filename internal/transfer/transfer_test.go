@@ -0,0 +1,345 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+// testLink connects managers by name the way the host routes between
+// participants, with a JSON round trip on the way.
+type testLink struct {
+	mu       sync.Mutex
+	managers map[string]*Manager
+	lose     func(msg *protocol.Message) bool // drops a chunk, as a lost link would
+	chunked  int64                            // chunk bytes delivered
+}
+
+type testPeer struct {
+	*Manager
+	offers chan Offer
+	done   chan result
+}
+
+type result struct {
+	t   Transfer
+	err error
+}
+
+func newTestLink() *testLink {
+	return &testLink{managers: make(map[string]*Manager)}
+}
+
+func (l *testLink) join(t *testing.T, name string) *testPeer {
+	t.Helper()
+	p := &testPeer{offers: make(chan Offer, 4), done: make(chan result, 4)}
+	p.Manager = NewManager(func(msg *protocol.Message) error {
+		return l.deliver(name, msg)
+	}, t.TempDir())
+	p.SetCallbacks(
+		func(o Offer) { p.offers <- o },
+		nil,
+		func(t Transfer, err error) { p.done <- result{t, err} },
+	)
+	t.Cleanup(p.Stop)
+	
+	l.mu.Lock()
+	l.managers[name] = p.Manager
+	l.mu.Unlock()
+	return p
+}
+
+func (l *testLink) deliver(from string, msg *protocol.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	var out protocol.Message
+	json.Unmarshal(data, &out)
+	out.From = from
+	
+	l.mu.Lock()
+	var targets []*Manager
+	for name, m := range l.managers {
+		if name != from && (out.To == "" || out.To == name) {
+			targets = append(targets, m)
+		}
+	}
+	if out.Type == protocol.MessageTypeFileChunk {
+		if l.lose != nil && l.lose(&out) {
+			l.mu.Unlock()
+			return nil
+		}
+		l.chunked += int64(len(out.Data))
+	}
+	l.mu.Unlock()
+	
+	for _, m := range targets {
+		m.Handle(out)
+	}
+	return nil
+}
+
+func writeTestFile(t *testing.T, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), "report.log")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+func waitOffer(t *testing.T, p *testPeer) Offer {
+	t.Helper()
+	select {
+	case o := <-p.offers:
+		return o
+	case <-time.After(2 * time.Second):
+		t.Fatal("No offer arrived")
+		return Offer{}
+	}
+}
+
+func waitDone(t *testing.T, p *testPeer) result {
+	t.Helper()
+	select {
+	case r := <-p.done:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("Transfer did not finish")
+		return result{}
+	}
+}
+
+func TestSendFile(t *testing.T) {
+	link := newTestLink()
+	alice := link.join(t, "alice")
+	bob := link.join(t, "bob")
+	
+	path, data := writeTestFile(t, 5*window+123)
+	if _, err := alice.Send(path); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	
+	offer := waitOffer(t, bob)
+	if offer.From != "alice" || offer.Name != "report.log" || offer.Size != int64(len(data)) {
+		t.Fatalf("Unexpected offer %+v", offer)
+	}
+	if _, err := bob.Accept(""); err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	
+	got := waitDone(t, bob)
+	if got.err != nil {
+		t.Fatalf("Receiving failed: %v", got.err)
+	}
+	if got.t.Path != filepath.Join(bob.Dir(), "report.log") {
+		t.Errorf("Saved to %s", got.t.Path)
+	}
+	if saved, _ := os.ReadFile(got.t.Path); !bytes.Equal(saved, data) {
+		t.Error("Saved file differs from the original")
+	}
+	if info, err := os.Stat(got.t.Path); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("Expected the saved file to be readable like any other, got %v (%v)", info.Mode(), err)
+	}
+	if sent := waitDone(t, alice); sent.err != nil || sent.t.Peer != "bob" || !sent.t.Outgoing {
+		t.Errorf("Sender saw %+v (%v)", sent.t, sent.err)
+	}
+	
+	// A second copy does not replace the first
+	alice.Send(path)
+	waitOffer(t, bob)
+	bob.Accept("")
+	if got := waitDone(t, bob); got.t.Path != filepath.Join(bob.Dir(), "report (2).log") {
+		t.Errorf("Second copy saved to %s (%v)", got.t.Path, got.err)
+	}
+}
+
+func TestDeclineFile(t *testing.T) {
+	link := newTestLink()
+	alice := link.join(t, "alice")
+	bob := link.join(t, "bob")
+	
+	path, _ := writeTestFile(t, 100)
+	alice.Send(path)
+	waitOffer(t, bob)
+	
+	if _, err := bob.Decline("7"); err == nil {
+		t.Error("Declining an unknown offer should fail")
+	}
+	if _, err := bob.Decline(""); err != nil {
+		t.Fatalf("Decline failed: %v", err)
+	}
+	if got := waitDone(t, alice); got.err == nil || !strings.Contains(got.err.Error(), "declined") {
+		t.Errorf("Expected the sender to hear it was declined, got %v", got.err)
+	}
+	if _, err := bob.Accept(""); err == nil {
+		t.Error("A declined offer cannot be accepted")
+	}
+}
+
+func TestResumeAfterLostChunk(t *testing.T) {
+	link := newTestLink()
+	alice := link.join(t, "alice")
+	bob := link.join(t, "bob")
+	
+	// Lose one chunk, as if bob's link dropped while it was on its way
+	lost := false
+	link.lose = func(msg *protocol.Message) bool {
+		if msg.Offset == 2*ChunkSize && !lost {
+			lost = true
+			return true
+		}
+		return false
+	}
+	
+	path, data := writeTestFile(t, 4*window)
+	alice.Send(path)
+	waitOffer(t, bob)
+	bob.Accept("")
+	
+	select {
+	case r := <-bob.done:
+		t.Fatalf("Finished despite the gap: %v", r.err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	
+	// Bob is back and asks for the rest
+	bob.Resume("alice")
+	got := waitDone(t, bob)
+	if got.err != nil {
+		t.Fatalf("Receiving failed: %v", got.err)
+	}
+	if saved, _ := os.ReadFile(got.t.Path); !bytes.Equal(saved, data) {
+		t.Error("Saved file differs from the original")
+	}
+}
+
+func TestResumePartialDownload(t *testing.T) {
+	link := newTestLink()
+	alice := link.join(t, "alice")
+	bob := link.join(t, "bob")
+	
+	path, data := writeTestFile(t, 3*window)
+	info, _ := alice.Send(path)
+	waitOffer(t, bob)
+	
+	// Half of it arrived in an earlier session
+	part := filepath.Join(bob.Dir(), "report.log."+info.SHA256[:12]+".part")
+	os.WriteFile(part, data[:len(data)/2], 0644)
+	
+	bob.Accept("")
+	got := waitDone(t, bob)
+	if got.err != nil {
+		t.Fatalf("Receiving failed: %v", got.err)
+	}
+	if saved, _ := os.ReadFile(got.t.Path); !bytes.Equal(saved, data) {
+		t.Error("Saved file differs from the original")
+	}
+	if link.chunked != int64(len(data)/2) {
+		t.Errorf("Expected only the missing %d bytes to be sent, got %d", len(data)/2, link.chunked)
+	}
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Error("The partial file should be gone")
+	}
+}
+
+func TestPartialFileNotFollowedThroughLink(t *testing.T) {
+	link := newTestLink()
+	alice := link.join(t, "alice")
+	bob := link.join(t, "bob")
+	
+	path, _ := writeTestFile(t, 1000)
+	info, _ := alice.Send(path)
+	waitOffer(t, bob)
+	
+	// Another user planted a link where the partial file goes
+	victim := filepath.Join(t.TempDir(), "victim")
+	os.WriteFile(victim, []byte("keep me"), 0600)
+	part := filepath.Join(bob.Dir(), "report.log."+info.SHA256[:12]+".part")
+	if err := os.Symlink(victim, part); err != nil {
+		t.Fatal(err)
+	}
+	
+	if _, err := bob.Accept(""); err == nil {
+		t.Error("Expected the download to refuse a linked partial file")
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "keep me" {
+		t.Errorf("The link was followed, victim now holds %q", data)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	link := newTestLink()
+	alice := link.join(t, "alice")
+	bob := link.join(t, "bob")
+	
+	path, data := writeTestFile(t, 1000)
+	alice.Send(path)
+	waitOffer(t, bob)
+	
+	// The file changes after it was offered
+	data[500] ^= 0xff
+	os.WriteFile(path, data, 0644)
+	
+	bob.Accept("")
+	if got := waitDone(t, bob); got.err == nil {
+		t.Error("A corrupted file should be rejected")
+	}
+	if got := waitDone(t, alice); got.err == nil || !strings.Contains(got.err.Error(), "intact") {
+		t.Errorf("Expected the sender to hear the file was damaged, got %v", got.err)
+	}
+	if entries, _ := os.ReadDir(bob.Dir()); len(entries) != 0 {
+		t.Errorf("Nothing should be kept, found %d files", len(entries))
+	}
+}
+
+func TestPeerLeftEndsTransfers(t *testing.T) {
+	link := newTestLink()
+	alice := link.join(t, "alice")
+	bob := link.join(t, "bob")
+	link.lose = func(*protocol.Message) bool { return true }
+	
+	path, _ := writeTestFile(t, 2*window)
+	alice.Send(path)
+	waitOffer(t, bob)
+	bob.Accept("")
+	
+	alice.Handle(protocol.Message{Type: protocol.MessageTypeLeft, Content: "bob"})
+	if got := waitDone(t, alice); got.err == nil || !strings.Contains(got.err.Error(), "left") {
+		t.Errorf("Expected the transfer to end, got %v", got.err)
+	}
+	bob.Handle(protocol.Message{Type: protocol.MessageTypeLeft, Content: "alice"})
+	if got := waitDone(t, bob); got.err == nil {
+		t.Error("Expected the download to end")
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	tests := []struct {
+		offered string
+		want    string
+	}{
+		{"notes.txt", "notes.txt"},
+		{"../../.bashrc", "_.._.bashrc"},
+		{"/etc/passwd", "_etc_passwd"},
+		{"..", "file"},
+		{"a\x1b[2Jb", "a_[2Jb"},
+		{strings.Repeat("x", 300) + ".tar.gz", strings.Repeat("x", maxNameLength-3) + ".gz"},
+	}
+	for _, tt := range tests {
+		if got := safeName(tt.offered); got != tt.want {
+			t.Errorf("safeName(%q) = %q, want %q", tt.offered, got, tt.want)
+		}
+	}
+}
\ No newline at end of file
@@ -0,0 +1,145 @@
+package ui
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Terminal is a terminal on another machine, such as the PTY of a guest on
+// the embedded SSH server.
+type Terminal interface {
+	io.ReadWriteCloser
+	Size() (width, height int)
+	NotifyResize(cb func())
+}
+
+// NewRemote draws the chat on term rather than on our own terminal. termType
+// is the guest's $TERM.
+func NewRemote(term Terminal, termType, sessionID string) (*SimpleUI, error) {
+	info, err := tcell.LookupTerminfo(termType)
+	if err != nil {
+		// Nearly everything people ssh from understands xterm
+		if info, err = tcell.LookupTerminfo("xterm-256color"); err != nil {
+			return nil, err
+		}
+	}
+	
+	screen, err := tcell.NewTerminfoScreenFromTtyTerminfo(newRemoteTty(term), info)
+	if err != nil {
+		return nil, err
+	}
+	return newSimple(screen, sessionID)
+}
+
+var errDrained = errors.New("terminal drained")
+
+// remoteTty adapts a Terminal to tcell. A goroutine keeps reading the terminal
+// so a Read can be abandoned when tcell drains the input; there is no raw
+// mode to set because the guest's own terminal already runs raw.
+type remoteTty struct {
+	term    Terminal
+	input   chan []byte
+	closed  chan struct{}
+	pending []byte
+	
+	mu        sync.Mutex
+	drain     chan struct{}
+	drainOnce *sync.Once
+	closeOnce sync.Once
+}
+
+func newRemoteTty(term Terminal) *remoteTty {
+	t := &remoteTty{
+		term:   term,
+		input:  make(chan []byte),
+		closed: make(chan struct{}),
+	}
+	go t.pump()
+	return t
+}
+
+func (t *remoteTty) pump() {
+	defer close(t.input)
+	for {
+		buf := make([]byte, 256)
+		n, err := t.term.Read(buf)
+		if n > 0 {
+			select {
+			case t.input <- buf[:n]:
+			case <-t.closed:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (t *remoteTty) Start() error {
+	t.mu.Lock()
+	t.drain = make(chan struct{})
+	t.drainOnce = new(sync.Once)
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *remoteTty) Stop() error {
+	return nil
+}
+
+func (t *remoteTty) Drain() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.drain != nil {
+		t.drainOnce.Do(func() { close(t.drain) })
+	}
+	return nil
+}
+
+func (t *remoteTty) Read(b []byte) (int, error) {
+	if len(t.pending) == 0 {
+		t.mu.Lock()
+		drain := t.drain
+		t.mu.Unlock()
+		
+		select {
+		case data, ok := <-t.input:
+			if !ok {
+				return 0, io.EOF
+			}
+			t.pending = data
+		case <-drain:
+			return 0, errDrained
+		}
+	}
+	
+	n := copy(b, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+func (t *remoteTty) Write(b []byte) (int, error) {
+	return t.term.Write(b)
+}
+
+func (t *remoteTty) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+func (t *remoteTty) NotifyResize(cb func()) {
+	t.term.NotifyResize(cb)
+}
+
+func (t *remoteTty) WindowSize() (tcell.WindowSize, error) {
+	width, height := t.term.Size()
+	if width == 0 || height == 0 {
+		// Some clients leave the size out; assume the classic terminal
+		width, height = 80, 24
+	}
+	return tcell.WindowSize{Width: width, Height: height}, nil
+}
\ No newline at end of file
@@ -0,0 +1,63 @@
+package ui
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+type pipeTerminal struct {
+	net.Conn
+}
+
+func (pipeTerminal) Size() (int, int)    { return 80, 24 }
+func (pipeTerminal) NotifyResize(func()) {}
+
+func TestRemoteUI(t *testing.T) {
+	local, guest := net.Pipe()
+	go io.Copy(io.Discard, guest)
+	
+	ui, err := NewRemote(pipeTerminal{local}, "xterm-256color", "test-session")
+	if err != nil {
+		t.Fatalf("NewRemote failed: %v", err)
+	}
+	
+	sent := make(chan string, 1)
+	quit := make(chan struct{})
+	ui.SetCallbacks(func(msg *protocol.Message) error {
+		sent <- msg.Content
+		return nil
+	}, func() { close(quit) })
+	done := make(chan struct{})
+	go func() {
+		ui.Run()
+		close(done)
+	}()
+	
+	guest.Write([]byte("hi\r"))
+	select {
+	case content := <-sent:
+		if content != "hi" {
+			t.Errorf("Expected %q, got %q", "hi", content)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The guest's message was not sent")
+	}
+	
+	guest.Close()
+	select {
+	case <-quit:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Hanging up should quit")
+	}
+	
+	ui.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Close")
+	}
+}
\ No newline at end of file
@@ -0,0 +1,138 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Sanitize makes text from someone else safe to put on a terminal. Escape
+// sequences are removed whole, along with any other C0 or C1 control and
+// the bidirectional overrides that can make text read differently from how
+// it is stored. Tabs and newlines become spaces.
+//
+// With caret set nothing is removed; controls are shown in caret notation
+// (^[ for ESC, ^? for DEL) and the rest as <U+202E>, so the user can see
+// what was sent.
+func Sanitize(s string, caret bool) string {
+	if clean(s) {
+		return s
+	}
+	
+	var b strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\t' || r == '\n':
+			b.WriteByte(' ')
+		case caret && r < 0x20:
+			b.WriteByte('^')
+			b.WriteRune(r + 0x40)
+		case caret && r == 0x7f:
+			b.WriteString("^?")
+		case caret && unsafeRune(r):
+			fmt.Fprintf(&b, "<U+%04X>", r)
+		case r == 0x1b || r == 0x9b || r == 0x90 || r == 0x9d || r == 0x98 || r == 0x9e || r == 0x9f:
+			i = skipSequence(runes, i)
+		case unsafeRune(r):
+			// Dropped
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// clean reports whether s has nothing Sanitize would change, which is
+// nearly always.
+func clean(s string) bool {
+	for _, r := range s {
+		if r == '\t' || r == '\n' || unsafeRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// unsafeRune reports whether r is a C0 or C1 control, DEL, or a character
+// that changes the direction of the text around it.
+func unsafeRune(r rune) bool {
+	switch {
+	case r < 0x20, r >= 0x7f && r <= 0x9f:
+		return true
+	case r >= 0x202a && r <= 0x202e: // LRE, RLE, PDF, LRO, RLO
+		return true
+	case r >= 0x2066 && r <= 0x2069: // LRI, RLI, FSI, PDI
+		return true
+	case r == 0x200e, r == 0x200f, r == 0x061c: // LRM, RLM, ALM
+		return true
+	}
+	return false
+}
+
+// skipSequence returns the index of the last rune of the escape sequence
+// starting at runes[i], which is ESC or a C1 introducer. A sequence cut off
+// by the end of the text runs to the end.
+func skipSequence(runes []rune, i int) int {
+	intro := runes[i]
+	if intro == 0x1b {
+		if i+1 >= len(runes) {
+			return i
+		}
+		i++
+		switch runes[i] {
+		case '[':
+			intro = 0x9b
+		case ']':
+			intro = 0x9d
+		case 'P':
+			intro = 0x90
+		case 'X':
+			intro = 0x98
+		case '^':
+			intro = 0x9e
+		case '_':
+			intro = 0x9f
+		default:
+			// ESC, intermediates, then a final character
+			for i < len(runes) && runes[i] >= 0x20 && runes[i] <= 0x2f {
+				i++
+			}
+			if i < len(runes) && runes[i] >= 0x30 && runes[i] <= 0x7e {
+				return i
+			}
+			return i - 1
+		}
+	}
+	
+	if intro == 0x9b {
+		// CSI: parameters and intermediates, then a final character
+		for i+1 < len(runes) {
+			i++
+			switch r := runes[i]; {
+			case r >= 0x20 && r <= 0x3f:
+			case r >= 0x40 && r <= 0x7e:
+				return i
+			default:
+				// Not a well-formed sequence; leave r to be dealt with on its own
+				return i - 1
+			}
+		}
+		return i
+	}
+	
+	// A control string (OSC, DCS, SOS, PM, APC) runs to ST, and OSC may end
+	// with BEL instead
+	for i+1 < len(runes) {
+		i++
+		switch runes[i] {
+		case 0x9c, 0x07:
+			return i
+		case 0x1b:
+			if i+1 < len(runes) && runes[i+1] == '\\' {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
\ No newline at end of file
@@ -0,0 +1,73 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		strip string
+		caret string
+	}{
+		{"plain", "héllo 😀 [ok]", "héllo 😀 [ok]", "héllo 😀 [ok]"},
+		{"clear screen", "\x1b[2J\x1b[Hhi", "hi", "^[[2J^[[Hhi"},
+		{"colours", "\x1b[1;31mred\x1b[0m", "red", "^[[1;31mred^[[0m"},
+		{"cursor up over an earlier line", "ok\x1b[1A\x1b[2Kbob: send money", "okbob: send money", "ok^[[1A^[[2Kbob: send money"},
+		{"carriage return", "hi\rbob: send money", "hibob: send money", "hi^Mbob: send money"},
+		{"backspaces", "yes\b\b\bno", "yesno", "yes^H^H^Hno"},
+		{"window title", "\x1b]0;pwned\x07hi", "hi", "^[]0;pwned^Ghi"},
+		{"clipboard write", "\x1b]52;c;ZWNobyBoaQ==\x1b\\hi", "hi", "^[]52;c;ZWNobyBoaQ==^[\\hi"},
+		{"hyperlink", "\x1b]8;;https://evil.example\x1b\\click\x1b]8;;\x1b\\", "click", "^[]8;;https://evil.example^[\\click^[]8;;^[\\"},
+		{"device control", "\x1bP+q544e\x1b\\hi", "hi", "^[P+q544e^[\\hi"},
+		{"unterminated", "hi\x1b]0;title", "hi", "hi^[]0;title"},
+		{"C1 CSI", "\u009b2Jhi", "hi", "<U+009B>2Jhi"},
+		{"two-character sequence", "\x1bchi", "hi", "^[chi"},
+		{"trailing escape", "hi\x1b", "hi", "hi^["},
+		{"bell and delete", "a\x07b\x7fc", "abc", "a^Gb^?c"},
+		{"bidi override", "admin\u202e\u2066 // x\u2069\u2066", "admin // x", "admin<U+202E><U+2066> // x<U+2069><U+2066>"},
+		{"whitespace", "a\tb\nc", "a b c", "a b c"},
+	}
+	for _, tt := range tests {
+		if got := Sanitize(tt.in, false); got != tt.strip {
+			t.Errorf("%s: Sanitize(%q) = %q, want %q", tt.name, tt.in, got, tt.strip)
+		}
+		if got := Sanitize(tt.in, true); got != tt.caret {
+			t.Errorf("%s: Sanitize(%q, caret) = %q, want %q", tt.name, tt.in, got, tt.caret)
+		}
+	}
+}
+
+func TestMessagesDrawnSanitized(t *testing.T) {
+	screen := tcell.NewSimulationScreen("UTF-8")
+	screen.SetSize(80, 24)
+	chat, err := newSimple(screen, "s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chat.Close()
+	
+	msg := protocol.NewMessage(protocol.MessageTypeText, "\x1b]0;pwned\x07hello\x1b[2J")
+	msg.From = "bob\u202e"
+	chat.DisplayMessage(*msg)
+	
+	cells, width, _ := screen.GetContents()
+	var text strings.Builder
+	for i, cell := range cells {
+		text.WriteString(string(cell.Runes))
+		if i%width == width-1 {
+			text.WriteByte('\n')
+		}
+	}
+	if !strings.Contains(text.String(), "bob: hello") {
+		t.Errorf("Expected the cleaned message on screen, got:\n%s", text.String())
+	}
+	if strings.ContainsAny(text.String(), "\x1b\x07\u202e") {
+		t.Error("Control characters reached the screen")
+	}
+}
\ No newline at end of file
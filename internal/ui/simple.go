@@ -1,8 +1,10 @@
 package ui
 
 import (
+	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/sam/termchat/pkg/protocol"
@@ -14,16 +16,35 @@ type SimpleUI struct {
 	input     string
 	cursorPos int
 	sessionID string
-	scrollPos int  // 0 = bottom (newest), increases as you scroll up
+	name      string
+	roster    []string
+	scrollPos int // 0 = bottom (newest), increases as you scroll up
+	typing    *typingNotifier
+	typists   *typingTracker
+	seen      map[string]bool // IDs of messages already shown
+	unread    []string        // IDs not yet reported as read
+	focused   bool
+	verifier  verifier
+	caret     bool // show control characters instead of removing them
 	mu        sync.Mutex
 	
-	onMessage func(string)
+	onMessage func(*protocol.Message) error
+	onCommand func(cmd, arg string)
+	onRead    func(id string)
 	onQuit    func()
 }
 
 type ChatMsg struct {
+	ID      string
 	Content string
+	From    string
 	FromMe  bool
+	System  bool
+	Warning bool                    // a system message shown in red
+	Status  protocol.DeliveryStatus // only tracked for our own messages
+	
+	// A file transfer under way, shown with a progress bar
+	Done, Size int64
 }
 
 func NewSimple(sessionID string) (*SimpleUI, error) {
@@ -31,18 +52,24 @@ func NewSimple(sessionID string) (*SimpleUI, error) {
 	if err != nil {
 		return nil, err
 	}
-	
+	return newSimple(screen, sessionID)
+}
+
+func newSimple(screen tcell.Screen, sessionID string) (*SimpleUI, error) {
 	if err := screen.Init(); err != nil {
 		return nil, err
 	}
 	
 	screen.SetStyle(tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorWhite))
+	screen.EnableFocus()
 	screen.Clear()
 	
 	return &SimpleUI{
 		screen:    screen,
 		messages:  make([]ChatMsg, 0),
 		sessionID: sessionID,
+		seen:      make(map[string]bool),
+		focused:   true, // many terminals never report focus
 	}, nil
 }
 
@@ -50,11 +77,100 @@ func (ui *SimpleUI) Close() {
 	ui.screen.Fini()
 }
 
-func (ui *SimpleUI) SetCallbacks(onMessage func(string), onQuit func()) {
+// SetCallbacks registers the sender for TEXT messages typed by the user; an
+// error marks the message as failed.
+func (ui *SimpleUI) SetCallbacks(onMessage func(*protocol.Message) error, onQuit func()) {
 	ui.onMessage = onMessage
 	ui.onQuit = onQuit
 }
 
+// SetCommandHandler receives slash commands other than /quit, e.g. "/nick bob"
+// arrives as ("nick", "bob").
+func (ui *SimpleUI) SetCommandHandler(onCommand func(cmd, arg string)) {
+	ui.onCommand = onCommand
+}
+
+// SetTypingCallback turns on typing indicators: onTyping is told when we
+// start and stop typing, and incoming typing events are shown in a status line.
+func (ui *SimpleUI) SetTypingCallback(onTyping func(active bool)) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	
+	ui.typing = newTypingNotifier(onTyping)
+	ui.typists = newTypingTracker()
+}
+
+// SetReadCallback turns on read receipts: onRead is called with the ID of
+// each incoming message once it has been on screen in a focused terminal.
+func (ui *SimpleUI) SetReadCallback(onRead func(id string)) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.onRead = onRead
+}
+
+// SetStatus updates the delivery status shown next to one of our messages.
+// Reports that arrive out of order never move a message backwards.
+func (ui *SimpleUI) SetStatus(id string, status protocol.DeliveryStatus) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	
+	for i := len(ui.messages) - 1; i >= 0; i-- {
+		m := &ui.messages[i]
+		if m.FromMe && m.ID == id {
+			if status > m.Status {
+				m.Status = status
+				ui.draw()
+			}
+			return
+		}
+	}
+}
+
+// SetVerificationCode shows the code the user should compare with name's
+// before trusting the session. Registering the same code again, as happens
+// after a reconnect, keeps its verified state.
+func (ui *SimpleUI) SetVerificationCode(name, code string) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	
+	if code == "" || !ui.verifier.add(name, code) {
+		return
+	}
+	ui.messages = append(ui.messages, ChatMsg{
+		Content: fmt.Sprintf("[Verification code with %s: %s - check that they see the same code (call them, or ask in person), then type /verify %s]", name, code, name),
+		System:  true,
+	})
+	ui.scrollPos = 0
+	ui.draw()
+}
+
+// ClearVerification forgets name's code once they have left.
+func (ui *SimpleUI) ClearVerification(name string) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	
+	ui.verifier.remove(name)
+	ui.draw()
+}
+
+// SetShowControls shows control characters in what others send as ^[
+// and the like, instead of removing them.
+func (ui *SimpleUI) SetShowControls(show bool) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	
+	ui.caret = show
+	ui.draw()
+}
+
+func (ui *SimpleUI) SetName(name string) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	
+	ui.name = name
+	ui.draw()
+}
+
 func (ui *SimpleUI) Run() {
 	ui.draw()
 	
@@ -70,6 +186,16 @@ func (ui *SimpleUI) Run() {
 		case *tcell.EventResize:
 			ui.screen.Sync()
 			ui.draw()
+		case *tcell.EventFocus:
+			ui.mu.Lock()
+			ui.focused = ev.Focused
+			ui.flushRead()
+			ui.mu.Unlock()
+		case *tcell.EventError:
+			// The terminal is gone, e.g. a remote guest hung up
+			if ui.onQuit != nil {
+				ui.onQuit()
+			}
 		}
 	}
 }
@@ -94,20 +220,54 @@ func (ui *SimpleUI) handleKey(ev *tcell.EventKey) {
 				return
 			}
 			
+			if ui.input == "/retry" {
+				ui.input = ""
+				ui.cursorPos = 0
+				ui.retryFailed()
+				break
+			}
+			
+			if cmd, arg, _ := strings.Cut(ui.input, " "); cmd == "/verify" {
+				ui.input = ""
+				ui.cursorPos = 0
+				reply, warning := ui.verifier.verify(arg)
+				ui.messages = append(ui.messages, ChatMsg{Content: reply, System: true, Warning: warning})
+				ui.scrollPos = 0
+				break
+			}
+			
+			if strings.HasPrefix(ui.input, "/") {
+				cmd, arg, _ := strings.Cut(strings.TrimPrefix(ui.input, "/"), " ")
+				ui.input = ""
+				ui.cursorPos = 0
+				if ui.onCommand != nil {
+					// Run outside the lock: handlers report back through
+					// AddMessage
+					go ui.onCommand(cmd, strings.TrimSpace(arg))
+				}
+				break
+			}
+			
 			// Add message to display
 			ui.messages = append(ui.messages, ChatMsg{
+				ID:      protocol.NewMessageID(),
 				Content: ui.input,
+				From:    ui.name,
 				FromMe:  true,
 			})
 			
 			// Reset scroll to bottom when sending
 			ui.scrollPos = 0
 			
-			// Send message
-			if ui.onMessage != nil {
-				ui.onMessage(ui.input)
+			// The message itself tells the peer we stopped typing
+			if ui.typing != nil {
+				ui.typing.Stop(false)
 			}
 			
+			// Send message
+			ui.send(len(ui.messages) - 1)
+			ui.warnUnverified()
+			
 			ui.input = ""
 			ui.cursorPos = 0
 		}
@@ -116,6 +276,7 @@ func (ui *SimpleUI) handleKey(ev *tcell.EventKey) {
 		if ui.cursorPos > 0 {
 			ui.input = ui.input[:ui.cursorPos-1] + ui.input[ui.cursorPos:]
 			ui.cursorPos--
+			ui.noteTyping()
 		}
 		
 	case tcell.KeyLeft:
@@ -139,30 +300,138 @@ func (ui *SimpleUI) handleKey(ev *tcell.EventKey) {
 		if ui.scrollPos > 0 {
 			ui.scrollPos--
 		}
+		ui.flushRead()
 		
 	default:
 		if ev.Rune() != 0 {
 			ui.input = ui.input[:ui.cursorPos] + string(ev.Rune()) + ui.input[ui.cursorPos:]
 			ui.cursorPos++
+			ui.noteTyping()
 		}
 	}
 	
 	ui.draw()
 }
 
+// send hands one of our messages to the network. Retries reuse the ID so
+// peers that already have it can ignore the copy.
+func (ui *SimpleUI) send(i int) {
+	if ui.onMessage == nil {
+		return
+	}
+	
+	m := &ui.messages[i]
+	msg := protocol.NewMessage(protocol.MessageTypeText, m.Content)
+	msg.ID = m.ID
+	if err := ui.onMessage(msg); err != nil {
+		m.Status = protocol.StatusFailed
+	}
+}
+
+// retryFailed sends every message marked as failed again.
+func (ui *SimpleUI) retryFailed() {
+	retried := 0
+	for i := range ui.messages {
+		if ui.messages[i].FromMe && ui.messages[i].Status == protocol.StatusFailed {
+			ui.messages[i].Status = protocol.StatusNone
+			ui.send(i)
+			retried++
+		}
+	}
+	
+	if retried == 0 {
+		ui.messages = append(ui.messages, ChatMsg{
+			Content: "[No failed messages to retry]",
+			System:  true,
+		})
+	}
+	ui.scrollPos = 0
+}
+
+// warnUnverified tells the user, once per peer, that they are chatting with
+// someone whose code they never checked.
+func (ui *SimpleUI) warnUnverified() {
+	for _, p := range ui.verifier.peers {
+		if p.verified || p.warned {
+			continue
+		}
+		p.warned = true
+		ui.messages = append(ui.messages, ChatMsg{
+			Content: fmt.Sprintf("[WARNING: %s is not verified. Anyone who guessed the session ID could be on the other end. Compare codes (%s) out of band, then /verify %s]", p.name, p.code, p.name),
+			System:  true,
+			Warning: true,
+		})
+	}
+}
+
+// flushRead sends read receipts for messages the user has now seen.
+func (ui *SimpleUI) flushRead() {
+	if ui.onRead == nil || !ui.focused || ui.scrollPos != 0 {
+		return
+	}
+	for _, id := range ui.unread {
+		ui.onRead(id)
+	}
+	ui.unread = nil
+}
+
+// noteTyping reports an edit to the input line. Commands are never announced
+// and clearing the line counts as stopping.
+func (ui *SimpleUI) noteTyping() {
+	if ui.typing == nil {
+		return
+	}
+	if ui.input == "" || strings.HasPrefix(ui.input, "/") {
+		ui.typing.Stop(true)
+		return
+	}
+	ui.typing.Keystroke()
+}
+
 func (ui *SimpleUI) draw() {
 	ui.screen.Clear()
 	width, height := ui.screen.Size()
 	
-	// Draw session ID at top
+	// Draw session ID and participants at top
 	sessionText := "Session: " + ui.sessionID
+	if len(ui.roster) > 0 {
+		sessionText += fmt.Sprintf("  |  %d here: %s", len(ui.roster), strings.Join(ui.roster, ", "))
+	}
 	style := tcell.StyleDefault.Foreground(tcell.ColorGray)
-	for i, r := range sessionText {
+	x := 0
+	for _, r := range Sanitize(sessionText, ui.caret) {
+		if x < width {
+			ui.screen.SetContent(x, 0, r, nil, style)
+		}
+		x++
+	}
+	if pending := ui.verifier.unverified(); len(pending) > 0 {
+		warning := tcell.StyleDefault.Foreground(tcell.ColorRed).Bold(true)
+		for _, r := range Sanitize("  |  UNVERIFIED: "+strings.Join(pending, ", "), ui.caret) {
+			if x < width {
+				ui.screen.SetContent(x, 0, r, nil, warning)
+			}
+			x++
+		}
+	}
+	
+	ui.drawMessages(width, height)
+	ui.drawStatus(width, height)
+	
+	// Draw input line at bottom
+	inputY := height - 1
+	for i, r := range ui.input {
 		if i < width {
-			ui.screen.SetContent(i, 0, r, nil, style)
+			ui.screen.SetContent(i, inputY, r, nil, tcell.StyleDefault)
 		}
 	}
 	
+	// Show cursor
+	ui.screen.ShowCursor(ui.cursorPos, inputY)
+	ui.screen.Show()
+}
+
+func (ui *SimpleUI) drawMessages(width, height int) {
 	// Draw messages with boxes
 	y := 2
 	
@@ -173,7 +442,7 @@ func (ui *SimpleUI) draw() {
 	}
 	
 	// Calculate how many messages can fit
-	availableHeight := height - 4 // Leave room for header and input
+	availableHeight := height - 4            // Leave room for header and input
 	messagesPerScreen := availableHeight / 4 // Each message box takes ~4 lines
 	
 	// Calculate start index based on scroll position
@@ -192,31 +461,33 @@ func (ui *SimpleUI) draw() {
 		if y+3 >= height-2 {
 			break
 		}
-		msg := ui.messages[i]
-		displayText := ""
-		if msg.FromMe {
-			displayText = "you: " + msg.Content
-		} else {
-			displayText = "peer: " + msg.Content
+		style := tcell.StyleDefault
+		if ui.messages[i].Warning {
+			style = style.Foreground(tcell.ColorRed).Bold(true)
 		}
-		ui.drawMessageBox(1, y, width-2, displayText)
+		ui.drawMessageBox(1, y, width-2, Sanitize(ui.messages[i].Label(), ui.caret), style)
 		y += 4
 	}
+}
+
+// drawStatus shows who is typing on the line just above the input.
+func (ui *SimpleUI) drawStatus(width, height int) {
+	if ui.typists == nil {
+		return
+	}
 	
-	// Draw input line at bottom
-	inputY := height - 1
-	for i, r := range ui.input {
-		if i < width {
-			ui.screen.SetContent(i, inputY, r, nil, tcell.StyleDefault)
+	style := tcell.StyleDefault.Foreground(tcell.ColorGray)
+	x := 0
+	for _, r := range Sanitize(typingStatus(ui.typists.Active()), ui.caret) {
+		if x >= width {
+			break
 		}
+		ui.screen.SetContent(x, height-2, r, nil, style)
+		x++
 	}
-	
-	// Show cursor
-	ui.screen.ShowCursor(ui.cursorPos, inputY)
-	ui.screen.Show()
 }
 
-func (ui *SimpleUI) drawMessageBox(x, y, maxWidth int, msg string) {
+func (ui *SimpleUI) drawMessageBox(x, y, maxWidth int, msg string, style tcell.Style) {
 	// Wrap text if needed
 	lines := wrapText(msg, maxWidth-2)
 	boxHeight := len(lines) + 2
@@ -234,27 +505,55 @@ func (ui *SimpleUI) drawMessageBox(x, y, maxWidth int, msg string) {
 	}
 	
 	// Top border
-	ui.screen.SetContent(x, y, '┌', nil, tcell.StyleDefault)
+	ui.screen.SetContent(x, y, '┌', nil, style)
 	for i := 1; i < boxWidth-1; i++ {
-		ui.screen.SetContent(x+i, y, '─', nil, tcell.StyleDefault)
+		ui.screen.SetContent(x+i, y, '─', nil, style)
 	}
-	ui.screen.SetContent(x+boxWidth-1, y, '┐', nil, tcell.StyleDefault)
+	ui.screen.SetContent(x+boxWidth-1, y, '┐', nil, style)
 	
 	// Message lines
 	for i, line := range lines {
-		ui.screen.SetContent(x, y+i+1, '│', nil, tcell.StyleDefault)
+		ui.screen.SetContent(x, y+i+1, '│', nil, style)
 		for j, r := range line {
-			ui.screen.SetContent(x+j+1, y+i+1, r, nil, tcell.StyleDefault)
+			ui.screen.SetContent(x+j+1, y+i+1, r, nil, style)
 		}
-		ui.screen.SetContent(x+boxWidth-1, y+i+1, '│', nil, tcell.StyleDefault)
+		ui.screen.SetContent(x+boxWidth-1, y+i+1, '│', nil, style)
 	}
 	
 	// Bottom border
-	ui.screen.SetContent(x, y+boxHeight-1, '└', nil, tcell.StyleDefault)
+	ui.screen.SetContent(x, y+boxHeight-1, '└', nil, style)
 	for i := 1; i < boxWidth-1; i++ {
-		ui.screen.SetContent(x+i, y+boxHeight-1, '─', nil, tcell.StyleDefault)
+		ui.screen.SetContent(x+i, y+boxHeight-1, '─', nil, style)
+	}
+	ui.screen.SetContent(x+boxWidth-1, y+boxHeight-1, '┘', nil, style)
+}
+
+// Label is the text shown for a message, prefixed with who sent it and, for
+// our own messages, followed by its delivery status.
+func (m ChatMsg) Label() string {
+	switch {
+	case m.System && m.Size > 0:
+		return m.Content + " " + progressBar(m.Done, m.Size)
+	case m.System:
+		return m.Content
+	case m.FromMe:
+		label := "you: " + m.Content
+		if m.From != "" {
+			label = m.From + " (you): " + m.Content
+		}
+		switch m.Status {
+		case protocol.StatusNone:
+			return label
+		case protocol.StatusFailed:
+			return label + "  [failed - /retry to resend]"
+		default:
+			return label + "  [" + m.Status.String() + "]"
+		}
+	case m.From != "":
+		return m.From + ": " + m.Content
+	default:
+		return "peer: " + m.Content
 	}
-	ui.screen.SetContent(x+boxWidth-1, y+boxHeight-1, '┘', nil, tcell.StyleDefault)
 }
 
 func wrapText(text string, width int) []string {
@@ -290,7 +589,7 @@ func (ui *SimpleUI) AddMessage(content string) {
 	
 	ui.messages = append(ui.messages, ChatMsg{
 		Content: content,
-		FromMe:  false,
+		System:  true,
 	})
 	// Reset scroll to see new message
 	ui.scrollPos = 0
@@ -298,16 +597,106 @@ func (ui *SimpleUI) AddMessage(content string) {
 }
 
 func (ui *SimpleUI) DisplayMessage(msg protocol.Message) {
-	if msg.Type == protocol.MessageTypeText {
-		ui.mu.Lock()
-		defer ui.mu.Unlock()
-		
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	
+	switch msg.Type {
+	case protocol.MessageTypeText:
+		if msg.ID != "" {
+			// A sender retrying a message we already have
+			if ui.seen[msg.ID] {
+				return
+			}
+			ui.seen[msg.ID] = true
+			if ui.onRead != nil {
+				ui.unread = append(ui.unread, msg.ID)
+			}
+		}
+		if ui.typists != nil {
+			ui.typists.Set(msg.From, false)
+		}
 		ui.messages = append(ui.messages, ChatMsg{
+			ID:      msg.ID,
 			Content: msg.Content,
-			FromMe:  false,
+			From:    msg.From,
 		})
-		// Reset scroll to see new message
-		ui.scrollPos = 0
+	case protocol.MessageTypeTyping:
+		if ui.typists == nil {
+			return
+		}
+		ui.typists.Set(msg.From, msg.Content == "true")
+		// Redraw once the indicator would expire in case no stop arrives
+		time.AfterFunc(typingExpiry+100*time.Millisecond, ui.redraw)
 		ui.draw()
+		return
+	case protocol.MessageTypeJoined:
+		ui.messages = append(ui.messages, ChatMsg{
+			Content: fmt.Sprintf("[%s joined]", msg.Content),
+			System:  true,
+		})
+	case protocol.MessageTypeLeft:
+		if ui.typists != nil {
+			ui.typists.Set(msg.Content, false)
+		}
+		ui.verifier.remove(msg.Content)
+		ui.messages = append(ui.messages, ChatMsg{
+			Content: fmt.Sprintf("[%s left]", msg.Content),
+			System:  true,
+		})
+	case protocol.MessageTypeAway:
+		if ui.typists != nil {
+			ui.typists.Set(msg.Content, false)
+		}
+		ui.messages = append(ui.messages, ChatMsg{
+			Content: fmt.Sprintf("[%s lost connection, waiting for them to come back]", msg.Content),
+			System:  true,
+		})
+	case protocol.MessageTypeBack:
+		ui.messages = append(ui.messages, ChatMsg{
+			Content: fmt.Sprintf("[%s is back]", msg.Content),
+			System:  true,
+		})
+	case protocol.MessageTypeNick:
+		if msg.From == ui.name {
+			ui.name = msg.Content
+		}
+		if ui.typists != nil {
+			ui.typists.Rename(msg.From, msg.Content)
+		}
+		ui.verifier.rename(msg.From, msg.Content)
+		ui.messages = append(ui.messages, ChatMsg{
+			Content: fmt.Sprintf("[%s is now known as %s]", msg.From, msg.Content),
+			System:  true,
+		})
+	case protocol.MessageTypeError:
+		ui.messages = append(ui.messages, ChatMsg{
+			Content: fmt.Sprintf("[Error: %s]", msg.Content),
+			System:  true,
+		})
+	case protocol.MessageTypeRoster:
+		ui.roster = msg.Roster
+		ui.draw()
+		return
+	default:
+		return
 	}
+	
+	// Reset scroll to see new message
+	ui.scrollPos = 0
+	ui.draw()
+	ui.flushRead()
+}
+
+func (ui *SimpleUI) redraw() {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.draw()
+}
+
+func (ui *SimpleUI) SetRoster(names []string) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	
+	ui.roster = names
+	ui.draw()
 }
\ No newline at end of file
@@ -0,0 +1,29 @@
+package ui
+
+import (
+	"testing"
+
+	"github.com/sam/termchat/pkg/protocol"
+)
+
+func TestChatMsgLabel(t *testing.T) {
+	tests := []struct {
+		msg  ChatMsg
+		want string
+	}{
+		{ChatMsg{Content: "[bob joined]", System: true}, "[bob joined]"},
+		{ChatMsg{Content: "hi", From: "bob"}, "bob: hi"},
+		{ChatMsg{Content: "hi"}, "peer: hi"},
+		{ChatMsg{Content: "hi", FromMe: true}, "you: hi"},
+		{ChatMsg{Content: "hi", From: "alice", FromMe: true}, "alice (you): hi"},
+		{ChatMsg{Content: "hi", FromMe: true, Status: protocol.StatusSending}, "you: hi  [sending]"},
+		{ChatMsg{Content: "hi", FromMe: true, Status: protocol.StatusRead}, "you: hi  [read]"},
+		{ChatMsg{Content: "hi", FromMe: true, Status: protocol.StatusFailed}, "you: hi  [failed - /retry to resend]"},
+	}
+	
+	for _, tt := range tests {
+		if got := tt.msg.Label(); got != tt.want {
+			t.Errorf("Label() = %q, want %q", got, tt.want)
+		}
+	}
+}
\ No newline at end of file
@@ -0,0 +1,85 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// progressWidth is how many cells the bar itself takes.
+const progressWidth = 20
+
+// SetTransfer shows how far a file transfer has got, as a line with a
+// progress bar that is added the first time key is seen and updated in
+// place after that.
+func (ui *SimpleUI) SetTransfer(key, label string, done, size int64) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	
+	if m := ui.transferLine(key); m != nil {
+		m.Content = label
+		m.Done = done
+		m.Size = size
+	} else {
+		ui.messages = append(ui.messages, ChatMsg{
+			ID:      key,
+			Content: label,
+			System:  true,
+			Done:    done,
+			Size:    size,
+		})
+		ui.scrollPos = 0
+	}
+	ui.draw()
+}
+
+// FinishTransfer replaces a transfer's progress bar with how it ended.
+func (ui *SimpleUI) FinishTransfer(key, result string, failed bool) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	
+	m := ui.transferLine(key)
+	if m == nil {
+		ui.messages = append(ui.messages, ChatMsg{ID: key, System: true})
+		m = &ui.messages[len(ui.messages)-1]
+		ui.scrollPos = 0
+	}
+	m.Content = result
+	m.Warning = failed
+	m.Done = 0
+	m.Size = 0
+	ui.draw()
+}
+
+func (ui *SimpleUI) transferLine(key string) *ChatMsg {
+	for i := len(ui.messages) - 1; i >= 0; i-- {
+		if m := &ui.messages[i]; m.System && m.ID == key {
+			return m
+		}
+	}
+	return nil
+}
+
+// progressBar draws done out of size as "[#####-----] 50% 1.0 MB/2.0 MB".
+func progressBar(done, size int64) string {
+	if size <= 0 {
+		return ""
+	}
+	filled := int(done * progressWidth / size)
+	return fmt.Sprintf("[%s%s] %d%% %s/%s",
+		strings.Repeat("#", filled), strings.Repeat("-", progressWidth-filled),
+		done*100/size, FormatSize(done), FormatSize(size))
+}
+
+// FormatSize writes a byte count the way people read it, e.g. "1.5 MB".
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
\ No newline at end of file
@@ -0,0 +1,27 @@
+package ui
+
+import "testing"
+
+func TestProgressBar(t *testing.T) {
+	tests := []struct {
+		done, size int64
+		want       string
+	}{
+		{0, 2048, "[--------------------] 0% 0 B/2.0 KB"},
+		{1024, 2048, "[##########----------] 50% 1.0 KB/2.0 KB"},
+		{3 << 20, 3 << 20, "[####################] 100% 3.0 MB/3.0 MB"},
+		{0, 0, ""},
+	}
+	for _, tt := range tests {
+		if got := progressBar(tt.done, tt.size); got != tt.want {
+			t.Errorf("progressBar(%d, %d) = %q, want %q", tt.done, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestTransferLabel(t *testing.T) {
+	m := ChatMsg{Content: "[Receiving notes.txt from bob]", System: true, Done: 512, Size: 1024}
+	if got, want := m.Label(), "[Receiving notes.txt from bob] [##########----------] 50% 512 B/1.0 KB"; got != want {
+		t.Errorf("Label() = %q, want %q", got, want)
+	}
+}
\ No newline at end of file
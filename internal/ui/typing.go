@@ -0,0 +1,123 @@
+package ui
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Stop counts as typing after this long without a keystroke
+	typingIdle = 5 * time.Second
+	// While typing continues, re-announce at most this often so the other
+	// side's indicator does not expire
+	typingRefresh = 3 * time.Second
+	// Forget a remote typist we have not heard from in this long
+	typingExpiry = 6 * time.Second
+)
+
+// typingNotifier turns keystrokes into throttled typing start/stop events.
+type typingNotifier struct {
+	mu       sync.Mutex
+	active   bool
+	lastSent time.Time
+	timer    *time.Timer
+	idle     time.Duration
+	refresh  time.Duration
+	send     func(active bool)
+}
+
+func newTypingNotifier(send func(active bool)) *typingNotifier {
+	return &typingNotifier{
+		idle:    typingIdle,
+		refresh: typingRefresh,
+		send:    send,
+	}
+}
+
+func (t *typingNotifier) Keystroke() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	
+	now := time.Now()
+	if !t.active || now.Sub(t.lastSent) >= t.refresh {
+		t.active = true
+		t.lastSent = now
+		go t.send(true)
+	}
+	
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timer = time.AfterFunc(t.idle, func() {
+		t.Stop(true)
+	})
+}
+
+// Stop ends the typing state. notify is false when the peer can infer it,
+// e.g. because the message was just sent.
+func (t *typingNotifier) Stop(notify bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	if t.active && notify {
+		go t.send(false)
+	}
+	t.active = false
+}
+
+// typingTracker remembers who is typing on the other side.
+type typingTracker struct {
+	until map[string]time.Time
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{until: make(map[string]time.Time)}
+}
+
+func (t *typingTracker) Set(name string, active bool) {
+	if active {
+		t.until[name] = time.Now().Add(typingExpiry)
+	} else {
+		delete(t.until, name)
+	}
+}
+
+func (t *typingTracker) Rename(old, name string) {
+	if until, ok := t.until[old]; ok {
+		delete(t.until, old)
+		t.until[name] = until
+	}
+}
+
+// Active returns the names still typing, sorted.
+func (t *typingTracker) Active() []string {
+	now := time.Now()
+	var names []string
+	for name, until := range t.until {
+		if now.Before(until) {
+			names = append(names, name)
+		} else {
+			delete(t.until, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func typingStatus(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return names[0] + " is typing…"
+	case 2:
+		return names[0] + " and " + names[1] + " are typing…"
+	default:
+		return "several people are typing…"
+	}
+}
\ No newline at end of file
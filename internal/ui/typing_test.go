@@ -0,0 +1,75 @@
+package ui
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTypingNotifierDebounce(t *testing.T) {
+	events := make(chan bool, 16)
+	n := newTypingNotifier(func(active bool) { events <- active })
+	n.idle = 50 * time.Millisecond
+	n.refresh = time.Hour
+	
+	// A burst of keystrokes announces typing once
+	for i := 0; i < 5; i++ {
+		n.Keystroke()
+	}
+	
+	if got := <-events; !got {
+		t.Fatal("Expected typing start")
+	}
+	
+	// Going idle announces the stop
+	select {
+	case got := <-events:
+		if got {
+			t.Error("Expected typing stop after idle, got another start")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Typing stop was never sent")
+	}
+}
+
+func TestTypingNotifierRefresh(t *testing.T) {
+	events := make(chan bool, 16)
+	n := newTypingNotifier(func(active bool) { events <- active })
+	n.refresh = 0
+	
+	n.Keystroke()
+	n.Keystroke()
+	n.Stop(false)
+	
+	for i := 0; i < 2; i++ {
+		if got := <-events; !got {
+			t.Errorf("Expected refreshed start, got stop")
+		}
+	}
+	
+	select {
+	case got := <-events:
+		t.Errorf("Stop without notify should be silent, got %v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTypingTracker(t *testing.T) {
+	tracker := newTypingTracker()
+	tracker.Set("bob", true)
+	tracker.Set("alice", true)
+	
+	if got := typingStatus(tracker.Active()); got != "alice and bob are typing…" {
+		t.Errorf("Unexpected status %q", got)
+	}
+	
+	tracker.Rename("bob", "robert")
+	tracker.Set("alice", false)
+	if got := typingStatus(tracker.Active()); got != "robert is typing…" {
+		t.Errorf("Unexpected status %q", got)
+	}
+	
+	tracker.until["robert"] = time.Now().Add(-time.Second)
+	if got := tracker.Active(); len(got) != 0 {
+		t.Errorf("Expired typist should be dropped, got %v", got)
+	}
+}
\ No newline at end of file
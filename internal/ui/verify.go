@@ -0,0 +1,118 @@
+package ui
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+// verification is the short authentication string shared with one peer and
+// whether the user has confirmed it out of band.
+type verification struct {
+	name     string
+	code     string
+	verified bool
+	warned   bool // already told the user this peer is unverified
+}
+
+// verifier tracks a code per peer: the host for a joiner, every joiner for
+// the host.
+type verifier struct {
+	peers []*verification
+}
+
+// add registers a peer's code. It returns false if the code is already known,
+// as happens when a peer resumes after a dropped connection.
+func (v *verifier) add(name, code string) bool {
+	for _, p := range v.peers {
+		if p.code == code {
+			p.name = name
+			return false
+		}
+	}
+	v.peers = append(v.peers, &verification{name: name, code: code})
+	return true
+}
+
+func (v *verifier) find(name string) *verification {
+	for _, p := range v.peers {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (v *verifier) rename(old, name string) {
+	if p := v.find(old); p != nil {
+		p.name = name
+	}
+}
+
+func (v *verifier) remove(name string) {
+	for i, p := range v.peers {
+		if p.name == name {
+			v.peers = append(v.peers[:i], v.peers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (v *verifier) unverified() []string {
+	var names []string
+	for _, p := range v.peers {
+		if !p.verified {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// verify handles "/verify [name] [code]" and returns what to tell the user.
+// Without a code the user vouches that the codes matched; with one the
+// codes are compared for them. warning is set when verification failed.
+func (v *verifier) verify(arg string) (reply string, warning bool) {
+	fields := strings.Fields(arg)
+	
+	var target *verification
+	if len(fields) > 0 {
+		target = v.find(fields[0])
+		if target != nil {
+			fields = fields[1:]
+		}
+	}
+	if target == nil {
+		switch pending := v.unverified(); {
+		case len(v.peers) == 1:
+			target = v.peers[0]
+		case len(pending) == 1:
+			target = v.find(pending[0])
+		case len(v.peers) == 0:
+			return "[Nobody to verify yet]", false
+		default:
+			return "[Say who to verify: /verify <name> [code]]", false
+		}
+	}
+	
+	if code := strings.Join(fields, ""); code != "" && !codesMatch(target.code, code) {
+		target.verified = false
+		return fmt.Sprintf("[WARNING: VERIFICATION FAILED for %s: you were given %s but the code here is %s. Someone may be intercepting this session - stop chatting and /quit]",
+			target.name, strings.Join(fields, " "), target.code), true
+	}
+	
+	target.verified = true
+	return fmt.Sprintf("[%s is verified]", target.name), false
+}
+
+// codesMatch compares verification codes, ignoring spaces and dashes.
+func codesMatch(want, typed string) bool {
+	strip := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == ' ' || r == '-' {
+				return -1
+			}
+			return r
+		}, s)
+	}
+	return subtle.ConstantTimeCompare([]byte(strip(want)), []byte(strip(typed))) == 1
+}
\ No newline at end of file
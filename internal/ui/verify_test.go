@@ -0,0 +1,62 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifierSinglePeer(t *testing.T) {
+	var v verifier
+	if !v.add("host", "482 913") {
+		t.Fatal("First code should be new")
+	}
+	
+	if reply, warning := v.verify("111 111"); !warning || !strings.Contains(reply, "FAILED") {
+		t.Errorf("Wrong code should warn, got %q", reply)
+	}
+	if len(v.unverified()) != 1 {
+		t.Error("A failed check must not verify the peer")
+	}
+	
+	if _, warning := v.verify("482-913"); warning {
+		t.Error("Matching code should verify")
+	}
+	if len(v.unverified()) != 0 {
+		t.Error("Peer should now be verified")
+	}
+	
+	// Reconnecting re-registers the same code and keeps the verified state
+	if v.add("host", "482 913") {
+		t.Error("Known code should not be announced again")
+	}
+	if len(v.unverified()) != 0 {
+		t.Error("Reconnect should keep verification")
+	}
+}
+
+func TestVerifierSeveralPeers(t *testing.T) {
+	var v verifier
+	v.add("alice", "111 111")
+	v.add("bob", "222 222")
+	
+	if _, warning := v.verify(""); warning || len(v.unverified()) != 2 {
+		t.Error("Ambiguous /verify should ask who to verify")
+	}
+	
+	v.verify("alice 111111")
+	if got := v.unverified(); len(got) != 1 || got[0] != "bob" {
+		t.Errorf("Expected only bob unverified, got %v", got)
+	}
+	
+	// With one peer left to check, the name can be omitted
+	v.rename("bob", "robert")
+	v.verify("")
+	if len(v.unverified()) != 0 {
+		t.Error("Expected robert to be verified")
+	}
+	
+	v.remove("alice")
+	if v.find("alice") != nil {
+		t.Error("alice should be forgotten")
+	}
+}
\ No newline at end of file
@@ -0,0 +1,476 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Codec turns messages into frames on the wire and back. Every connection
+// starts out in JSON; the joiner lists the codecs it speaks in HELLO and the
+// host names the one it picked in WELCOME, which both sides switch to from
+// the joiner's READY on.
+type Codec interface {
+	Name() string
+	NewEncoder(w io.Writer) Encoder
+	
+	// NewDecoder reads frames of at most maxSize bytes, or
+	// DefaultMaxFrameSize if maxSize is 0.
+	NewDecoder(r *bufio.Reader, maxSize int) Decoder
+}
+
+// Encoder writes one message per call. It is not safe for concurrent use.
+type Encoder interface {
+	Encode(msg *Message) error
+}
+
+// Decoder reads one message per call. A codec switch hands the same
+// bufio.Reader to the next decoder, so nothing read ahead is lost.
+//
+// A frame that is too large or cannot be parsed is skipped and reported as
+// a *FrameError; the next call reads the frame after it. A FrameError that is
+// Fatal could not be skipped, and any other error means the stream is
+// broken; either way the caller should close the connection.
+type Decoder interface {
+	Decode(msg *Message) error
+}
+
+// DefaultMaxFrameSize bounds one encoded message, which is far more than
+// any chat message needs and leaves room for a file chunk in JSON.
+const DefaultMaxFrameSize = 1 << 20
+
+// ErrFrameTooLarge is wrapped by the FrameError for a frame over the limit.
+var ErrFrameTooLarge = errors.New("message too large")
+
+// FrameError is a frame the decoder skipped, or one it gave up on.
+type FrameError struct {
+	Size  int64 // bytes in the frame
+	Err   error
+	Fatal bool // the frame was left on the wire, so nothing after it can be read
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("invalid message (%d bytes): %v", e.Size, e.Err)
+}
+
+// Code is the error code to answer the bad frame with.
+func (e *FrameError) Code() ErrorCode {
+	if errors.Is(e.Err, ErrFrameTooLarge) {
+		return ErrorCodeTooLarge
+	}
+	return ErrorCodeInvalidMessage
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+func tooLarge(size int64, max int) *FrameError {
+	return &FrameError{Size: size, Err: fmt.Errorf("%w, the limit is %d bytes", ErrFrameTooLarge, max)}
+}
+
+func frameLimit(maxSize int) int {
+	if maxSize <= 0 {
+		return DefaultMaxFrameSize
+	}
+	return maxSize
+}
+
+var (
+	// JSON is one JSON object per line, readable by every version.
+	JSON Codec = jsonCodec{}
+	
+	// Binary is a length-prefixed frame of tagged fields, which carries file
+	// data without base64 and skips field names.
+	Binary Codec = binaryCodec{}
+)
+
+var codecs = []Codec{Binary, JSON}
+
+// SupportedCodecs lists the codecs this build speaks, most preferred first.
+func SupportedCodecs() []string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = c.Name()
+	}
+	return names
+}
+
+// CodecByName finds a codec this build speaks.
+func CodecByName(name string) (Codec, bool) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// ChooseCodec picks the first codec in offered that is also in local. Peers
+// that predate codecs offer none and get JSON.
+func ChooseCodec(local, offered []string) Codec {
+	for _, name := range offered {
+		if !HasCapability(local, name) {
+			continue
+		}
+		if c, ok := CodecByName(name); ok {
+			return c
+		}
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) NewEncoder(w io.Writer) Encoder {
+	return jsonEncoder{json.NewEncoder(w)}
+}
+
+func (jsonCodec) NewDecoder(r *bufio.Reader, maxSize int) Decoder {
+	return &jsonDecoder{r: r, max: frameLimit(maxSize)}
+}
+
+type jsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e jsonEncoder) Encode(msg *Message) error {
+	return e.enc.Encode(msg)
+}
+
+// jsonDecoder reads a line at a time rather than using json.Decoder, which
+// buffers past the end of the message and would swallow the start of the
+// first frame in another codec.
+type jsonDecoder struct {
+	r    *bufio.Reader
+	max  int
+	line []byte
+}
+
+func (d *jsonDecoder) Decode(msg *Message) error {
+	for {
+		size, err := d.readLine()
+		if size > int64(d.max) {
+			if err != nil && err != io.EOF {
+				return err
+			}
+			return tooLarge(size, d.max)
+		}
+		if len(bytes.TrimSpace(d.line)) == 0 {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err := json.Unmarshal(d.line, msg); err != nil {
+			return &FrameError{Size: size, Err: err}
+		}
+		return nil
+	}
+}
+
+// readLine reads up to the next newline into d.line and returns the
+// line's length without it. Past d.max bytes the rest of the line is read
+// and thrown away.
+func (d *jsonDecoder) readLine() (int64, error) {
+	d.line = d.line[:0]
+	var size int64
+	for {
+		chunk, err := d.r.ReadSlice('\n')
+		size += int64(len(chunk))
+		if size <= int64(d.max)+1 {
+			d.line = append(d.line, chunk...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == nil {
+			size--
+		}
+		return size, err
+	}
+}
+
+// The binary codec frames each message as a 4-byte big-endian length and a
+// body of fields, each a varint key (field number << 3 | wire type)
+// followed by either a varint or a varint length and that many bytes.
+// Fields a reader does not know are skipped, so minor versions can add
+// them, and zero values are left out as they are in JSON.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// Field numbers in the binary codec. They must never be reused.
+const (
+	fieldType         = 1
+	fieldID           = 2
+	fieldSeq          = 3
+	fieldRef          = 4
+	fieldToken        = 5
+	fieldContent      = 6
+	fieldSessionID    = 7
+	fieldName         = 8
+	fieldUser         = 9
+	fieldVersion      = 10
+	fieldCapabilities = 11 // repeated
+	fieldFrom         = 12
+	fieldRoster       = 13 // repeated
+	fieldTo           = 14
+	fieldFile         = 15 // nested FileInfo
+	fieldOffset       = 16
+	fieldData         = 17
+	fieldTimestamp    = 18
+	fieldCodecs       = 19 // repeated
+	fieldCode         = 20
+	
+	fieldFileName   = 1
+	fieldFileSize   = 2
+	fieldFileSHA256 = 3
+)
+
+var errMalformed = errors.New("malformed binary frame")
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return "binary" }
+
+func (binaryCodec) NewEncoder(w io.Writer) Encoder {
+	return &binaryEncoder{w: w}
+}
+
+func (binaryCodec) NewDecoder(r *bufio.Reader, maxSize int) Decoder {
+	return &binaryDecoder{r: r, max: frameLimit(maxSize)}
+}
+
+type binaryEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (e *binaryEncoder) Encode(msg *Message) error {
+	frame := appendMessage(append(e.buf[:0], 0, 0, 0, 0), msg)
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	e.buf = frame
+	_, err := e.w.Write(frame)
+	return err
+}
+
+func appendMessage(b []byte, msg *Message) []byte {
+	b = appendString(b, fieldType, string(msg.Type))
+	b = appendString(b, fieldID, msg.ID)
+	b = appendVarint(b, fieldSeq, msg.Seq)
+	b = appendString(b, fieldRef, msg.Ref)
+	b = appendString(b, fieldToken, msg.Token)
+	b = appendString(b, fieldContent, msg.Content)
+	b = appendString(b, fieldSessionID, msg.SessionID)
+	b = appendString(b, fieldName, msg.Name)
+	b = appendString(b, fieldUser, msg.User)
+	b = appendString(b, fieldVersion, msg.Version)
+	for _, c := range msg.Capabilities {
+		b = appendField(b, fieldCapabilities, []byte(c))
+	}
+	b = appendString(b, fieldFrom, msg.From)
+	for _, name := range msg.Roster {
+		b = appendField(b, fieldRoster, []byte(name))
+	}
+	b = appendString(b, fieldTo, msg.To)
+	if msg.File != nil {
+		var file []byte
+		file = appendString(file, fieldFileName, msg.File.Name)
+		file = appendVarint(file, fieldFileSize, uint64(msg.File.Size))
+		file = appendString(file, fieldFileSHA256, msg.File.SHA256)
+		b = appendField(b, fieldFile, file)
+	}
+	b = appendVarint(b, fieldOffset, uint64(msg.Offset))
+	if len(msg.Data) > 0 {
+		b = appendField(b, fieldData, msg.Data)
+	}
+	b = appendVarint(b, fieldTimestamp, uint64(msg.Timestamp))
+	for _, name := range msg.Codecs {
+		b = appendField(b, fieldCodecs, []byte(name))
+	}
+	b = appendString(b, fieldCode, string(msg.Code))
+	return b
+}
+
+func appendVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendField(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+type binaryDecoder struct {
+	r   *bufio.Reader
+	max int
+	buf []byte
+	err *FrameError // a frame over the limit ends the stream
+}
+
+func (d *binaryDecoder) Decode(msg *Message) error {
+	if d.err != nil {
+		return d.err
+	}
+	
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		return err
+	}
+	n := int64(binary.BigEndian.Uint32(size[:]))
+	if n > int64(d.max) {
+		// Skipping it would mean reading up to 4 GiB the peer chose to send
+		d.err = tooLarge(n, d.max)
+		d.err.Fatal = true
+		return d.err
+	}
+	
+	if int64(cap(d.buf)) < n {
+		d.buf = make([]byte, n)
+	}
+	body := d.buf[:n]
+	if _, err := io.ReadFull(d.r, body); err != nil {
+		return noEOF(err)
+	}
+	
+	*msg = Message{}
+	if err := readMessage(body, msg); err != nil {
+		return &FrameError{Size: n, Err: err}
+	}
+	return nil
+}
+
+// noEOF reports a frame cut off part way as such.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readMessage fills msg from a frame body. Strings and data are copied, as
+// the body is reused for the next frame.
+func readMessage(b []byte, msg *Message) error {
+	return readFields(b, func(field uint64, v uint64, data []byte) error {
+		switch field {
+		case fieldType:
+			msg.Type = MessageType(data)
+		case fieldID:
+			msg.ID = string(data)
+		case fieldSeq:
+			msg.Seq = v
+		case fieldRef:
+			msg.Ref = string(data)
+		case fieldToken:
+			msg.Token = string(data)
+		case fieldContent:
+			msg.Content = string(data)
+		case fieldSessionID:
+			msg.SessionID = string(data)
+		case fieldName:
+			msg.Name = string(data)
+		case fieldUser:
+			msg.User = string(data)
+		case fieldVersion:
+			msg.Version = string(data)
+		case fieldCapabilities:
+			msg.Capabilities = append(msg.Capabilities, string(data))
+		case fieldFrom:
+			msg.From = string(data)
+		case fieldRoster:
+			msg.Roster = append(msg.Roster, string(data))
+		case fieldTo:
+			msg.To = string(data)
+		case fieldFile:
+			msg.File = &FileInfo{}
+			return readFileInfo(data, msg.File)
+		case fieldOffset:
+			msg.Offset = int64(v)
+		case fieldData:
+			msg.Data = append([]byte{}, data...)
+		case fieldTimestamp:
+			msg.Timestamp = int64(v)
+		case fieldCodecs:
+			msg.Codecs = append(msg.Codecs, string(data))
+		case fieldCode:
+			msg.Code = ErrorCode(data)
+		}
+		return nil
+	})
+}
+
+func readFileInfo(b []byte, info *FileInfo) error {
+	return readFields(b, func(field uint64, v uint64, data []byte) error {
+		switch field {
+		case fieldFileName:
+			info.Name = string(data)
+		case fieldFileSize:
+			info.Size = int64(v)
+		case fieldFileSHA256:
+			info.SHA256 = string(data)
+		}
+		return nil
+	})
+}
+
+// readFields calls set for each field in b with its number and either its
+// varint value or its bytes, depending on its wire type.
+func readFields(b []byte, set func(field uint64, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errMalformed
+		}
+		b = b[n:]
+		
+		var v uint64
+		var data []byte
+		switch key & 7 {
+		case wireVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errMalformed
+			}
+			b = b[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return errMalformed
+			}
+			data = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			return fmt.Errorf("%w: unknown wire type %d", errMalformed, key&7)
+		}
+		
+		if err := set(key>>3, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
\ No newline at end of file
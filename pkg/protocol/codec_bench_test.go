@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// benchMessages are what a session mostly carries: chat, and file chunks
+// once a transfer is running.
+func benchMessages() map[string]*Message {
+	return map[string]*Message{
+		"Text": {
+			Type:      MessageTypeText,
+			ID:        NewMessageID(),
+			Seq:       1234,
+			Content:   "This is a medium-sized message that represents typical chat content",
+			From:      "alice",
+			Timestamp: time.Now().UnixMilli(),
+		},
+		"FileChunk": {
+			Type:      MessageTypeFileChunk,
+			ID:        NewMessageID(),
+			Ref:       NewMessageID(),
+			To:        "bob",
+			Offset:    16 << 20,
+			Data:      bytes.Repeat([]byte{0xa5, 0x00, 0x5a, 0xff}, 4<<10),
+			Timestamp: time.Now().UnixMilli(),
+		},
+	}
+}
+
+func BenchmarkCodecEncode(b *testing.B) {
+	for _, codec := range []Codec{JSON, Binary} {
+		for name, msg := range benchMessages() {
+			b.Run(codec.Name()+"/"+name, func(b *testing.B) {
+				var wire countingWriter
+				enc := codec.NewEncoder(&wire)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					_ = enc.Encode(msg)
+				}
+				b.SetBytes(wire.n / int64(b.N))
+			})
+		}
+	}
+}
+
+func BenchmarkCodecDecode(b *testing.B) {
+	for _, codec := range []Codec{JSON, Binary} {
+		for name, msg := range benchMessages() {
+			b.Run(codec.Name()+"/"+name, func(b *testing.B) {
+				var frame bytes.Buffer
+				codec.NewEncoder(&frame).Encode(msg)
+				reader := bufio.NewReader(&repeatReader{frame: frame.Bytes()})
+				dec := codec.NewDecoder(reader, 0)
+				b.SetBytes(int64(frame.Len()))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					var decoded Message
+					_ = dec.Decode(&decoded)
+				}
+			})
+		}
+	}
+}
+
+// countingWriter discards what it is given but counts it, so the encode
+// benchmarks report the size on the wire.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// repeatReader serves the same frame forever.
+type repeatReader struct {
+	frame []byte
+	off   int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if len(r.frame) == 0 {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], r.frame[r.off:])
+		n += c
+		r.off = (r.off + c) % len(r.frame)
+	}
+	return n, nil
+}
\ No newline at end of file
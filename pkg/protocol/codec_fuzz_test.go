@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fuzzDecode feeds data to a decoder until it gives up. Whatever it manages
+// to decode must survive another round trip unchanged, and it must not
+// panic or accept anything over the limit.
+func fuzzDecode(t *testing.T, codec Codec, data []byte) {
+	const limit = 4 << 10
+	dec := codec.NewDecoder(bufio.NewReader(bytes.NewReader(data)), limit)
+	for {
+		var msg Message
+		err := dec.Decode(&msg)
+		var frameErr *FrameError
+		if errors.As(err, &frameErr) && !frameErr.Fatal {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		
+		var wire bytes.Buffer
+		if err := codec.NewEncoder(&wire).Encode(&msg); err != nil {
+			t.Fatalf("Re-encoding %+v failed: %v", msg, err)
+		}
+		var again Message
+		if err := codec.NewDecoder(bufio.NewReader(&wire), 0).Decode(&again); err != nil {
+			t.Fatalf("Decoding %q again failed: %v", wire.Bytes(), err)
+		}
+		if !reflect.DeepEqual(normalized(msg), normalized(again)) {
+			t.Fatalf("Round trip changed %+v into %+v", msg, again)
+		}
+	}
+}
+
+// normalized treats empty and missing lists alike, as omitempty does.
+func normalized(msg Message) Message {
+	if len(msg.Capabilities) == 0 {
+		msg.Capabilities = nil
+	}
+	if len(msg.Codecs) == 0 {
+		msg.Codecs = nil
+	}
+	if len(msg.Roster) == 0 {
+		msg.Roster = nil
+	}
+	if len(msg.Data) == 0 {
+		msg.Data = nil
+	}
+	return msg
+}
+
+func fuzzSeeds(f *testing.F, codec Codec) {
+	var wire bytes.Buffer
+	enc := codec.NewEncoder(&wire)
+	enc.Encode(fullMessage())
+	enc.Encode(NewMessage(MessageTypeText, "Hi"))
+	f.Add(wire.Bytes())
+	f.Add([]byte{})
+}
+
+func FuzzJSONDecode(f *testing.F) {
+	fuzzSeeds(f, JSON)
+	f.Add([]byte("{\"type\":\"text\",\"data\":\"!!\"}\n{}\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode(t, JSON, data)
+	})
+}
+
+func FuzzBinaryDecode(f *testing.F) {
+	fuzzSeeds(f, Binary)
+	f.Add([]byte{0, 0, 0, 3, 2<<3 | wireBytes, 50, 'x'})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzDecode(t, Binary, data)
+	})
+}
\ No newline at end of file
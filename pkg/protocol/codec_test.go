@@ -0,0 +1,197 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fullMessage sets every field, so a codec that drops one is caught.
+func fullMessage() *Message {
+	return &Message{
+		Type:         MessageTypeFileOffer,
+		ID:           "9f2c4e1a7b3d5f60",
+		Seq:          42,
+		Ref:          "0011223344556677",
+		Token:        "resume-token",
+		Content:      "héllo\nworld",
+		SessionID:    "cosmic-turtle-1234",
+		Name:         "alice",
+		User:         "alice-ssh",
+		Version:      ProtocolVersion,
+		Capabilities: []string{CapTyping, CapResume},
+		Codecs:       []string{"binary", "json"},
+		From:         "bob",
+		Roster:       []string{"sam", "alice", "bob"},
+		To:           "carol",
+		File:         &FileInfo{Name: "notes.txt", Size: 48213, SHA256: "3a7bd3e2"},
+		Offset:       1 << 40,
+		Data:         []byte{0, 1, 2, 0xff, '\n'},
+		Timestamp:    1234567890123,
+		Code:         ErrorCodeTooLarge,
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSON, Binary} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			sent := []*Message{fullMessage(), NewMessage(MessageTypeText, "Hi"), {Type: MessageTypePing}}
+			
+			var wire bytes.Buffer
+			enc := codec.NewEncoder(&wire)
+			for _, msg := range sent {
+				if err := enc.Encode(msg); err != nil {
+					t.Fatalf("Encode failed: %v", err)
+				}
+			}
+			
+			dec := codec.NewDecoder(bufio.NewReader(&wire), 0)
+			for _, want := range sent {
+				var got Message
+				if err := dec.Decode(&got); err != nil {
+					t.Fatalf("Decode failed: %v", err)
+				}
+				if !reflect.DeepEqual(&got, want) {
+					t.Errorf("Got %+v, want %+v", got, *want)
+				}
+			}
+			
+			var extra Message
+			if err := dec.Decode(&extra); err != io.EOF {
+				t.Errorf("Expected EOF after the last message, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCodecSwitch(t *testing.T) {
+	// The host reads HELLO in JSON, then everything after WELCOME in binary
+	var wire bytes.Buffer
+	JSON.NewEncoder(&wire).Encode(NewHandshakeMessage(MessageTypeHello, "s"))
+	Binary.NewEncoder(&wire).Encode(NewMessage(MessageTypeReady, ""))
+	
+	reader := bufio.NewReader(&wire)
+	var hello, ready Message
+	if err := JSON.NewDecoder(reader, 0).Decode(&hello); err != nil || hello.Type != MessageTypeHello {
+		t.Fatalf("Expected HELLO, got %+v (%v)", hello, err)
+	}
+	if err := Binary.NewDecoder(reader, 0).Decode(&ready); err != nil || ready.Type != MessageTypeReady {
+		t.Fatalf("Expected READY, got %+v (%v)", ready, err)
+	}
+}
+
+func TestBinarySkipsUnknownFields(t *testing.T) {
+	body := appendMessage(nil, NewMessage(MessageTypeText, "hi"))
+	body = appendVarint(body, 100, 7)
+	body = appendString(body, 101, "from a later version")
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	
+	var msg Message
+	if err := Binary.NewDecoder(bufio.NewReader(bytes.NewReader(append(frame, body...))), 0).Decode(&msg); err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if msg.Content != "hi" {
+		t.Errorf("Expected the known fields, got %+v", msg)
+	}
+}
+
+func TestBinaryMalformed(t *testing.T) {
+	var frame bytes.Buffer
+	Binary.NewEncoder(&frame).Encode(fullMessage())
+	whole := frame.Bytes()
+	
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"cut in the length", whole[:2], io.ErrUnexpectedEOF},
+		{"cut in the body", whole[:len(whole)-3], io.ErrUnexpectedEOF},
+		{"length past the end", []byte{0, 0, 0, 3, 2<<3 | wireBytes, 50, 'x'}, errMalformed},
+		{"unknown wire type", []byte{0, 0, 0, 2, 1<<3 | 5, 0}, errMalformed},
+		{"truncated varint", []byte{0, 0, 0, 2, 3 << 3, 0x80}, errMalformed},
+	}
+	for _, tt := range tests {
+		var msg Message
+		err := Binary.NewDecoder(bufio.NewReader(bytes.NewReader(tt.data)), 0).Decode(&msg)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestFrameLimit(t *testing.T) {
+	for _, codec := range []Codec{JSON, Binary} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			var wire bytes.Buffer
+			enc := codec.NewEncoder(&wire)
+			enc.Encode(NewMessage(MessageTypeText, strings.Repeat("x", 20000)))
+			enc.Encode(NewMessage(MessageTypeText, "fits"))
+			
+			dec := codec.NewDecoder(bufio.NewReader(&wire), 1000)
+			var msg Message
+			err := dec.Decode(&msg)
+			var frameErr *FrameError
+			if !errors.As(err, &frameErr) || !errors.Is(err, ErrFrameTooLarge) || frameErr.Size <= 20000 {
+				t.Fatalf("Expected the large message to be refused, got %v", err)
+			}
+			
+			if codec == Binary {
+				// The length is enough to refuse it; the rest is not read
+				if !frameErr.Fatal || wire.Len() == 0 {
+					t.Errorf("Expected a fatal error with the frame left unread, got %v", err)
+				}
+				if err := dec.Decode(&msg); err != frameErr {
+					t.Errorf("Expected the stream to stay broken, got %+v (%v)", msg, err)
+				}
+				return
+			}
+			
+			// A line is read to its end, so the stream carries on after it
+			if frameErr.Fatal {
+				t.Error("Expected a JSON line to be skipped")
+			}
+			if err := dec.Decode(&msg); err != nil || msg.Content != "fits" {
+				t.Errorf("Expected the next message, got %+v (%v)", msg, err)
+			}
+		})
+	}
+}
+
+func TestJSONMalformedSkipped(t *testing.T) {
+	wire := strings.NewReader("{\"type\": \"text\", \"content\": \n\n{\"type\":\"text\",\"content\":\"ok\"}\n")
+	dec := JSON.NewDecoder(bufio.NewReader(wire), 0)
+	
+	var msg Message
+	var frameErr *FrameError
+	if err := dec.Decode(&msg); !errors.As(err, &frameErr) {
+		t.Fatalf("Expected a FrameError, got %v", err)
+	}
+	msg = Message{}
+	if err := dec.Decode(&msg); err != nil || msg.Content != "ok" {
+		t.Errorf("Expected the next message, got %+v (%v)", msg, err)
+	}
+}
+
+func TestChooseCodec(t *testing.T) {
+	tests := []struct {
+		local, offered []string
+		want           string
+	}{
+		{SupportedCodecs(), []string{"binary", "json"}, "binary"},
+		{SupportedCodecs(), []string{"json", "binary"}, "json"},
+		{[]string{"json"}, []string{"binary", "json"}, "json"},
+		{SupportedCodecs(), []string{"cbor", "binary"}, "binary"},
+		{SupportedCodecs(), nil, "json"},
+	}
+	for _, tt := range tests {
+		if got := ChooseCodec(tt.local, tt.offered).Name(); got != tt.want {
+			t.Errorf("ChooseCodec(%v, %v) = %s, want %s", tt.local, tt.offered, got, tt.want)
+		}
+	}
+}
\ No newline at end of file
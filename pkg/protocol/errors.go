@@ -0,0 +1,50 @@
+package protocol
+
+// ErrorCode says why an ERROR was sent. Content is for people and may change
+// between releases; the code is what a program should act on.
+type ErrorCode string
+
+const (
+	ErrorCodeSessionMismatch    ErrorCode = "SESSION_MISMATCH"
+	ErrorCodeVersionUnsupported ErrorCode = "VERSION_UNSUPPORTED"
+	ErrorCodeRateLimited        ErrorCode = "RATE_LIMITED"
+	ErrorCodeSessionFull        ErrorCode = "SESSION_FULL"
+	ErrorCodeRejected           ErrorCode = "REJECTED"
+	ErrorCodeTooLarge           ErrorCode = "TOO_LARGE"
+	ErrorCodeInvalidMessage     ErrorCode = "INVALID_MESSAGE"
+	ErrorCodeSessionExpired     ErrorCode = "SESSION_EXPIRED"
+	ErrorCodeInvalidName        ErrorCode = "INVALID_NAME"
+)
+
+// errorCodes is the registry of codes a peer may send. A code is never
+// reused for a different meaning once released.
+var errorCodes = map[ErrorCode]string{
+	ErrorCodeSessionMismatch:    "the joiner does not know the session ID",
+	ErrorCodeVersionUnsupported: "the peers speak different major protocol versions",
+	ErrorCodeRateLimited:        "too many failed attempts from this address",
+	ErrorCodeSessionFull:        "the session has no free seat",
+	ErrorCodeRejected:           "the host turned the joiner away",
+	ErrorCodeTooLarge:           "a message was larger than the receiver accepts",
+	ErrorCodeInvalidMessage:     "a message could not be parsed or was not expected",
+	ErrorCodeSessionExpired:     "the seat to resume is no longer held",
+	ErrorCodeInvalidName:        "a requested display name was refused",
+}
+
+// Known reports whether c is in the registry. Codes from a newer peer are
+// not, and should be treated as a generic error.
+func (c ErrorCode) Known() bool {
+	_, ok := errorCodes[c]
+	return ok
+}
+
+// Description explains c in a few words, or returns "" for an unknown code.
+func (c ErrorCode) Description() string {
+	return errorCodes[c]
+}
+
+// NewError builds an ERROR with the given code and a human-readable content.
+func NewError(code ErrorCode, content string) *Message {
+	msg := NewMessage(MessageTypeError, content)
+	msg.Code = code
+	return msg
+}
\ No newline at end of file
@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewError(t *testing.T) {
+	msg := NewError(ErrorCodeSessionFull, "Session is full")
+	if msg.Type != MessageTypeError || msg.Code != ErrorCodeSessionFull || msg.Content != "Session is full" {
+		t.Fatalf("Unexpected error message %+v", msg)
+	}
+	
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"code":"SESSION_FULL"`) {
+		t.Errorf("Expected the code on the wire, got %s", data)
+	}
+	
+	// Other messages leave it out
+	data, _ = json.Marshal(NewMessage(MessageTypeText, "hi"))
+	if strings.Contains(string(data), "code") {
+		t.Errorf("Expected no code on a TEXT message, got %s", data)
+	}
+}
+
+func TestErrorCodeRegistry(t *testing.T) {
+	for code := range errorCodes {
+		if code.Description() == "" {
+			t.Errorf("%s has no description", code)
+		}
+		if strings.ToUpper(string(code)) != string(code) {
+			t.Errorf("%s should be upper case", code)
+		}
+	}
+	
+	if ErrorCode("FROM_THE_FUTURE").Known() {
+		t.Error("An unregistered code should not be known")
+	}
+	if !ErrorCodeRateLimited.Known() {
+		t.Error("RATE_LIMITED should be known")
+	}
+}
\ No newline at end of file
@@ -1,6 +1,10 @@
 package protocol
 
-import "time"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
 
 type MessageType string
 
@@ -13,18 +17,103 @@ const (
 	MessageTypePong    MessageType = "pong"
 	MessageTypeLeave   MessageType = "leave"
 	MessageTypeError   MessageType = "error"
+	
+	// Group session events, sent by the host to every participant
+	MessageTypeJoined MessageType = "joined"
+	MessageTypeLeft   MessageType = "left"
+	MessageTypeRoster MessageType = "roster"
+	MessageTypeNick   MessageType = "nick"
+	MessageTypeAway   MessageType = "away" // lost connection, may resume
+	MessageTypeBack   MessageType = "back" // resumed after being away
+	
+	// Content is "true" when the sender starts typing and "false" when it stops
+	MessageTypeTyping MessageType = "typing"
+	
+	// Receipts for a TEXT message, whose ID is carried in Ref
+	MessageTypeAck  MessageType = "ack"
+	MessageTypeRead MessageType = "read"
+	
+	// File transfer, addressed with To; every message after the offer
+	// carries the offer's ID in Ref
+	MessageTypeFileOffer    MessageType = "file_offer"
+	MessageTypeFileAccept   MessageType = "file_accept"   // send from Offset
+	MessageTypeFileChunk    MessageType = "file_chunk"    // Data at Offset
+	MessageTypeFileAck      MessageType = "file_ack"      // received up to Offset
+	MessageTypeFileComplete MessageType = "file_complete" // received and verified
+	MessageTypeFileCancel   MessageType = "file_cancel"   // declined or aborted
 )
 
+// IsFileTransfer reports whether t belongs to a file transfer.
+func IsFileTransfer(t MessageType) bool {
+	switch t {
+	case MessageTypeFileOffer, MessageTypeFileAccept, MessageTypeFileChunk,
+		MessageTypeFileAck, MessageTypeFileComplete, MessageTypeFileCancel:
+		return true
+	}
+	return false
+}
+
+// FileInfo describes the file in a FILE_OFFER.
+type FileInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"` // hex
+}
+
+// DeliveryStatus is what the sender knows about a TEXT message it sent. The
+// values are ordered so that a late report never downgrades an earlier one.
+type DeliveryStatus int
+
+const (
+	StatusNone DeliveryStatus = iota // receipts not in use
+	StatusSending
+	StatusFailed
+	StatusDelivered
+	StatusRead
+)
+
+func (s DeliveryStatus) String() string {
+	switch s {
+	case StatusSending:
+		return "sending"
+	case StatusFailed:
+		return "failed"
+	case StatusDelivered:
+		return "delivered"
+	case StatusRead:
+		return "read"
+	default:
+		return ""
+	}
+}
+
 type Message struct {
-	Type      MessageType `json:"type"`
-	Content   string      `json:"content,omitempty"`
-	SessionID string      `json:"session_id,omitempty"`
-	Timestamp int64       `json:"timestamp"`
+	Type         MessageType `json:"type"`
+	ID           string      `json:"id,omitempty"`
+	Seq          uint64      `json:"seq,omitempty"`
+	Ref          string      `json:"ref,omitempty"`
+	Token        string      `json:"token,omitempty"`
+	Content      string      `json:"content,omitempty"`
+	SessionID    string      `json:"session_id,omitempty"`
+	Name         string      `json:"name,omitempty"`
+	User         string      `json:"user,omitempty"`
+	Version      string      `json:"version,omitempty"`
+	Capabilities []string    `json:"capabilities,omitempty"`
+	Codecs       []string    `json:"codecs,omitempty"` // offered in HELLO, the one picked in WELCOME
+	Code         ErrorCode   `json:"code,omitempty"`   // ERROR only
+	From         string      `json:"from,omitempty"`
+	Roster       []string    `json:"roster,omitempty"`
+	To           string      `json:"to,omitempty"`
+	File         *FileInfo   `json:"file,omitempty"`
+	Offset       int64       `json:"offset,omitempty"`
+	Data         []byte      `json:"data,omitempty"`
+	Timestamp    int64       `json:"timestamp"`
 }
 
 func NewMessage(msgType MessageType, content string) *Message {
 	return &Message{
 		Type:      msgType,
+		ID:        NewMessageID(),
 		Content:   content,
 		Timestamp: time.Now().UnixMilli(),
 	}
@@ -33,7 +122,25 @@ func NewMessage(msgType MessageType, content string) *Message {
 func NewHandshakeMessage(msgType MessageType, sessionID string) *Message {
 	return &Message{
 		Type:      msgType,
+		ID:        NewMessageID(),
 		SessionID: sessionID,
+		Version:   ProtocolVersion,
 		Timestamp: time.Now().UnixMilli(),
 	}
+}
+
+// NewReceipt acknowledges the message with the given ID. msgType is
+// MessageTypeAck or MessageTypeRead.
+func NewReceipt(msgType MessageType, id string) *Message {
+	msg := NewMessage(msgType, "")
+	msg.Ref = id
+	return msg
+}
+
+// NewMessageID returns a random 64-bit identifier in hex. Sequence numbers
+// are assigned per connection when a message is sent.
+func NewMessageID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
 }
\ No newline at end of file
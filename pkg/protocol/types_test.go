@@ -150,4 +150,23 @@ func containsHelper(s, substr string) bool {
 		}
 	}
 	return false
+}
+
+func TestMessageIDs(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := NewMessage(MessageTypeText, "hi").ID
+		if len(id) != 16 {
+			t.Fatalf("Expected a 16 character ID, got %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("Duplicate message ID %s", id)
+		}
+		seen[id] = true
+	}
+	
+	receipt := NewReceipt(MessageTypeAck, "abc")
+	if receipt.Ref != "abc" || receipt.ID == "" {
+		t.Errorf("Unexpected receipt %+v", receipt)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,89 @@
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProtocolVersion is the wire protocol spoken by this build, as major.minor.
+// Peers with a different major version cannot talk to each other; minor
+// versions only add optional features, which are gated by capabilities.
+// 2.0 runs every connection inside an encrypted Noise channel.
+const ProtocolVersion = "2.0"
+
+// Optional features a peer can advertise in HELLO. The host answers in
+// WELCOME with the subset both sides support.
+const (
+	CapTyping       = "typing"
+	CapReceipts     = "receipts"
+	CapFileTransfer = "file-transfer"
+	CapResume       = "resume"
+)
+
+// SupportedCapabilities lists the optional features this build implements.
+func SupportedCapabilities() []string {
+	return []string{CapTyping, CapReceipts, CapResume, CapFileTransfer}
+}
+
+// VersionError is returned when the peer speaks an incompatible protocol.
+type VersionError struct {
+	Local  string
+	Remote string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("incompatible protocol version: peer speaks %s, we speak %s", e.Remote, e.Local)
+}
+
+// CheckVersion accepts any remote version with our major number. Peers that
+// predate versioning send none and are treated as 1.0.
+func CheckVersion(remote string) error {
+	if remote == "" {
+		remote = "1.0"
+	}
+	
+	remoteMajor, err := majorVersion(remote)
+	if err != nil {
+		return &VersionError{Local: ProtocolVersion, Remote: remote}
+	}
+	
+	localMajor, _ := majorVersion(ProtocolVersion)
+	if remoteMajor != localMajor {
+		return &VersionError{Local: ProtocolVersion, Remote: remote}
+	}
+	return nil
+}
+
+func majorVersion(version string) (int, error) {
+	major, _, _ := strings.Cut(version, ".")
+	return strconv.Atoi(major)
+}
+
+// NegotiateCapabilities returns the capabilities present in both lists, in
+// the order of local.
+func NegotiateCapabilities(local, remote []string) []string {
+	offered := make(map[string]bool, len(remote))
+	for _, c := range remote {
+		offered[c] = true
+	}
+	
+	common := []string{}
+	for _, c := range local {
+		if offered[c] {
+			common = append(common, c)
+			delete(offered, c)
+		}
+	}
+	return common
+}
+
+// HasCapability reports whether name is in caps.
+func HasCapability(caps []string, name string) bool {
+	for _, c := range caps {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
\ No newline at end of file
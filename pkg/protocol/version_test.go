@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		remote  string
+		wantErr bool
+	}{
+		{"2.0", false},
+		{"2.7", false},
+		{"1.0", true}, // plaintext, before encryption
+		{"", true},    // peers from before versioning
+		{"3.0", true},
+		{"banana", true},
+	}
+	
+	for _, tt := range tests {
+		err := CheckVersion(tt.remote)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckVersion(%q) = %v, wantErr %v", tt.remote, err, tt.wantErr)
+		}
+		
+		var versionErr *VersionError
+		if err != nil && !errors.As(err, &versionErr) {
+			t.Errorf("CheckVersion(%q) should return a VersionError, got %T", tt.remote, err)
+		}
+	}
+}
+
+func TestNegotiateCapabilities(t *testing.T) {
+	local := []string{CapTyping, CapReceipts, CapFileTransfer}
+	remote := []string{CapFileTransfer, "future-thing", CapTyping, CapTyping}
+	
+	got := NegotiateCapabilities(local, remote)
+	want := []string{CapTyping, CapFileTransfer}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("NegotiateCapabilities = %v, want %v", got, want)
+	}
+	
+	if got := NegotiateCapabilities(local, nil); len(got) != 0 {
+		t.Errorf("Expected no capabilities with an old peer, got %v", got)
+	}
+}
+
+func TestHandshakeCarriesVersion(t *testing.T) {
+	msg := NewHandshakeMessage(MessageTypeHello, "s")
+	if msg.Version != ProtocolVersion {
+		t.Errorf("Expected version %s, got %q", ProtocolVersion, msg.Version)
+	}
+}
\ No newline at end of file